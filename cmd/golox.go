@@ -1,19 +1,149 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/compiler"
 	"github.com/mtvarkovsky/golox/pkg/lox"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 func main() {
-	inst := lox.TreeWalkInterpreter{}
-	if len(os.Args) > 2 {
-		fmt.Println("Usage: golox [script]")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "disasm":
+			disasm(os.Args[2:])
+			return
+		case "compile":
+			compile(os.Args[2:])
+			return
+		}
+	}
+
+	backend := flag.String("backend", "tree", "interpreter backend: tree, closure or vm")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintln(os.Stderr, "Usage: golox [-backend tree|closure|vm] [script.lox|script.loxc]")
+		_, _ = fmt.Fprintln(os.Stderr, "       golox compile [-o script.loxc] script.lox")
+		_, _ = fmt.Fprintln(os.Stderr, "       golox disasm [-trace] script.lox")
+	}
+	flag.Parse()
+
+	// compiled scripts only run on the virtual machine
+	if filepath.Ext(flag.Arg(0)) == lox.CompiledExt {
+		*backend = "vm"
+	}
+
+	var inst lox.Interpreter
+	switch *backend {
+	case "tree":
+		inst = lox.NewTreeWalkInterpreter(lox.Config{})
+	case "closure":
+		inst = lox.NewTreeWalkInterpreter(lox.Config{CompileClosures: true})
+	case "vm":
+		inst = lox.NewBytecodeInterpreter(lox.Config{})
+	default:
+		flag.Usage()
+		os.Exit(64)
+	}
+
+	if flag.NArg() > 1 {
+		flag.Usage()
 		os.Exit(64)
-	} else if len(os.Args) == 2 {
-		inst.RunFile(os.Args[1])
+	} else if flag.NArg() == 1 {
+		result, err := inst.RunFile(flag.Arg(0))
+		if errors.Is(err, compiler.ErrInvalidBytecode) {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(65)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(66)
+		}
+		exit(result)
 	} else {
-		inst.RunPrompt()
+		if err := inst.RunPrompt(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(74)
+		}
+	}
+}
+
+// disasm prints the bytecode of a script, with -trace it also runs the script and traces every instruction
+func disasm(args []string) {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	trace := flags.Bool("trace", false, "run the script and dump the VM stack before each instruction")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(os.Stderr, "Usage: golox disasm [-trace] script")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(64)
+	}
+
+	source, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(66)
+	}
+
+	config := lox.Config{}
+	if *trace {
+		config.Trace = os.Stdout
+	}
+	inst := lox.NewBytecodeInterpreter(config)
+
+	result := inst.Disassemble(string(source), os.Stdout)
+	if result.Success && *trace {
+		fmt.Println()
+		result = inst.Run(string(source))
+	}
+	exit(result)
+}
+
+// compile writes the bytecode of a script to a .loxc file next to it, or to the file given with -o
+func compile(args []string) {
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	output := flags.String("o", "", "output file, defaults to the script path with the "+lox.CompiledExt+" extension")
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(os.Stderr, "Usage: golox compile [-o script.loxc] script.lox")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(64)
+	}
+
+	path := flags.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(path, filepath.Ext(path)) + lox.CompiledExt
+	}
+
+	buf := &bytes.Buffer{}
+	result, err := lox.NewBytecodeInterpreter(lox.Config{}).CompileFile(path, buf)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(66)
+	}
+	exit(result)
+
+	if err = os.WriteFile(*output, buf.Bytes(), 0o644); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(73)
+	}
+}
+
+func exit(result lox.Result) {
+	if result.HadError() {
+		os.Exit(65)
+	}
+	if result.HadRuntimeError() {
+		os.Exit(70)
 	}
 }
@@ -2,11 +2,13 @@ module github.com/mtvarkovsky/golox
 
 go 1.19
 
-require github.com/stretchr/testify v1.8.1
+require (
+	github.com/gobeam/stringy v0.0.6
+	github.com/stretchr/testify v1.8.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/gobeam/stringy v0.0.6 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -15,11 +15,20 @@ type ExpressionType int
 const (
 	AssignmentExpressionType ExpressionType = iota
 	BinaryExpressionType
+	CallExpressionType
 	UnaryExpressionType
 	VariableExpressionType
+	GetExpressionType
+	SetExpressionType
+	ThisExpressionType
+	SuperExpressionType
 	LogicalExpressionType
 	LiteralExpressionType
 	GroupingExpressionType
+	ListExpressionType
+	SubscriptExpressionType
+	SubscriptSetExpressionType
+	MapLiteralExpressionType
 )
 
 type Assignment interface {
@@ -101,6 +110,49 @@ func (e *binary) Type() ExpressionType {
 }
 
 
+type Call interface {
+	Expression
+	Callee() Expression
+	Paren() tokens.Token
+	Arguments() []Expression
+}
+
+type call struct {
+	callee Expression
+	paren tokens.Token
+	arguments []Expression
+}
+
+var _ Call = (*call)(nil)
+
+func NewCall(callee Expression, paren tokens.Token, arguments []Expression) Call {
+	return &call{
+		callee: callee,
+		paren: paren,
+		arguments: arguments,
+	}
+}
+
+func (e *call) Accept(visitor ExpressionVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *call) Callee() Expression {
+	return e.callee
+}
+
+func (e *call) Paren() tokens.Token {
+	return e.paren
+}
+
+func (e *call) Arguments() []Expression {
+	return e.arguments
+}
+
+func (e *call) Type() ExpressionType {
+	return CallExpressionType
+}
+
+
 type Unary interface {
 	Expression
 	Operator() tokens.Token
@@ -166,6 +218,150 @@ func (e *variable) Type() ExpressionType {
 }
 
 
+type Get interface {
+	Expression
+	Object() Expression
+	Name() tokens.Token
+}
+
+type get struct {
+	object Expression
+	name tokens.Token
+}
+
+var _ Get = (*get)(nil)
+
+func NewGet(object Expression, name tokens.Token) Get {
+	return &get{
+		object: object,
+		name: name,
+	}
+}
+
+func (e *get) Accept(visitor ExpressionVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *get) Object() Expression {
+	return e.object
+}
+
+func (e *get) Name() tokens.Token {
+	return e.name
+}
+
+func (e *get) Type() ExpressionType {
+	return GetExpressionType
+}
+
+
+type Set interface {
+	Expression
+	Object() Expression
+	Name() tokens.Token
+	Value() Expression
+}
+
+type set struct {
+	object Expression
+	name tokens.Token
+	value Expression
+}
+
+var _ Set = (*set)(nil)
+
+func NewSet(object Expression, name tokens.Token, value Expression) Set {
+	return &set{
+		object: object,
+		name: name,
+		value: value,
+	}
+}
+
+func (e *set) Accept(visitor ExpressionVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *set) Object() Expression {
+	return e.object
+}
+
+func (e *set) Name() tokens.Token {
+	return e.name
+}
+
+func (e *set) Value() Expression {
+	return e.value
+}
+
+func (e *set) Type() ExpressionType {
+	return SetExpressionType
+}
+
+
+type This interface {
+	Expression
+	Keyword() tokens.Token
+}
+
+type this struct {
+	keyword tokens.Token
+}
+
+var _ This = (*this)(nil)
+
+func NewThis(keyword tokens.Token) This {
+	return &this{
+		keyword: keyword,
+	}
+}
+
+func (e *this) Accept(visitor ExpressionVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *this) Keyword() tokens.Token {
+	return e.keyword
+}
+
+func (e *this) Type() ExpressionType {
+	return ThisExpressionType
+}
+
+
+type Super interface {
+	Expression
+	Keyword() tokens.Token
+	Method() tokens.Token
+}
+
+type super struct {
+	keyword tokens.Token
+	method tokens.Token
+}
+
+var _ Super = (*super)(nil)
+
+func NewSuper(keyword tokens.Token, method tokens.Token) Super {
+	return &super{
+		keyword: keyword,
+		method: method,
+	}
+}
+
+func (e *super) Accept(visitor ExpressionVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *super) Keyword() tokens.Token {
+	return e.keyword
+}
+
+func (e *super) Method() tokens.Token {
+	return e.method
+}
+
+func (e *super) Type() ExpressionType {
+	return SuperExpressionType
+}
+
+
 type Logical interface {
 	Expression
 	Left() Expression
@@ -266,6 +462,178 @@ func (e *grouping) Type() ExpressionType {
 	return GroupingExpressionType
 }
 
+
+type List interface {
+	Expression
+	Bracket() tokens.Token
+	Elements() []Expression
+}
+
+type list struct {
+	bracket tokens.Token
+	elements []Expression
+}
+
+var _ List = (*list)(nil)
+
+func NewList(bracket tokens.Token, elements []Expression) List {
+	return &list{
+		bracket: bracket,
+		elements: elements,
+	}
+}
+
+func (e *list) Accept(visitor ExpressionVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *list) Bracket() tokens.Token {
+	return e.bracket
+}
+
+func (e *list) Elements() []Expression {
+	return e.elements
+}
+
+func (e *list) Type() ExpressionType {
+	return ListExpressionType
+}
+
+
+type Subscript interface {
+	Expression
+	Object() Expression
+	Bracket() tokens.Token
+	Index() Expression
+}
+
+type subscript struct {
+	object Expression
+	bracket tokens.Token
+	index Expression
+}
+
+var _ Subscript = (*subscript)(nil)
+
+func NewSubscript(object Expression, bracket tokens.Token, index Expression) Subscript {
+	return &subscript{
+		object: object,
+		bracket: bracket,
+		index: index,
+	}
+}
+
+func (e *subscript) Accept(visitor ExpressionVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *subscript) Object() Expression {
+	return e.object
+}
+
+func (e *subscript) Bracket() tokens.Token {
+	return e.bracket
+}
+
+func (e *subscript) Index() Expression {
+	return e.index
+}
+
+func (e *subscript) Type() ExpressionType {
+	return SubscriptExpressionType
+}
+
+
+type SubscriptSet interface {
+	Expression
+	Object() Expression
+	Bracket() tokens.Token
+	Index() Expression
+	Value() Expression
+}
+
+type subscriptSet struct {
+	object Expression
+	bracket tokens.Token
+	index Expression
+	value Expression
+}
+
+var _ SubscriptSet = (*subscriptSet)(nil)
+
+func NewSubscriptSet(object Expression, bracket tokens.Token, index Expression, value Expression) SubscriptSet {
+	return &subscriptSet{
+		object: object,
+		bracket: bracket,
+		index: index,
+		value: value,
+	}
+}
+
+func (e *subscriptSet) Accept(visitor ExpressionVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *subscriptSet) Object() Expression {
+	return e.object
+}
+
+func (e *subscriptSet) Bracket() tokens.Token {
+	return e.bracket
+}
+
+func (e *subscriptSet) Index() Expression {
+	return e.index
+}
+
+func (e *subscriptSet) Value() Expression {
+	return e.value
+}
+
+func (e *subscriptSet) Type() ExpressionType {
+	return SubscriptSetExpressionType
+}
+
+
+type MapLiteral interface {
+	Expression
+	Brace() tokens.Token
+	Keys() []Expression
+	Values() []Expression
+}
+
+type mapLiteral struct {
+	brace tokens.Token
+	keys []Expression
+	values []Expression
+}
+
+var _ MapLiteral = (*mapLiteral)(nil)
+
+func NewMapLiteral(brace tokens.Token, keys []Expression, values []Expression) MapLiteral {
+	return &mapLiteral{
+		brace: brace,
+		keys: keys,
+		values: values,
+	}
+}
+
+func (e *mapLiteral) Accept(visitor ExpressionVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *mapLiteral) Brace() tokens.Token {
+	return e.brace
+}
+
+func (e *mapLiteral) Keys() []Expression {
+	return e.keys
+}
+
+func (e *mapLiteral) Values() []Expression {
+	return e.values
+}
+
+func (e *mapLiteral) Type() ExpressionType {
+	return MapLiteralExpressionType
+}
+
 type Statement interface {
 	Accept(visitor StatementVisitor) (any, error)
 	Type() StatementType
@@ -276,11 +644,19 @@ type StatementType int
 
 const (
 	BlockStatementStatementType StatementType = iota
+	ClassStatementStatementType
 	ExpressionStatementStatementType
+	FunctionStatementStatementType
 	IfStatementStatementType
 	PrintStatementStatementType
+	ReturnStatementStatementType
 	VarStatementStatementType
 	WhileStatementStatementType
+	ForInStatementStatementType
+	BreakStatementStatementType
+	ContinueStatementStatementType
+	ThrowStatementStatementType
+	TryStatementStatementType
 )
 
 type BlockStatement interface {
@@ -312,6 +688,49 @@ func (e *blockStatement) Type() StatementType {
 }
 
 
+type ClassStatement interface {
+	Statement
+	Name() tokens.Token
+	Superclass() Variable
+	Methods() []FunctionStatement
+}
+
+type classStatement struct {
+	name tokens.Token
+	superclass Variable
+	methods []FunctionStatement
+}
+
+var _ ClassStatement = (*classStatement)(nil)
+
+func NewClassStatement(name tokens.Token, superclass Variable, methods []FunctionStatement) ClassStatement {
+	return &classStatement{
+		name: name,
+		superclass: superclass,
+		methods: methods,
+	}
+}
+
+func (e *classStatement) Accept(visitor StatementVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *classStatement) Name() tokens.Token {
+	return e.name
+}
+
+func (e *classStatement) Superclass() Variable {
+	return e.superclass
+}
+
+func (e *classStatement) Methods() []FunctionStatement {
+	return e.methods
+}
+
+func (e *classStatement) Type() StatementType {
+	return ClassStatementStatementType
+}
+
+
 type ExpressionStatement interface {
 	Statement
 	Expression() Expression
@@ -341,6 +760,49 @@ func (e *expressionStatement) Type() StatementType {
 }
 
 
+type FunctionStatement interface {
+	Statement
+	Name() tokens.Token
+	Params() []tokens.Token
+	Body() []Statement
+}
+
+type functionStatement struct {
+	name tokens.Token
+	params []tokens.Token
+	body []Statement
+}
+
+var _ FunctionStatement = (*functionStatement)(nil)
+
+func NewFunctionStatement(name tokens.Token, params []tokens.Token, body []Statement) FunctionStatement {
+	return &functionStatement{
+		name: name,
+		params: params,
+		body: body,
+	}
+}
+
+func (e *functionStatement) Accept(visitor StatementVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *functionStatement) Name() tokens.Token {
+	return e.name
+}
+
+func (e *functionStatement) Params() []tokens.Token {
+	return e.params
+}
+
+func (e *functionStatement) Body() []Statement {
+	return e.body
+}
+
+func (e *functionStatement) Type() StatementType {
+	return FunctionStatementStatementType
+}
+
+
 type IfStatement interface {
 	Statement
 	Condition() Expression
@@ -413,6 +875,42 @@ func (e *printStatement) Type() StatementType {
 }
 
 
+type ReturnStatement interface {
+	Statement
+	Keyword() tokens.Token
+	Value() Expression
+}
+
+type returnStatement struct {
+	keyword tokens.Token
+	value Expression
+}
+
+var _ ReturnStatement = (*returnStatement)(nil)
+
+func NewReturnStatement(keyword tokens.Token, value Expression) ReturnStatement {
+	return &returnStatement{
+		keyword: keyword,
+		value: value,
+	}
+}
+
+func (e *returnStatement) Accept(visitor StatementVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *returnStatement) Keyword() tokens.Token {
+	return e.keyword
+}
+
+func (e *returnStatement) Value() Expression {
+	return e.value
+}
+
+func (e *returnStatement) Type() StatementType {
+	return ReturnStatementStatementType
+}
+
+
 type VarStatement interface {
 	Statement
 	Name() tokens.Token
@@ -453,19 +951,22 @@ type WhileStatement interface {
 	Statement
 	Condition() Expression
 	Body() Statement
+	Increment() Expression
 }
 
 type whileStatement struct {
 	condition Expression
 	body Statement
+	increment Expression
 }
 
 var _ WhileStatement = (*whileStatement)(nil)
 
-func NewWhileStatement(condition Expression, body Statement) WhileStatement {
+func NewWhileStatement(condition Expression, body Statement, increment Expression) WhileStatement {
 	return &whileStatement{
 		condition: condition,
 		body: body,
+		increment: increment,
 	}
 }
 
@@ -480,7 +981,212 @@ func (e *whileStatement) Body() Statement {
 	return e.body
 }
 
+func (e *whileStatement) Increment() Expression {
+	return e.increment
+}
+
 func (e *whileStatement) Type() StatementType {
 	return WhileStatementStatementType
 }
 
+
+type ForInStatement interface {
+	Statement
+	Keyword() tokens.Token
+	Name() tokens.Token
+	Iterable() Expression
+	Body() Statement
+}
+
+type forInStatement struct {
+	keyword tokens.Token
+	name tokens.Token
+	iterable Expression
+	body Statement
+}
+
+var _ ForInStatement = (*forInStatement)(nil)
+
+func NewForInStatement(keyword tokens.Token, name tokens.Token, iterable Expression, body Statement) ForInStatement {
+	return &forInStatement{
+		keyword: keyword,
+		name: name,
+		iterable: iterable,
+		body: body,
+	}
+}
+
+func (e *forInStatement) Accept(visitor StatementVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *forInStatement) Keyword() tokens.Token {
+	return e.keyword
+}
+
+func (e *forInStatement) Name() tokens.Token {
+	return e.name
+}
+
+func (e *forInStatement) Iterable() Expression {
+	return e.iterable
+}
+
+func (e *forInStatement) Body() Statement {
+	return e.body
+}
+
+func (e *forInStatement) Type() StatementType {
+	return ForInStatementStatementType
+}
+
+
+type BreakStatement interface {
+	Statement
+	Keyword() tokens.Token
+}
+
+type breakStatement struct {
+	keyword tokens.Token
+}
+
+var _ BreakStatement = (*breakStatement)(nil)
+
+func NewBreakStatement(keyword tokens.Token) BreakStatement {
+	return &breakStatement{
+		keyword: keyword,
+	}
+}
+
+func (e *breakStatement) Accept(visitor StatementVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *breakStatement) Keyword() tokens.Token {
+	return e.keyword
+}
+
+func (e *breakStatement) Type() StatementType {
+	return BreakStatementStatementType
+}
+
+
+type ContinueStatement interface {
+	Statement
+	Keyword() tokens.Token
+}
+
+type continueStatement struct {
+	keyword tokens.Token
+}
+
+var _ ContinueStatement = (*continueStatement)(nil)
+
+func NewContinueStatement(keyword tokens.Token) ContinueStatement {
+	return &continueStatement{
+		keyword: keyword,
+	}
+}
+
+func (e *continueStatement) Accept(visitor StatementVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *continueStatement) Keyword() tokens.Token {
+	return e.keyword
+}
+
+func (e *continueStatement) Type() StatementType {
+	return ContinueStatementStatementType
+}
+
+
+type ThrowStatement interface {
+	Statement
+	Keyword() tokens.Token
+	Value() Expression
+}
+
+type throwStatement struct {
+	keyword tokens.Token
+	value Expression
+}
+
+var _ ThrowStatement = (*throwStatement)(nil)
+
+func NewThrowStatement(keyword tokens.Token, value Expression) ThrowStatement {
+	return &throwStatement{
+		keyword: keyword,
+		value: value,
+	}
+}
+
+func (e *throwStatement) Accept(visitor StatementVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *throwStatement) Keyword() tokens.Token {
+	return e.keyword
+}
+
+func (e *throwStatement) Value() Expression {
+	return e.value
+}
+
+func (e *throwStatement) Type() StatementType {
+	return ThrowStatementStatementType
+}
+
+
+type TryStatement interface {
+	Statement
+	Keyword() tokens.Token
+	Body() []Statement
+	Name() tokens.Token
+	CatchBody() []Statement
+	FinallyBody() []Statement
+}
+
+type tryStatement struct {
+	keyword tokens.Token
+	body []Statement
+	name tokens.Token
+	catchBody []Statement
+	finallyBody []Statement
+}
+
+var _ TryStatement = (*tryStatement)(nil)
+
+func NewTryStatement(keyword tokens.Token, body []Statement, name tokens.Token, catchBody []Statement, finallyBody []Statement) TryStatement {
+	return &tryStatement{
+		keyword: keyword,
+		body: body,
+		name: name,
+		catchBody: catchBody,
+		finallyBody: finallyBody,
+	}
+}
+
+func (e *tryStatement) Accept(visitor StatementVisitor) (any, error) {
+	return visitor(e)
+}
+func (e *tryStatement) Keyword() tokens.Token {
+	return e.keyword
+}
+
+func (e *tryStatement) Body() []Statement {
+	return e.body
+}
+
+func (e *tryStatement) Name() tokens.Token {
+	return e.name
+}
+
+func (e *tryStatement) CatchBody() []Statement {
+	return e.catchBody
+}
+
+func (e *tryStatement) FinallyBody() []Statement {
+	return e.finallyBody
+}
+
+func (e *tryStatement) Type() StatementType {
+	return TryStatementStatementType
+}
+
@@ -0,0 +1,166 @@
+package compiler
+
+type OpCode byte
+
+const (
+	OpConstant OpCode = iota
+	OpNil
+	OpTrue
+	OpFalse
+	OpPop
+	OpGetLocal
+	OpSetLocal
+	OpGetGlobal
+	OpDefineGlobal
+	OpSetGlobal
+	OpGetUpvalue
+	OpSetUpvalue
+	OpGetProperty
+	OpSetProperty
+	OpGetSuper
+	OpEqual
+	OpGreater
+	OpLess
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpNot
+	OpNegate
+	OpPrint
+	OpJump
+	OpJumpIfFalse
+	OpLoop
+	OpCall
+	OpInvoke
+	OpSuperInvoke
+	OpClosure
+	OpCloseUpvalue
+	OpReturn
+	OpClass
+	OpInherit
+	OpMethod
+	OpList
+	OpGetIndex
+	OpSetIndex
+	OpMap
+	OpIterator
+	OpTry
+	OpTryFinally
+	OpEndTry
+	OpThrow
+)
+
+func (op OpCode) String() string {
+	names := [...]string{
+		"OP_CONSTANT",
+		"OP_NIL",
+		"OP_TRUE",
+		"OP_FALSE",
+		"OP_POP",
+		"OP_GET_LOCAL",
+		"OP_SET_LOCAL",
+		"OP_GET_GLOBAL",
+		"OP_DEFINE_GLOBAL",
+		"OP_SET_GLOBAL",
+		"OP_GET_UPVALUE",
+		"OP_SET_UPVALUE",
+		"OP_GET_PROPERTY",
+		"OP_SET_PROPERTY",
+		"OP_GET_SUPER",
+		"OP_EQUAL",
+		"OP_GREATER",
+		"OP_LESS",
+		"OP_ADD",
+		"OP_SUBTRACT",
+		"OP_MULTIPLY",
+		"OP_DIVIDE",
+		"OP_NOT",
+		"OP_NEGATE",
+		"OP_PRINT",
+		"OP_JUMP",
+		"OP_JUMP_IF_FALSE",
+		"OP_LOOP",
+		"OP_CALL",
+		"OP_INVOKE",
+		"OP_SUPER_INVOKE",
+		"OP_CLOSURE",
+		"OP_CLOSE_UPVALUE",
+		"OP_RETURN",
+		"OP_CLASS",
+		"OP_INHERIT",
+		"OP_METHOD",
+		"OP_LIST",
+		"OP_GET_INDEX",
+		"OP_SET_INDEX",
+		"OP_MAP",
+		"OP_ITERATOR",
+		"OP_TRY",
+		"OP_TRY_FINALLY",
+		"OP_END_TRY",
+		"OP_THROW",
+	}
+	if int(op) >= len(names) {
+		return "OP_UNKNOWN"
+	}
+	return names[op]
+}
+
+type (
+	// Chunk is a compiled sequence of instructions.
+	// Instruction operands are encoded in the bytes that follow the opcode:
+	// constant pool indexes, jump offsets and list and map lengths take two bytes (big endian), local, upvalue and argument counts take one.
+	Chunk struct {
+		Code []byte
+		// Lines holds the source line of every byte in Code
+		Lines []int
+		// Spans holds the part of the source every byte in Code was compiled from
+		Spans []Span
+		// Source is the program the spans point into, it isn't saved in .loxc files
+		Source string
+		// Constants holds float64, string and *Function values
+		Constants []any
+	}
+
+	// Span is the token an instruction was compiled from: its byte offsets in the source, End excluded,
+	// and the 1-based column of its first character. The zero Span is an unknown place.
+	Span struct {
+		Start  int
+		End    int
+		Column int
+	}
+
+	// Function is the compiled form of a Lox function or of the top-level script
+	Function struct {
+		// Name is empty for the top-level script
+		Name         string
+		Arity        int
+		UpvalueCount int
+		Chunk        *Chunk
+	}
+)
+
+func NewChunk() *Chunk {
+	return &Chunk{}
+}
+
+func (c *Chunk) Write(b byte, line int, span Span) {
+	c.Code = append(c.Code, b)
+	c.Lines = append(c.Lines, line)
+	c.Spans = append(c.Spans, span)
+}
+
+// AddConstant appends value to the constant pool and returns its index.
+// Numbers and strings that are already in the pool are reused.
+func (c *Chunk) AddConstant(value any) int {
+	switch value.(type) {
+	case float64, string:
+		for i, constant := range c.Constants {
+			if constant == value {
+				return i
+			}
+		}
+	}
+	c.Constants = append(c.Constants, value)
+	return len(c.Constants) - 1
+}
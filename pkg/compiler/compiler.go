@@ -0,0 +1,968 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/ast"
+	"github.com/mtvarkovsky/golox/pkg/tokens"
+)
+
+type (
+	// Compiler translates a parsed and resolved program into bytecode for the virtual machine.
+	// It assumes the program already passed the resolver and only reports the limits of the bytecode format.
+	Compiler interface {
+		Compile(statements []ast.Statement) (*Function, []*Error)
+	}
+
+	compiler struct {
+		current      *functionCompiler
+		currentClass *classCompiler
+		// line and span locate the last token seen, instructions are attributed to it
+		line int
+		span Span
+		errs []*Error
+	}
+
+	// functionCompiler holds the state of the function whose body is being compiled
+	functionCompiler struct {
+		enclosing  *functionCompiler
+		function   *Function
+		fType      functionType
+		locals     []local
+		upvalues   []upvalue
+		scopeDepth int
+		// loop is the innermost loop around the code being compiled, nil outside of loops
+		loop *loopCompiler
+		// tries holds the try statements whose body or catch clause is being compiled, innermost last
+		tries []*tryCompiler
+	}
+
+	// loopCompiler collects the jumps of the break and continue statements of a loop body until their targets are known
+	loopCompiler struct {
+		enclosing *loopCompiler
+		// scopeDepth is the depth of the scope around the body, the locals deeper than it are discarded before jumping
+		scopeDepth    int
+		breakJumps    []int
+		continueJumps []int
+		// tries is the number of try statements around the loop, the ones above it are left by break and continue
+		tries int
+	}
+
+	// tryCompiler holds what break, continue and return statements must undo to leave a try statement:
+	// the exception handler the statement installed, if any, and its finally block, which runs before they jump
+	tryCompiler struct {
+		hasHandler  bool
+		finallyBody []ast.Statement
+		// loop is the innermost loop around the try statement, break and continue in the finally block refer to it
+		loop *loopCompiler
+	}
+
+	local struct {
+		name string
+		// depth is -1 while the variable is declared but its initializer is still being compiled
+		depth      int
+		isCaptured bool
+	}
+
+	upvalue struct {
+		index   byte
+		isLocal bool
+	}
+
+	classCompiler struct {
+		enclosing     *classCompiler
+		hasSuperclass bool
+	}
+
+	Error struct {
+		Line int
+		// Span is the token the compiler was at, the zero Span when it hadn't reached any
+		Span Span
+		// Source is the program Span points into, it is empty when the compiler hadn't reached any token
+		Source string
+		err    error
+	}
+
+	functionType int
+)
+
+const (
+	typeFunction functionType = iota
+	typeInitializer
+	typeMethod
+	typeScript
+)
+
+const (
+	MaxLocals       = 256
+	MaxUpvalues     = 256
+	MaxConstants    = 1 << 16
+	MaxJump         = 1<<16 - 1
+	MaxListElements = 1<<16 - 1
+	MaxMapEntries   = 1<<16 - 1
+)
+
+func NewCompiler() Compiler {
+	return &compiler{}
+}
+
+func (c *compiler) Compile(statements []ast.Statement) (*Function, []*Error) {
+	c.current = nil
+	c.currentClass = nil
+	c.line = 1
+	c.span = Span{}
+	c.errs = nil
+
+	c.beginFunction(typeScript, "")
+	for _, statement := range statements {
+		c.statement(statement)
+	}
+	function, _ := c.endFunction()
+
+	return function, c.errs
+}
+
+func (c *compiler) beginFunction(fType functionType, name string) {
+	fc := &functionCompiler{
+		enclosing: c.current,
+		function: &Function{
+			Name:  name,
+			Chunk: NewChunk(),
+		},
+		fType: fType,
+	}
+
+	// slot zero holds the called closure, or the receiver inside methods
+	slotZero := ""
+	if fType == typeMethod || fType == typeInitializer {
+		slotZero = "this"
+	}
+	fc.locals = append(fc.locals, local{name: slotZero, depth: 0})
+
+	c.current = fc
+}
+
+func (c *compiler) endFunction() (*Function, []upvalue) {
+	c.emitReturn()
+	fc := c.current
+	c.current = fc.enclosing
+	return fc.function, fc.upvalues
+}
+
+func (c *compiler) statement(statement ast.Statement) {
+	switch statement.Type() {
+	case ast.ExpressionStatementStatementType:
+		c.expression(statement.(ast.ExpressionStatement).Expression())
+		c.emitOp(OpPop)
+	case ast.PrintStatementStatementType:
+		c.expression(statement.(ast.PrintStatement).Expression())
+		c.emitOp(OpPrint)
+	case ast.VarStatementStatementType:
+		c.varStatement(statement.(ast.VarStatement))
+	case ast.BlockStatementStatementType:
+		c.block(statement.(ast.BlockStatement).Statements())
+	case ast.IfStatementStatementType:
+		c.ifStatement(statement.(ast.IfStatement))
+	case ast.WhileStatementStatementType:
+		c.whileStatement(statement.(ast.WhileStatement))
+	case ast.ForInStatementStatementType:
+		c.forInStatement(statement.(ast.ForInStatement))
+	case ast.FunctionStatementStatementType:
+		c.functionStatement(statement.(ast.FunctionStatement))
+	case ast.ReturnStatementStatementType:
+		c.returnStatement(statement.(ast.ReturnStatement))
+	case ast.ThrowStatementStatementType:
+		throw := statement.(ast.ThrowStatement)
+		c.expression(throw.Value())
+		c.setLine(throw.Keyword())
+		c.emitOp(OpThrow)
+	case ast.TryStatementStatementType:
+		c.tryStatement(statement.(ast.TryStatement))
+	case ast.BreakStatementStatementType:
+		c.loopJump(statement.(ast.BreakStatement).Keyword())
+	case ast.ContinueStatementStatementType:
+		c.loopJump(statement.(ast.ContinueStatement).Keyword())
+	case ast.ClassStatementStatementType:
+		c.classStatement(statement.(ast.ClassStatement))
+	default:
+		c.error(fmt.Sprintf("Unsupported statement type %d.", statement.Type()))
+	}
+}
+
+func (c *compiler) varStatement(statement ast.VarStatement) {
+	c.setLine(statement.Name())
+	c.declareVariable(statement.Name())
+	if statement.Initializer() != nil {
+		c.expression(statement.Initializer())
+	} else {
+		c.emitOp(OpNil)
+	}
+	c.defineVariable(statement.Name())
+}
+
+func (c *compiler) ifStatement(statement ast.IfStatement) {
+	c.expression(statement.Condition())
+
+	thenJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	c.statement(statement.ThenStatement())
+
+	elseJump := c.emitJump(OpJump)
+	c.patchJump(thenJump)
+	c.emitOp(OpPop)
+	if statement.ElseStatement() != nil {
+		c.statement(statement.ElseStatement())
+	}
+	c.patchJump(elseJump)
+}
+
+func (c *compiler) whileStatement(statement ast.WhileStatement) {
+	loopStart := len(c.chunk().Code)
+	c.expression(statement.Condition())
+
+	exitJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	loop := c.beginLoop()
+	c.statement(statement.Body())
+
+	// continue runs the increment of a desugared for loop before the condition is checked again
+	c.patchJumps(loop.continueJumps)
+	if statement.Increment() != nil {
+		c.expression(statement.Increment())
+		c.emitOp(OpPop)
+	}
+	c.emitLoop(loopStart)
+
+	c.patchJump(exitJump)
+	c.emitOp(OpPop)
+	c.endLoop(loop)
+}
+
+// forInStatement keeps the iterator in a hidden local and asks it for values with its hasNext and next methods.
+// The built-in iterators of lists, maps, strings and ranges answer both without a call frame.
+func (c *compiler) forInStatement(statement ast.ForInStatement) {
+	c.beginScope()
+	c.expression(statement.Iterable())
+	c.setLine(statement.Keyword())
+	c.emitOp(OpIterator)
+	// the name can't be written in Lox, so the body can't read or shadow the iterator
+	c.addLocal("for iterator")
+	c.markInitialized()
+	iterator := byte(len(c.current.locals) - 1)
+
+	loopStart := len(c.chunk().Code)
+	c.setLine(statement.Keyword())
+	c.emitOp(OpGetLocal)
+	c.emitByte(iterator)
+	c.emitOpShort(OpInvoke, c.identifierConstant("hasNext"))
+	c.emitByte(0)
+	exitJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	loop := c.beginLoop()
+
+	// every iteration has its own loop variable, closures created by the body capture the value of their iteration
+	c.beginScope()
+	c.emitOp(OpGetLocal)
+	c.emitByte(iterator)
+	c.emitOpShort(OpInvoke, c.identifierConstant("next"))
+	c.emitByte(0)
+	c.addLocal(statement.Name().Lexeme())
+	c.markInitialized()
+	c.statement(statement.Body())
+	c.endScope()
+	c.patchJumps(loop.continueJumps)
+	c.emitLoop(loopStart)
+
+	c.patchJump(exitJump)
+	c.emitOp(OpPop)
+	// break leaves the iterator on the stack, it is discarded with the scope of the loop
+	c.endLoop(loop)
+	c.endScope()
+}
+
+// beginLoop starts collecting the break and continue jumps of a loop body
+func (c *compiler) beginLoop() *loopCompiler {
+	loop := &loopCompiler{enclosing: c.current.loop, scopeDepth: c.current.scopeDepth, tries: len(c.current.tries)}
+	c.current.loop = loop
+	return loop
+}
+
+// endLoop points the break jumps of a loop to the next instruction to be emitted
+func (c *compiler) endLoop(loop *loopCompiler) {
+	c.patchJumps(loop.breakJumps)
+	c.current.loop = loop.enclosing
+}
+
+func (c *compiler) patchJumps(offsets []int) {
+	for _, offset := range offsets {
+		c.patchJump(offset)
+	}
+}
+
+// loopJump compiles a break or continue statement. Both discard the locals declared in the loop body
+// and jump forward, the targets are patched once the loop is compiled.
+func (c *compiler) loopJump(keyword tokens.Token) {
+	c.setLine(keyword)
+	loop := c.current.loop
+	if loop == nil {
+		c.error(fmt.Sprintf("Can't use '%s' outside of a loop.", keyword.Lexeme()))
+		return
+	}
+
+	c.leaveTries(loop.tries)
+
+	// the locals stay declared, the statements after the jump are still compiled in their scope
+	locals := c.current.locals
+	for i := len(locals) - 1; i >= 0 && locals[i].depth > loop.scopeDepth; i-- {
+		if locals[i].isCaptured {
+			c.emitOp(OpCloseUpvalue)
+		} else {
+			c.emitOp(OpPop)
+		}
+	}
+
+	if keyword.Type() == tokens.Break {
+		loop.breakJumps = append(loop.breakJumps, c.emitJump(OpJump))
+	} else {
+		loop.continueJumps = append(loop.continueJumps, c.emitJump(OpJump))
+	}
+}
+
+// tryStatement installs an exception handler around the body. When the body throws, the VM unwinds the stack
+// to the depth it had at the handler, pushes the thrown value and jumps to the catch clause,
+// or to the finally block which then throws the exception again.
+// The finally block is compiled once for every way out of the statement: after the body or the catch clause complete,
+// on the path that throws the exception again, and before every break, continue and return that leaves the statement.
+func (c *compiler) tryStatement(statement ast.TryStatement) {
+	hasCatch := statement.Name() != nil
+	hasFinally := len(statement.FinallyBody()) > 0
+	if !hasCatch && !hasFinally {
+		c.block(statement.Body())
+		return
+	}
+
+	try := &tryCompiler{hasHandler: true, finallyBody: statement.FinallyBody(), loop: c.current.loop}
+	c.current.tries = append(c.current.tries, try)
+
+	c.setLine(statement.Keyword())
+	// a catch handler receives the thrown value, a finally handler receives the exception it throws again
+	handlerOp := OpTryFinally
+	if hasCatch {
+		handlerOp = OpTry
+	}
+	handler := c.emitJump(handlerOp)
+	c.block(statement.Body())
+	c.emitOp(OpEndTry)
+	done := []int{c.emitJump(OpJump)}
+	c.patchJump(handler)
+
+	// the thrown value is on top of the stack
+	if hasCatch {
+		try.hasHandler = hasFinally
+		c.beginScope()
+		c.addLocal(statement.Name().Lexeme())
+		c.markInitialized()
+		if hasFinally {
+			// an exception thrown by the catch clause still runs the finally block
+			handler = c.emitJump(OpTryFinally)
+		}
+		c.block(statement.CatchBody())
+		if hasFinally {
+			c.emitOp(OpEndTry)
+		}
+		c.endScope()
+		done = append(done, c.emitJump(OpJump))
+	}
+	c.current.tries = c.current.tries[:len(c.current.tries)-1]
+
+	if hasFinally {
+		if hasCatch {
+			c.patchJump(handler)
+			// the handler of the catch clause leaves the catch variable below the exception
+			c.beginScope()
+			c.addLocal("catch variable")
+			c.markInitialized()
+		}
+		c.beginScope()
+		c.addLocal("exception")
+		c.markInitialized()
+		exception := byte(len(c.current.locals) - 1)
+		c.block(statement.FinallyBody())
+		c.emitOp(OpGetLocal)
+		c.emitByte(exception)
+		c.emitOp(OpThrow)
+		c.dropScope()
+		if hasCatch {
+			c.dropScope()
+		}
+	}
+
+	c.patchJumps(done)
+	if hasFinally {
+		c.block(statement.FinallyBody())
+	}
+}
+
+// leaveTries emits what leaves the try statements above the first count ones, innermost first:
+// it removes their exception handlers and runs their finally blocks.
+// A finally block is compiled with the try statements and the loop that are around its own statement,
+// so the break, continue and return statements it contains leave the right ones.
+func (c *compiler) leaveTries(count int) {
+	fc := c.current
+	tries, loop := fc.tries, fc.loop
+	for i := len(tries) - 1; i >= count; i-- {
+		if tries[i].hasHandler {
+			c.emitOp(OpEndTry)
+		}
+		if len(tries[i].finallyBody) > 0 {
+			fc.tries, fc.loop = tries[:i], tries[i].loop
+			c.block(tries[i].finallyBody)
+		}
+	}
+	fc.tries, fc.loop = tries, loop
+}
+
+// block compiles statements in a scope of their own
+func (c *compiler) block(statements []ast.Statement) {
+	c.beginScope()
+	for _, statement := range statements {
+		c.statement(statement)
+	}
+	c.endScope()
+}
+
+// dropScope forgets the locals of the innermost scope without discarding them,
+// it ends scopes whose code never reaches the end because it returns or throws first
+func (c *compiler) dropScope() {
+	fc := c.current
+	fc.scopeDepth--
+	for len(fc.locals) > 0 && fc.locals[len(fc.locals)-1].depth > fc.scopeDepth {
+		fc.locals = fc.locals[:len(fc.locals)-1]
+	}
+}
+
+func (c *compiler) functionStatement(statement ast.FunctionStatement) {
+	c.setLine(statement.Name())
+	c.declareVariable(statement.Name())
+	// a local function may refer to itself, so it is usable before its body is compiled
+	c.markInitialized()
+	c.function(statement, typeFunction)
+	c.defineVariable(statement.Name())
+}
+
+func (c *compiler) function(declaration ast.FunctionStatement, fType functionType) {
+	c.beginFunction(fType, declaration.Name().Lexeme())
+	c.beginScope()
+
+	for _, param := range declaration.Params() {
+		c.current.function.Arity++
+		c.declareVariable(param)
+		c.defineVariable(param)
+	}
+	for _, statement := range declaration.Body() {
+		c.statement(statement)
+	}
+
+	function, upvalues := c.endFunction()
+	function.UpvalueCount = len(upvalues)
+
+	c.emitOpShort(OpClosure, c.makeConstant(function))
+	for _, uv := range upvalues {
+		if uv.isLocal {
+			c.emitByte(1)
+		} else {
+			c.emitByte(0)
+		}
+		c.emitByte(uv.index)
+	}
+}
+
+func (c *compiler) returnStatement(statement ast.ReturnStatement) {
+	c.setLine(statement.Keyword())
+	if statement.Value() == nil {
+		c.leaveTries(0)
+		c.emitReturn()
+		return
+	}
+	c.expression(statement.Value())
+	if len(c.current.tries) == 0 {
+		c.emitOp(OpReturn)
+		return
+	}
+
+	// the value waits in a hidden local while the finally blocks run, so their locals get the slots they expect
+	c.beginScope()
+	c.addLocal("return value")
+	c.markInitialized()
+	slot := byte(len(c.current.locals) - 1)
+	c.leaveTries(0)
+	c.setLine(statement.Keyword())
+	c.emitOp(OpGetLocal)
+	c.emitByte(slot)
+	c.emitOp(OpReturn)
+	c.dropScope()
+}
+
+func (c *compiler) classStatement(statement ast.ClassStatement) {
+	name := statement.Name()
+	c.setLine(name)
+	nameConstant := c.identifierConstant(name.Lexeme())
+	c.declareVariable(name)
+
+	c.emitOpShort(OpClass, nameConstant)
+	c.defineVariable(name)
+
+	class := &classCompiler{enclosing: c.currentClass}
+	c.currentClass = class
+
+	if statement.Superclass() != nil {
+		c.namedVariable(statement.Superclass().Name(), false)
+
+		c.beginScope()
+		c.addLocal("super")
+		c.markInitialized()
+
+		c.namedVariable(name, false)
+		c.emitOp(OpInherit)
+		class.hasSuperclass = true
+	}
+
+	c.namedVariable(name, false)
+	for _, method := range statement.Methods() {
+		c.setLine(method.Name())
+		constant := c.identifierConstant(method.Name().Lexeme())
+		fType := typeMethod
+		if method.Name().Lexeme() == "init" {
+			fType = typeInitializer
+		}
+		c.function(method, fType)
+		c.emitOpShort(OpMethod, constant)
+	}
+	c.emitOp(OpPop)
+
+	if class.hasSuperclass {
+		c.endScope()
+	}
+	c.currentClass = class.enclosing
+}
+
+func (c *compiler) expression(expression ast.Expression) {
+	switch expression.Type() {
+	case ast.LiteralExpressionType:
+		c.literal(expression.(ast.Literal))
+	case ast.GroupingExpressionType:
+		c.expression(expression.(ast.Grouping).Expression())
+	case ast.UnaryExpressionType:
+		c.unary(expression.(ast.Unary))
+	case ast.BinaryExpressionType:
+		c.binary(expression.(ast.Binary))
+	case ast.LogicalExpressionType:
+		c.logical(expression.(ast.Logical))
+	case ast.VariableExpressionType:
+		c.namedVariable(expression.(ast.Variable).Name(), false)
+	case ast.AssignmentExpressionType:
+		assignment := expression.(ast.Assignment)
+		c.expression(assignment.Value())
+		c.namedVariable(assignment.Name(), true)
+	case ast.CallExpressionType:
+		c.call(expression.(ast.Call))
+	case ast.GetExpressionType:
+		get := expression.(ast.Get)
+		c.expression(get.Object())
+		c.setLine(get.Name())
+		c.emitOpShort(OpGetProperty, c.identifierConstant(get.Name().Lexeme()))
+	case ast.SetExpressionType:
+		set := expression.(ast.Set)
+		c.expression(set.Object())
+		c.expression(set.Value())
+		c.setLine(set.Name())
+		c.emitOpShort(OpSetProperty, c.identifierConstant(set.Name().Lexeme()))
+	case ast.ThisExpressionType:
+		c.namedVariable(expression.(ast.This).Keyword(), false)
+	case ast.SuperExpressionType:
+		super := expression.(ast.Super)
+		c.namedVariable(thisToken(super.Keyword()), false)
+		c.namedVariable(super.Keyword(), false)
+		c.emitOpShort(OpGetSuper, c.identifierConstant(super.Method().Lexeme()))
+	case ast.ListExpressionType:
+		c.list(expression.(ast.List))
+	case ast.MapLiteralExpressionType:
+		c.mapLiteral(expression.(ast.MapLiteral))
+	case ast.SubscriptExpressionType:
+		subscript := expression.(ast.Subscript)
+		c.expression(subscript.Object())
+		c.expression(subscript.Index())
+		c.setLine(subscript.Bracket())
+		c.emitOp(OpGetIndex)
+	case ast.SubscriptSetExpressionType:
+		subscript := expression.(ast.SubscriptSet)
+		c.expression(subscript.Object())
+		c.expression(subscript.Index())
+		c.expression(subscript.Value())
+		c.setLine(subscript.Bracket())
+		c.emitOp(OpSetIndex)
+	default:
+		c.error(fmt.Sprintf("Unsupported expression type %d.", expression.Type()))
+	}
+}
+
+func (c *compiler) literal(expression ast.Literal) {
+	switch v := expression.Value().(type) {
+	case nil:
+		c.emitOp(OpNil)
+	case bool:
+		if v {
+			c.emitOp(OpTrue)
+		} else {
+			c.emitOp(OpFalse)
+		}
+	case float64, string:
+		c.emitOpShort(OpConstant, c.makeConstant(v))
+	default:
+		c.error(fmt.Sprintf("Unsupported literal %v.", v))
+	}
+}
+
+// list pushes the elements on the stack and collects them into a new list
+func (c *compiler) list(expression ast.List) {
+	for _, element := range expression.Elements() {
+		c.expression(element)
+	}
+	c.setLine(expression.Bracket())
+	if len(expression.Elements()) > MaxListElements {
+		c.error(fmt.Sprintf("Can't have more than %d elements in a list literal.", MaxListElements))
+	}
+	c.emitOpShort(OpList, len(expression.Elements()))
+}
+
+// mapLiteral pushes every key followed by its value on the stack and collects them into a new map
+func (c *compiler) mapLiteral(expression ast.MapLiteral) {
+	for i, key := range expression.Keys() {
+		c.expression(key)
+		c.expression(expression.Values()[i])
+	}
+	c.setLine(expression.Brace())
+	if len(expression.Keys()) > MaxMapEntries {
+		c.error(fmt.Sprintf("Can't have more than %d entries in a map literal.", MaxMapEntries))
+	}
+	c.emitOpShort(OpMap, len(expression.Keys()))
+}
+
+func (c *compiler) unary(expression ast.Unary) {
+	c.expression(expression.Right())
+	c.setLine(expression.Operator())
+	switch expression.Operator().Type() {
+	case tokens.Minus:
+		c.emitOp(OpNegate)
+	case tokens.Bang:
+		c.emitOp(OpNot)
+	}
+}
+
+func (c *compiler) binary(expression ast.Binary) {
+	c.expression(expression.Left())
+	c.expression(expression.Right())
+	c.setLine(expression.Operator())
+	switch expression.Operator().Type() {
+	case tokens.BangEqual:
+		c.emitOp(OpEqual)
+		c.emitOp(OpNot)
+	case tokens.EqualEqual:
+		c.emitOp(OpEqual)
+	case tokens.Greater:
+		c.emitOp(OpGreater)
+	case tokens.GreaterEqual:
+		c.emitOp(OpLess)
+		c.emitOp(OpNot)
+	case tokens.Less:
+		c.emitOp(OpLess)
+	case tokens.LessEqual:
+		c.emitOp(OpGreater)
+		c.emitOp(OpNot)
+	case tokens.Plus:
+		c.emitOp(OpAdd)
+	case tokens.Minus:
+		c.emitOp(OpSubtract)
+	case tokens.Star:
+		c.emitOp(OpMultiply)
+	case tokens.Slash:
+		c.emitOp(OpDivide)
+	}
+}
+
+func (c *compiler) logical(expression ast.Logical) {
+	c.expression(expression.Left())
+	if expression.Operator().Type() == tokens.And {
+		endJump := c.emitJump(OpJumpIfFalse)
+		c.emitOp(OpPop)
+		c.expression(expression.Right())
+		c.patchJump(endJump)
+		return
+	}
+
+	elseJump := c.emitJump(OpJumpIfFalse)
+	endJump := c.emitJump(OpJump)
+	c.patchJump(elseJump)
+	c.emitOp(OpPop)
+	c.expression(expression.Right())
+	c.patchJump(endJump)
+}
+
+// call compiles method calls on instances and on super into a single invoke instruction,
+// which avoids creating a bound method for every call
+func (c *compiler) call(expression ast.Call) {
+	switch expression.Callee().Type() {
+	case ast.GetExpressionType:
+		get := expression.Callee().(ast.Get)
+		c.expression(get.Object())
+		argCount := c.arguments(expression.Arguments())
+		c.setLine(expression.Paren())
+		c.emitOpShort(OpInvoke, c.identifierConstant(get.Name().Lexeme()))
+		c.emitByte(argCount)
+	case ast.SuperExpressionType:
+		super := expression.Callee().(ast.Super)
+		c.namedVariable(thisToken(super.Keyword()), false)
+		argCount := c.arguments(expression.Arguments())
+		c.namedVariable(super.Keyword(), false)
+		c.setLine(expression.Paren())
+		c.emitOpShort(OpSuperInvoke, c.identifierConstant(super.Method().Lexeme()))
+		c.emitByte(argCount)
+	default:
+		c.expression(expression.Callee())
+		argCount := c.arguments(expression.Arguments())
+		c.setLine(expression.Paren())
+		c.emitOp(OpCall)
+		c.emitByte(argCount)
+	}
+}
+
+func (c *compiler) arguments(arguments []ast.Expression) byte {
+	for _, argument := range arguments {
+		c.expression(argument)
+	}
+	if len(arguments) > 255 {
+		c.error("Can't have more than 255 arguments.")
+	}
+	return byte(len(arguments))
+}
+
+func (c *compiler) namedVariable(name tokens.Token, assign bool) {
+	c.setLine(name)
+
+	if slot := c.resolveLocal(c.current, name); slot != -1 {
+		if assign {
+			c.emitOp(OpSetLocal)
+		} else {
+			c.emitOp(OpGetLocal)
+		}
+		c.emitByte(byte(slot))
+		return
+	}
+
+	if index := c.resolveUpvalue(c.current, name); index != -1 {
+		if assign {
+			c.emitOp(OpSetUpvalue)
+		} else {
+			c.emitOp(OpGetUpvalue)
+		}
+		c.emitByte(byte(index))
+		return
+	}
+
+	constant := c.identifierConstant(name.Lexeme())
+	if assign {
+		c.emitOpShort(OpSetGlobal, constant)
+	} else {
+		c.emitOpShort(OpGetGlobal, constant)
+	}
+}
+
+func (c *compiler) resolveLocal(fc *functionCompiler, name tokens.Token) int {
+	for i := len(fc.locals) - 1; i >= 0; i-- {
+		if fc.locals[i].name == name.Lexeme() {
+			if fc.locals[i].depth == -1 {
+				c.error("Can't read local variable in its own initializer.")
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveUpvalue looks the variable up in the enclosing functions
+// and threads it through the upvalues of every function in between
+func (c *compiler) resolveUpvalue(fc *functionCompiler, name tokens.Token) int {
+	if fc.enclosing == nil {
+		return -1
+	}
+
+	if slot := c.resolveLocal(fc.enclosing, name); slot != -1 {
+		fc.enclosing.locals[slot].isCaptured = true
+		return c.addUpvalue(fc, byte(slot), true)
+	}
+
+	if index := c.resolveUpvalue(fc.enclosing, name); index != -1 {
+		return c.addUpvalue(fc, byte(index), false)
+	}
+
+	return -1
+}
+
+func (c *compiler) addUpvalue(fc *functionCompiler, index byte, isLocal bool) int {
+	for i, uv := range fc.upvalues {
+		if uv.index == index && uv.isLocal == isLocal {
+			return i
+		}
+	}
+
+	if len(fc.upvalues) == MaxUpvalues {
+		c.error("Too many closure variables in function.")
+		return 0
+	}
+
+	fc.upvalues = append(fc.upvalues, upvalue{index: index, isLocal: isLocal})
+	return len(fc.upvalues) - 1
+}
+
+func (c *compiler) declareVariable(name tokens.Token) {
+	if c.current.scopeDepth == 0 {
+		return
+	}
+	c.addLocal(name.Lexeme())
+}
+
+func (c *compiler) addLocal(name string) {
+	if len(c.current.locals) == MaxLocals {
+		c.error("Too many local variables in function.")
+		return
+	}
+	c.current.locals = append(c.current.locals, local{name: name, depth: -1})
+}
+
+func (c *compiler) defineVariable(name tokens.Token) {
+	if c.current.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+	c.emitOpShort(OpDefineGlobal, c.identifierConstant(name.Lexeme()))
+}
+
+func (c *compiler) markInitialized() {
+	if c.current.scopeDepth == 0 {
+		return
+	}
+	c.current.locals[len(c.current.locals)-1].depth = c.current.scopeDepth
+}
+
+func (c *compiler) beginScope() {
+	c.current.scopeDepth++
+}
+
+// endScope discards the locals of the innermost scope,
+// the ones captured by closures are moved off the stack first
+func (c *compiler) endScope() {
+	fc := c.current
+	fc.scopeDepth--
+
+	for len(fc.locals) > 0 && fc.locals[len(fc.locals)-1].depth > fc.scopeDepth {
+		if fc.locals[len(fc.locals)-1].isCaptured {
+			c.emitOp(OpCloseUpvalue)
+		} else {
+			c.emitOp(OpPop)
+		}
+		fc.locals = fc.locals[:len(fc.locals)-1]
+	}
+}
+
+func (c *compiler) identifierConstant(name string) int {
+	return c.makeConstant(name)
+}
+
+func (c *compiler) makeConstant(value any) int {
+	constant := c.chunk().AddConstant(value)
+	if constant >= MaxConstants {
+		c.error("Too many constants in one chunk.")
+		return 0
+	}
+	return constant
+}
+
+func (c *compiler) chunk() *Chunk {
+	return c.current.function.Chunk
+}
+
+func (c *compiler) setLine(token tokens.Token) {
+	if token != nil {
+		c.line = token.Line()
+		c.span = Span{Start: token.Start(), End: token.End(), Column: token.Position()}
+		// every token of a program has the same source, the first one gives it to the chunk
+		if chunk := c.chunk(); chunk.Source == "" {
+			chunk.Source = token.Source()
+		}
+	}
+}
+
+func (c *compiler) emitByte(b byte) {
+	c.chunk().Write(b, c.line, c.span)
+}
+
+func (c *compiler) emitOp(op OpCode) {
+	c.emitByte(byte(op))
+}
+
+func (c *compiler) emitOpShort(op OpCode, operand int) {
+	c.emitOp(op)
+	c.emitByte(byte(operand >> 8))
+	c.emitByte(byte(operand))
+}
+
+func (c *compiler) emitJump(op OpCode) int {
+	c.emitOpShort(op, MaxJump)
+	return len(c.chunk().Code) - 2
+}
+
+// patchJump points the jump operand at offset to the next instruction to be emitted
+func (c *compiler) patchJump(offset int) {
+	jump := len(c.chunk().Code) - offset - 2
+	if jump > MaxJump {
+		c.error("Too much code to jump over.")
+	}
+	c.chunk().Code[offset] = byte(jump >> 8)
+	c.chunk().Code[offset+1] = byte(jump)
+}
+
+func (c *compiler) emitLoop(loopStart int) {
+	offset := len(c.chunk().Code) - loopStart + 3
+	if offset > MaxJump {
+		c.error("Loop body too large.")
+	}
+	c.emitOpShort(OpLoop, offset)
+}
+
+func (c *compiler) emitReturn() {
+	if c.current.fType == typeInitializer {
+		c.emitOp(OpGetLocal)
+		c.emitByte(0)
+	} else {
+		c.emitOp(OpNil)
+	}
+	c.emitOp(OpReturn)
+}
+
+func (c *compiler) error(message string) {
+	c.errs = append(c.errs, &Error{
+		Line:   c.line,
+		Span:   c.span,
+		Source: c.chunk().Source,
+		err:    errors.New(message),
+	})
+}
+
+// thisToken makes the token used to look the receiver up for the given super expression
+func thisToken(super tokens.Token) tokens.Token {
+	return tokens.NewSourceToken(tokens.This, "this", nil, super.Line(), super.Position(), super.Start(), super.Source())
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
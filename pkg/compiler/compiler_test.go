@@ -0,0 +1,170 @@
+package compiler
+
+import (
+	"github.com/mtvarkovsky/golox/pkg/ast"
+	"github.com/mtvarkovsky/golox/pkg/parser"
+	"github.com/mtvarkovsky/golox/pkg/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func parse(t *testing.T, code string) []ast.Statement {
+	tkns, scanErrs := scanner.NewScanner(code).ScanTokens()
+	require.Empty(t, scanErrs)
+	statements, parseErrs := parser.NewParser(tkns).Parse()
+	require.Empty(t, parseErrs)
+	return statements
+}
+
+func TestCompiler_Expression(t *testing.T) {
+	function, errs := NewCompiler().Compile(parse(t, "print 1 + 2 * 1;\n"))
+	require.Empty(t, errs)
+
+	assert.Equal(t, "", function.Name)
+	assert.Equal(t, []byte{
+		byte(OpConstant), 0, 0,
+		byte(OpConstant), 0, 1,
+		byte(OpConstant), 0, 0,
+		byte(OpMultiply),
+		byte(OpAdd),
+		byte(OpPrint),
+		byte(OpNil),
+		byte(OpReturn),
+	}, function.Chunk.Code)
+	assert.Equal(t, []any{float64(1), float64(2)}, function.Chunk.Constants)
+	assert.Len(t, function.Chunk.Lines, len(function.Chunk.Code))
+}
+
+func TestCompiler_Spans(t *testing.T) {
+	code := "var a;\nprint -a;\n"
+	function, errs := NewCompiler().Compile(parse(t, code))
+	require.Empty(t, errs)
+
+	require.Len(t, function.Chunk.Spans, len(function.Chunk.Code))
+	negate := -1
+	for offset, b := range function.Chunk.Code {
+		if OpCode(b) == OpNegate {
+			negate = offset
+		}
+	}
+	require.NotEqual(t, -1, negate)
+	span := function.Chunk.Spans[negate]
+	assert.Equal(t, Span{Start: 13, End: 14, Column: 7}, span)
+	assert.Equal(t, "-", code[span.Start:span.End])
+}
+
+func TestCompiler_List(t *testing.T) {
+	function, errs := NewCompiler().Compile(parse(t, "var xs = [1, 2];\nxs[0] = xs[1];\n"))
+	require.Empty(t, errs)
+
+	assert.Equal(t, []byte{
+		byte(OpConstant), 0, 0,
+		byte(OpConstant), 0, 1,
+		byte(OpList), 0, 2,
+		byte(OpDefineGlobal), 0, 2,
+		byte(OpGetGlobal), 0, 2,
+		byte(OpConstant), 0, 3,
+		byte(OpGetGlobal), 0, 2,
+		byte(OpConstant), 0, 0,
+		byte(OpGetIndex),
+		byte(OpSetIndex),
+		byte(OpPop),
+		byte(OpNil),
+		byte(OpReturn),
+	}, function.Chunk.Code)
+	assert.Equal(t, []any{float64(1), float64(2), "xs", float64(0)}, function.Chunk.Constants)
+}
+
+// lines follow the last token the compiler has seen, literals and closing braces have none of their own
+func TestCompiler_Locals(t *testing.T) {
+	function, errs := NewCompiler().Compile(parse(t, "{\nvar a = 1;\nvar b = a;\nb = 2;\n}\n"))
+	require.Empty(t, errs)
+
+	assert.Equal(t, []byte{
+		byte(OpConstant), 0, 0,
+		byte(OpGetLocal), 1,
+		byte(OpConstant), 0, 1,
+		byte(OpSetLocal), 2,
+		byte(OpPop),
+		byte(OpPop),
+		byte(OpPop),
+		byte(OpNil),
+		byte(OpReturn),
+	}, function.Chunk.Code)
+	assert.Equal(t, []int{2, 2, 2, 3, 3, 3, 3, 3, 4, 4, 4, 4, 4, 4, 4}, function.Chunk.Lines)
+}
+
+func TestCompiler_Closure(t *testing.T) {
+	function, errs := NewCompiler().Compile(parse(t, "fun outer(a) {\nfun inner() { return a; }\nreturn inner;\n}\n"))
+	require.Empty(t, errs)
+
+	require.Len(t, function.Chunk.Constants, 2)
+	outer, ok := function.Chunk.Constants[0].(*Function)
+	require.True(t, ok)
+	assert.Equal(t, "outer", outer.Name)
+	assert.Equal(t, 1, outer.Arity)
+	assert.Equal(t, 0, outer.UpvalueCount)
+
+	inner, ok := outer.Chunk.Constants[0].(*Function)
+	require.True(t, ok)
+	assert.Equal(t, "inner", inner.Name)
+	assert.Equal(t, 1, inner.UpvalueCount)
+	assert.Equal(t, []byte{byte(OpGetUpvalue), 0, byte(OpReturn), byte(OpNil), byte(OpReturn)}, inner.Chunk.Code)
+	// inner captures the local in slot 1 of outer
+	assert.Equal(t, []byte{byte(OpClosure), 0, 0, 1, 1}, outer.Chunk.Code[:5])
+}
+
+func TestCompiler_Try(t *testing.T) {
+	function, errs := NewCompiler().Compile(parse(t, "try {} catch (e) {}\n"))
+	require.Empty(t, errs)
+
+	assert.Equal(t, []byte{
+		byte(OpTry), 0, 4,
+		byte(OpEndTry),
+		byte(OpJump), 0, 4,
+		// the handler starts with the thrown value on the stack, in the slot of e
+		byte(OpPop),
+		byte(OpJump), 0, 0,
+		byte(OpNil),
+		byte(OpReturn),
+	}, function.Chunk.Code)
+}
+
+func TestCompiler_Errors(t *testing.T) {
+	cases := []struct {
+		name    string
+		code    string
+		message string
+	}{
+		{
+			name:    "local in its own initializer",
+			code:    "{\nvar a = a;\n}\n",
+			message: "Can't read local variable in its own initializer.",
+		},
+		{
+			name:    "too many locals",
+			code:    "{\n" + strings.Repeat("var a;\n", MaxLocals) + "}\n",
+			message: "Too many local variables in function.",
+		},
+		{
+			name:    "break outside of a loop",
+			code:    "while (false) {}\nbreak;\n",
+			message: "Can't use 'break' outside of a loop.",
+		},
+		{
+			name:    "continue in a function declared in a loop",
+			code:    "while (false) {\nfun f() { continue; }\n}\n",
+			message: "Can't use 'continue' outside of a loop.",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, errs := NewCompiler().Compile(parse(t, tc.code))
+			require.NotEmpty(t, errs)
+			assert.Equal(t, tc.message, errs[0].Error())
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Disassemble prints the chunk of function and then the chunks of every function it declares
+func Disassemble(w io.Writer, function *Function) {
+	DisassembleChunk(w, function.Chunk, function.String())
+	for _, constant := range function.Chunk.Constants {
+		if f, ok := constant.(*Function); ok {
+			_, _ = fmt.Fprintln(w)
+			Disassemble(w, f)
+		}
+	}
+}
+
+// DisassembleChunk prints every instruction of chunk followed by its constant pool.
+// Each instruction line starts with its offset and its source line, "|" marks the same line as the previous instruction.
+func DisassembleChunk(w io.Writer, chunk *Chunk, name string) {
+	_, _ = fmt.Fprintf(w, "== %s ==\n", name)
+	for offset := 0; offset < len(chunk.Code); {
+		offset = DisassembleInstruction(w, chunk, offset)
+	}
+
+	if len(chunk.Constants) == 0 {
+		return
+	}
+	_, _ = fmt.Fprintln(w, "-- constants --")
+	for i, constant := range chunk.Constants {
+		_, _ = fmt.Fprintf(w, "%04d %s\n", i, FormatConstant(constant))
+	}
+}
+
+// DisassembleInstruction prints the instruction at offset and returns the offset of the next one
+func DisassembleInstruction(w io.Writer, chunk *Chunk, offset int) int {
+	_, _ = fmt.Fprintf(w, "%04d ", offset)
+	if offset > 0 && chunk.Lines[offset] == chunk.Lines[offset-1] {
+		_, _ = fmt.Fprint(w, "   | ")
+	} else {
+		_, _ = fmt.Fprintf(w, "%4d ", chunk.Lines[offset])
+	}
+
+	op := OpCode(chunk.Code[offset])
+	switch op {
+	case OpConstant, OpGetGlobal, OpDefineGlobal, OpSetGlobal, OpGetProperty, OpSetProperty, OpGetSuper, OpClass, OpMethod:
+		return constantInstruction(w, op, chunk, offset)
+	case OpList, OpMap:
+		return shortInstruction(w, op, chunk, offset)
+	case OpGetLocal, OpSetLocal, OpGetUpvalue, OpSetUpvalue, OpCall:
+		return byteInstruction(w, op, chunk, offset)
+	case OpJump, OpJumpIfFalse, OpTry, OpTryFinally:
+		return jumpInstruction(w, op, 1, chunk, offset)
+	case OpLoop:
+		return jumpInstruction(w, op, -1, chunk, offset)
+	case OpInvoke, OpSuperInvoke:
+		return invokeInstruction(w, op, chunk, offset)
+	case OpClosure:
+		return closureInstruction(w, chunk, offset)
+	case OpNil, OpTrue, OpFalse, OpPop, OpEqual, OpGreater, OpLess, OpAdd, OpSubtract, OpMultiply, OpDivide,
+		OpNot, OpNegate, OpPrint, OpCloseUpvalue, OpReturn, OpInherit, OpGetIndex, OpSetIndex, OpIterator,
+		OpEndTry, OpThrow:
+		_, _ = fmt.Fprintln(w, op)
+		return offset + 1
+	}
+
+	_, _ = fmt.Fprintf(w, "Unknown opcode %d\n", op)
+	return offset + 1
+}
+
+// FormatConstant prints a constant pool entry the way the disassembler shows it
+func FormatConstant(constant any) string {
+	switch c := constant.(type) {
+	case float64:
+		return strconv.FormatFloat(c, 'g', -1, 64)
+	case string:
+		return strconv.Quote(c)
+	case *Function:
+		return c.String()
+	}
+	return fmt.Sprint(constant)
+}
+
+func (f *Function) String() string {
+	if f.Name == "" {
+		return "<script>"
+	}
+	return fmt.Sprintf("<fn %s>", f.Name)
+}
+
+func readShort(chunk *Chunk, offset int) int {
+	return int(chunk.Code[offset])<<8 | int(chunk.Code[offset+1])
+}
+
+func constantInstruction(w io.Writer, op OpCode, chunk *Chunk, offset int) int {
+	constant := readShort(chunk, offset+1)
+	_, _ = fmt.Fprintf(w, "%-16s %4d %s\n", op, constant, FormatConstant(chunk.Constants[constant]))
+	return offset + 3
+}
+
+func shortInstruction(w io.Writer, op OpCode, chunk *Chunk, offset int) int {
+	_, _ = fmt.Fprintf(w, "%-16s %4d\n", op, readShort(chunk, offset+1))
+	return offset + 3
+}
+
+func byteInstruction(w io.Writer, op OpCode, chunk *Chunk, offset int) int {
+	_, _ = fmt.Fprintf(w, "%-16s %4d\n", op, chunk.Code[offset+1])
+	return offset + 2
+}
+
+func jumpInstruction(w io.Writer, op OpCode, sign int, chunk *Chunk, offset int) int {
+	jump := readShort(chunk, offset+1)
+	_, _ = fmt.Fprintf(w, "%-16s %4d -> %d\n", op, offset, offset+3+sign*jump)
+	return offset + 3
+}
+
+func invokeInstruction(w io.Writer, op OpCode, chunk *Chunk, offset int) int {
+	constant := readShort(chunk, offset+1)
+	argCount := chunk.Code[offset+3]
+	_, _ = fmt.Fprintf(w, "%-16s (%d args) %4d %s\n", op, argCount, constant, FormatConstant(chunk.Constants[constant]))
+	return offset + 4
+}
+
+func closureInstruction(w io.Writer, chunk *Chunk, offset int) int {
+	constant := readShort(chunk, offset+1)
+	function := chunk.Constants[constant].(*Function)
+	_, _ = fmt.Fprintf(w, "%-16s %4d %s\n", OpClosure, constant, function)
+
+	offset += 3
+	for i := 0; i < function.UpvalueCount; i++ {
+		kind := "upvalue"
+		if chunk.Code[offset] == 1 {
+			kind = "local"
+		}
+		_, _ = fmt.Fprintf(w, "%04d    |                     %s %d\n", offset, kind, chunk.Code[offset+1])
+		offset += 2
+	}
+	return offset
+}
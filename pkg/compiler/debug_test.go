@@ -0,0 +1,55 @@
+package compiler
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	function, errs := NewCompiler().Compile(parse(t, "fun add(a) {\nreturn a + 1.5;\n}\nwhile (false) print add(\"x\");\n"))
+	require.Empty(t, errs)
+
+	out := &bytes.Buffer{}
+	Disassemble(out, function)
+	assert.Equal(t, `== <script> ==
+0000    2 OP_CLOSURE          0 <fn add>
+0003    | OP_DEFINE_GLOBAL    1 "add"
+0006    | OP_FALSE
+0007    | OP_JUMP_IF_FALSE    7 -> 23
+0010    | OP_POP
+0011    4 OP_GET_GLOBAL       1 "add"
+0014    | OP_CONSTANT         2 "x"
+0017    | OP_CALL             1
+0019    | OP_PRINT
+0020    | OP_LOOP            20 -> 6
+0023    | OP_POP
+0024    | OP_NIL
+0025    | OP_RETURN
+-- constants --
+0000 <fn add>
+0001 "add"
+0002 "x"
+
+== <fn add> ==
+0000    2 OP_GET_LOCAL        1
+0002    | OP_CONSTANT         0 1.5
+0005    | OP_ADD
+0006    | OP_RETURN
+0007    | OP_NIL
+0008    | OP_RETURN
+-- constants --
+0000 1.5
+`, out.String())
+}
+
+func TestDisassembleInstruction_Closure(t *testing.T) {
+	function, errs := NewCompiler().Compile(parse(t, "{\nvar a = 1;\nfun f() { return a; }\n}\n"))
+	require.Empty(t, errs)
+
+	out := &bytes.Buffer{}
+	offset := DisassembleInstruction(out, function.Chunk, 3)
+	assert.Equal(t, 8, offset)
+	assert.Equal(t, "0003    3 OP_CLOSURE          1 <fn f>\n0006    |                     local 1\n", out.String())
+}
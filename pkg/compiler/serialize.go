@@ -0,0 +1,484 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// A .loxc file holds a compiled script:
+//
+//	header   magic "LOXC", format version (u16)
+//	function name (string), arity (u8), upvalue count (u16), chunk
+//	chunk    code length (u32), code bytes, one line (u32) per code byte,
+//	         one span per code byte: start offset, end offset and column (u32 each),
+//	         constant count (u32), constants
+//	constant tag (u8) followed by a number (float64 bits, u64), a string or a function
+//	string   length (u32), bytes
+//	trailer  CRC-32 (IEEE) of everything before it (u32)
+//
+// All integers are big endian.
+
+const (
+	Magic         = "LOXC"
+	FormatVersion = 2
+
+	constantNumber   byte = 1
+	constantString   byte = 2
+	constantFunction byte = 3
+)
+
+// ErrInvalidBytecode is wrapped by every error returned when a .loxc file can't be loaded
+var ErrInvalidBytecode = errors.New("invalid bytecode file")
+
+// Encode writes function in the .loxc format
+func Encode(w io.Writer, function *Function) error {
+	buf := &bytes.Buffer{}
+	buf.WriteString(Magic)
+	writeUint16(buf, FormatVersion)
+	encodeFunction(buf, function)
+	writeUint32(buf, crc32.ChecksumIEEE(buf.Bytes()))
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Decode loads a function written by Encode.
+// It rejects files with another format version, a bad checksum, or bytecode the VM could not execute safely:
+// unknown opcodes, truncated operands, constants of the wrong kind, jumps outside their chunk, upvalues the enclosing
+// function doesn't have, instructions that read past either end of the stack and unbalanced try statements.
+// Values of the wrong type are left to the VM, which reports them as runtime errors.
+func Decode(data []byte) (*Function, error) {
+	if len(data) < len(Magic)+2+4 || string(data[:len(Magic)]) != Magic {
+		return nil, fmt.Errorf("%w: missing %s header", ErrInvalidBytecode, Magic)
+	}
+	if version := binary.BigEndian.Uint16(data[len(Magic):]); version != FormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d, expected %d", ErrInvalidBytecode, version, FormatVersion)
+	}
+
+	body, trailer := data[:len(data)-4], data[len(data)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(trailer) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidBytecode)
+	}
+
+	d := &decoder{data: body, offset: len(Magic) + 2}
+	function := d.function()
+	// the VM calls the script without arguments and with no enclosing function to capture upvalues from
+	if d.err == nil && (function.Arity > 0 || function.UpvalueCount > 0) {
+		d.fail("the script has %d parameters and %d upvalues", function.Arity, function.UpvalueCount)
+	}
+	if d.err == nil && d.offset != len(d.data) {
+		d.fail("%d unexpected bytes after the script", len(d.data)-d.offset)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return function, nil
+}
+
+func encodeFunction(buf *bytes.Buffer, function *Function) {
+	writeString(buf, function.Name)
+	buf.WriteByte(byte(function.Arity))
+	writeUint16(buf, uint16(function.UpvalueCount))
+
+	chunk := function.Chunk
+	writeUint32(buf, uint32(len(chunk.Code)))
+	buf.Write(chunk.Code)
+	for _, line := range chunk.Lines {
+		writeUint32(buf, uint32(line))
+	}
+	for _, span := range chunk.Spans {
+		writeUint32(buf, uint32(span.Start))
+		writeUint32(buf, uint32(span.End))
+		writeUint32(buf, uint32(span.Column))
+	}
+
+	writeUint32(buf, uint32(len(chunk.Constants)))
+	for _, constant := range chunk.Constants {
+		switch c := constant.(type) {
+		case float64:
+			buf.WriteByte(constantNumber)
+			writeUint64(buf, math.Float64bits(c))
+		case string:
+			buf.WriteByte(constantString)
+			writeString(buf, c)
+		case *Function:
+			buf.WriteByte(constantFunction)
+			encodeFunction(buf, c)
+		}
+	}
+}
+
+func writeUint16(buf *bytes.Buffer, v uint16) {
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	_ = binary.Write(buf, binary.BigEndian, v)
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUint32(buf, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+// decoder reads from data and keeps the first error, every read after it returns zero values
+type decoder struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (d *decoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrInvalidBytecode, fmt.Sprintf(format, args...))
+	}
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data)-d.offset {
+		d.fail("unexpected end of file at byte %d", d.offset)
+		return nil
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+	return b
+}
+
+func (d *decoder) uint8() byte {
+	b := d.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) uint16() int {
+	b := d.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(b))
+}
+
+func (d *decoder) uint32() int {
+	b := d.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.uint32()))
+}
+
+func (d *decoder) function() *Function {
+	function := &Function{
+		Name:         d.string(),
+		Arity:        int(d.uint8()),
+		UpvalueCount: d.uint16(),
+		Chunk:        NewChunk(),
+	}
+	if function.UpvalueCount > MaxUpvalues {
+		d.fail("function %s has %d upvalues", function, function.UpvalueCount)
+	}
+
+	chunk := function.Chunk
+	chunk.Code = append([]byte(nil), d.bytes(d.uint32())...)
+	chunk.Lines = make([]int, 0, len(chunk.Code))
+	for range chunk.Code {
+		if d.err != nil {
+			break
+		}
+		chunk.Lines = append(chunk.Lines, d.uint32())
+	}
+	chunk.Spans = make([]Span, 0, len(chunk.Code))
+	for range chunk.Code {
+		if d.err != nil {
+			break
+		}
+		chunk.Spans = append(chunk.Spans, Span{Start: d.uint32(), End: d.uint32(), Column: d.uint32()})
+	}
+
+	count := d.uint32()
+	if count > MaxConstants {
+		d.fail("function %s has %d constants", function, count)
+	}
+	for i := 0; i < count && d.err == nil; i++ {
+		switch tag := d.uint8(); tag {
+		case constantNumber:
+			b := d.bytes(8)
+			if b != nil {
+				chunk.Constants = append(chunk.Constants, math.Float64frombits(binary.BigEndian.Uint64(b)))
+			}
+		case constantString:
+			chunk.Constants = append(chunk.Constants, d.string())
+		case constantFunction:
+			chunk.Constants = append(chunk.Constants, d.function())
+		default:
+			d.fail("unknown constant tag %d in function %s", tag, function)
+		}
+	}
+
+	if d.err == nil {
+		if err := verify(function); err != nil {
+			d.fail("function %s: %s", function, err)
+		}
+	}
+	return function
+}
+
+// verify checks that every instruction of function is complete and refers to existing constants, upvalues and offsets
+func verify(function *Function) error {
+	chunk := function.Chunk
+	constant := func(offset int) (any, error) {
+		index := readShort(chunk, offset)
+		if index >= len(chunk.Constants) {
+			return nil, fmt.Errorf("constant %d out of range at %04d", index, offset)
+		}
+		return chunk.Constants[index], nil
+	}
+	name := func(offset int) error {
+		c, err := constant(offset)
+		if err != nil {
+			return err
+		}
+		if _, ok := c.(string); !ok {
+			return fmt.Errorf("constant at %04d is not a name", offset)
+		}
+		return nil
+	}
+
+	// jumps must land on the first byte of an instruction, they are checked once every instruction is known
+	lengths := make(map[int]int)
+	jumps := make(map[int]int)
+	// the last instruction must return, so execution can't run past the end of the chunk
+	last := OpNil
+
+	for offset := 0; offset < len(chunk.Code); {
+		op := OpCode(chunk.Code[offset])
+		last = op
+		length := 1
+		switch op {
+		case OpConstant, OpGetGlobal, OpDefineGlobal, OpSetGlobal, OpGetProperty, OpSetProperty, OpGetSuper,
+			OpClass, OpMethod, OpJump, OpJumpIfFalse, OpLoop, OpClosure, OpList, OpMap, OpTry, OpTryFinally:
+			length = 3
+		case OpGetLocal, OpSetLocal, OpGetUpvalue, OpSetUpvalue, OpCall:
+			length = 2
+		case OpInvoke, OpSuperInvoke:
+			length = 4
+		case OpNil, OpTrue, OpFalse, OpPop, OpEqual, OpGreater, OpLess, OpAdd, OpSubtract, OpMultiply, OpDivide,
+			OpNot, OpNegate, OpPrint, OpCloseUpvalue, OpReturn, OpInherit, OpGetIndex, OpSetIndex, OpIterator,
+			OpEndTry, OpThrow:
+		default:
+			return fmt.Errorf("unknown opcode %d at %04d", op, offset)
+		}
+		if offset+length > len(chunk.Code) {
+			return fmt.Errorf("truncated %s at %04d", op, offset)
+		}
+
+		var err error
+		switch op {
+		case OpConstant:
+			var c any
+			if c, err = constant(offset + 1); err == nil {
+				if _, ok := c.(*Function); ok {
+					err = fmt.Errorf("%s at %04d loads a function", op, offset)
+				}
+			}
+		case OpGetGlobal, OpDefineGlobal, OpSetGlobal, OpGetProperty, OpSetProperty, OpGetSuper, OpClass, OpMethod,
+			OpInvoke, OpSuperInvoke:
+			err = name(offset + 1)
+		case OpGetUpvalue, OpSetUpvalue:
+			if index := int(chunk.Code[offset+1]); index >= function.UpvalueCount {
+				err = fmt.Errorf("upvalue %d out of range at %04d", index, offset)
+			}
+		case OpJump, OpJumpIfFalse, OpLoop, OpTry, OpTryFinally:
+			target := offset + 3 + readShort(chunk, offset+1)
+			if op == OpLoop {
+				target = offset + 3 - readShort(chunk, offset+1)
+			}
+			jumps[offset] = target
+		case OpClosure:
+			var c any
+			if c, err = constant(offset + 1); err == nil {
+				closure, ok := c.(*Function)
+				if !ok {
+					err = fmt.Errorf("%s at %04d doesn't load a function", op, offset)
+					break
+				}
+				length += 2 * closure.UpvalueCount
+				if offset+length > len(chunk.Code) {
+					err = fmt.Errorf("truncated %s at %04d", op, offset)
+					break
+				}
+				for i := offset + 3; i < offset+length; i += 2 {
+					isLocal, index := chunk.Code[i], int(chunk.Code[i+1])
+					if isLocal > 1 || (isLocal == 0 && index >= function.UpvalueCount) {
+						err = fmt.Errorf("invalid upvalue %d at %04d", index, i)
+						break
+					}
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		lengths[offset] = length
+		offset += length
+	}
+
+	if last != OpReturn {
+		return errors.New("code doesn't end with OP_RETURN")
+	}
+	for offset, target := range jumps {
+		if _, found := lengths[target]; !found {
+			return fmt.Errorf("%s at %04d jumps to %d", OpCode(chunk.Code[offset]), offset, target)
+		}
+	}
+	return verifyStack(function, lengths, jumps)
+}
+
+// frameState is what verifyStack knows about a call frame before an instruction runs
+type frameState struct {
+	// height is the number of stack slots the frame uses, the callee in slot 0 included
+	height int
+	// handlers holds the height every exception handler installed by the frame unwinds the stack to, innermost last
+	handlers []int
+}
+
+// verifyStack follows every path through the code of function, from its first instruction and from its exception handlers.
+// It checks that no instruction reads below the frame, the top of the stack or a local slot that isn't there,
+// that a try statement's handler stays under the values the instructions pop, that OP_END_TRY only removes handlers
+// installed by the function and that none is left when it returns.
+// Paths that meet must agree on the stack height and the handlers, as they always do in the compiler's output.
+// Code no path reaches is never run, so it isn't checked.
+func verifyStack(function *Function, lengths map[int]int, jumps map[int]int) error {
+	chunk := function.Chunk
+	states := make(map[int]frameState)
+	var work []int
+	reach := func(offset int, state frameState) error {
+		seen, found := states[offset]
+		if !found {
+			states[offset] = state
+			work = append(work, offset)
+			return nil
+		}
+		if seen.height != state.height || len(seen.handlers) != len(state.handlers) {
+			return fmt.Errorf("paths to %04d disagree on the stack", offset)
+		}
+		for h := range seen.handlers {
+			if seen.handlers[h] != state.handlers[h] {
+				return fmt.Errorf("paths to %04d disagree on the exception handlers", offset)
+			}
+		}
+		return nil
+	}
+
+	if err := reach(0, frameState{height: function.Arity + 1}); err != nil {
+		return err
+	}
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+		state := states[offset]
+		op := OpCode(chunk.Code[offset])
+
+		// pops are the values the instruction takes from the top of the stack, pushes the values it leaves there
+		pops, pushes := 0, 0
+		local := -1
+		switch op {
+		case OpConstant, OpNil, OpTrue, OpFalse, OpGetGlobal, OpGetUpvalue, OpClass:
+			pushes = 1
+		case OpGetLocal:
+			local, pushes = int(chunk.Code[offset+1]), 1
+		case OpSetLocal:
+			local, pops, pushes = int(chunk.Code[offset+1]), 1, 1
+		case OpSetGlobal, OpSetUpvalue, OpGetProperty, OpNot, OpNegate, OpJumpIfFalse, OpIterator:
+			pops, pushes = 1, 1
+		case OpPop, OpDefineGlobal, OpPrint, OpCloseUpvalue, OpReturn, OpThrow:
+			pops = 1
+		case OpSetProperty, OpGetSuper, OpEqual, OpGreater, OpLess, OpAdd, OpSubtract, OpMultiply, OpDivide,
+			OpInherit, OpMethod, OpGetIndex:
+			pops, pushes = 2, 1
+		case OpSetIndex:
+			pops, pushes = 3, 1
+		case OpCall:
+			pops, pushes = int(chunk.Code[offset+1])+1, 1
+		case OpInvoke:
+			pops, pushes = int(chunk.Code[offset+3])+1, 1
+		case OpSuperInvoke:
+			pops, pushes = int(chunk.Code[offset+3])+2, 1
+		case OpList:
+			pops, pushes = readShort(chunk, offset+1), 1
+		case OpMap:
+			pops, pushes = 2*readShort(chunk, offset+1), 1
+		case OpClosure:
+			pushes = 1
+			for i := offset + 3; i < offset+lengths[offset]; i += 2 {
+				if chunk.Code[i] == 1 && int(chunk.Code[i+1]) >= state.height {
+					return fmt.Errorf("%s at %04d captures local %d of a stack of %d", op, offset, chunk.Code[i+1], state.height)
+				}
+			}
+		}
+
+		if local >= state.height {
+			return fmt.Errorf("%s at %04d reads local %d of a stack of %d", op, offset, local, state.height)
+		}
+		if pops > state.height {
+			return fmt.Errorf("%s at %04d needs %d values on a stack of %d", op, offset, pops, state.height)
+		}
+		if n := len(state.handlers); n > 0 && op != OpReturn && op != OpThrow && state.height-pops < state.handlers[n-1] {
+			return fmt.Errorf("%s at %04d pops below the stack of its exception handler", op, offset)
+		}
+		next := frameState{height: state.height - pops + pushes, handlers: state.handlers}
+
+		var err error
+		switch op {
+		case OpReturn:
+			if len(state.handlers) > 0 {
+				return fmt.Errorf("%s at %04d leaves %d exception handlers installed", op, offset, len(state.handlers))
+			}
+			continue
+		case OpThrow:
+			continue
+		case OpJump, OpLoop:
+			err = reach(jumps[offset], next)
+		case OpJumpIfFalse:
+			if err = reach(jumps[offset], next); err == nil {
+				err = reach(offset+lengths[offset], next)
+			}
+		case OpTry, OpTryFinally:
+			// the handler runs with the stack unwound to its height and the exception pushed
+			if err = reach(jumps[offset], frameState{height: state.height + 1, handlers: state.handlers}); err == nil {
+				handlers := append(append([]int(nil), state.handlers...), state.height)
+				err = reach(offset+lengths[offset], frameState{height: next.height, handlers: handlers})
+			}
+		case OpEndTry:
+			if len(state.handlers) == 0 {
+				err = fmt.Errorf("%s at %04d has no exception handler to remove", op, offset)
+				break
+			}
+			next.handlers = state.handlers[:len(state.handlers)-1]
+			err = reach(offset+lengths[offset], next)
+		default:
+			err = reach(offset+lengths[offset], next)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
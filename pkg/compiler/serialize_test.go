@@ -0,0 +1,287 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func encode(t *testing.T, code string) []byte {
+	function, errs := NewCompiler().Compile(parse(t, code))
+	require.Empty(t, errs)
+	buf := &bytes.Buffer{}
+	require.NoError(t, Encode(buf, function))
+	return buf.Bytes()
+}
+
+// resign replaces the checksum of data, so tests can reach the checks after it
+func resign(data []byte) []byte {
+	body := data[:len(data)-4]
+	return binary.BigEndian.AppendUint32(append([]byte(nil), body...), crc32.ChecksumIEEE(body))
+}
+
+func TestEncodeDecode_Examples(t *testing.T) {
+	examples, err := filepath.Glob(filepath.Join("../../example", "*.lox"))
+	require.NoError(t, err)
+	require.NotEmpty(t, examples)
+
+	for _, example := range examples {
+		t.Run(filepath.Base(example), func(t *testing.T) {
+			source, err := os.ReadFile(example)
+			require.NoError(t, err)
+			function, errs := NewCompiler().Compile(parse(t, string(source)))
+			require.Empty(t, errs)
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, Encode(buf, function))
+			assert.Equal(t, Magic, buf.String()[:len(Magic)])
+
+			decoded, err := Decode(buf.Bytes())
+			require.NoError(t, err)
+			assert.Equal(t, string(source), function.Chunk.Source)
+			// the source isn't saved, everything else comes back
+			withoutSource(function)
+			assert.Equal(t, function, decoded)
+		})
+	}
+}
+
+// withoutSource clears the source of the chunks of function and of the functions it defines
+func withoutSource(function *Function) {
+	function.Chunk.Source = ""
+	for _, constant := range function.Chunk.Constants {
+		if f, ok := constant.(*Function); ok {
+			withoutSource(f)
+		}
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	// the script chunk of "print 1;" starts after the header and the empty name, arity and upvalue count
+	valid := encode(t, "print 1;\n")
+	codeStart := len(Magic) + 2 + 4 + 1 + 2 + 4
+
+	cases := []struct {
+		name    string
+		data    func() []byte
+		message string
+	}{
+		{
+			name:    "empty",
+			data:    func() []byte { return nil },
+			message: "invalid bytecode file: missing LOXC header",
+		},
+		{
+			name: "wrong version",
+			data: func() []byte {
+				data := append([]byte(nil), valid...)
+				binary.BigEndian.PutUint16(data[len(Magic):], FormatVersion+1)
+				return data
+			},
+			message: "invalid bytecode file: unsupported format version 3, expected 2",
+		},
+		{
+			name: "corrupted",
+			data: func() []byte {
+				data := append([]byte(nil), valid...)
+				data[codeStart]++
+				return data
+			},
+			message: "invalid bytecode file: checksum mismatch",
+		},
+		{
+			name: "truncated",
+			data: func() []byte {
+				return resign(valid[:codeStart+4])
+			},
+			message: "invalid bytecode file: unexpected end of file at byte 17",
+		},
+		{
+			name: "unknown opcode",
+			data: func() []byte {
+				data := append([]byte(nil), valid...)
+				data[codeStart+3] = 0xff
+				return resign(data)
+			},
+			message: "invalid bytecode file: function <script>: unknown opcode 255 at 0003",
+		},
+		{
+			name: "constant out of range",
+			data: func() []byte {
+				data := append([]byte(nil), valid...)
+				data[codeStart+2] = 7
+				return resign(data)
+			},
+			message: "invalid bytecode file: function <script>: constant 7 out of range at 0001",
+		},
+		{
+			name: "missing return",
+			data: func() []byte {
+				data := append([]byte(nil), valid...)
+				data[codeStart+5] = byte(OpPop)
+				return resign(data)
+			},
+			message: "invalid bytecode file: function <script>: code doesn't end with OP_RETURN",
+		},
+		{
+			name: "trailing bytes",
+			data: func() []byte {
+				data := append([]byte(nil), valid[:len(valid)-4]...)
+				return resign(append(data, 0, 0, 0, 0, 0))
+			},
+			message: "invalid bytecode file: 1 unexpected bytes after the script",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(tc.data())
+			require.ErrorIs(t, err, ErrInvalidBytecode)
+			assert.Equal(t, tc.message, err.Error())
+		})
+	}
+}
+
+func TestDecode_JumpIntoOperand(t *testing.T) {
+	valid := encode(t, "while (false) print 1;\n")
+	function, err := Decode(valid)
+	require.NoError(t, err)
+
+	// make the loop land on the operand of OP_JUMP_IF_FALSE instead of the condition
+	code := function.Chunk.Code
+	loop := bytes.IndexByte(code, byte(OpLoop))
+	require.Greater(t, loop, 0)
+	offset := readShort(function.Chunk, loop+1)
+	binary.BigEndian.PutUint16(code[loop+1:], uint16(offset-2))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, Encode(buf, function))
+	_, err = Decode(buf.Bytes())
+	require.ErrorIs(t, err, ErrInvalidBytecode)
+	assert.Contains(t, err.Error(), "OP_LOOP at")
+}
+
+// encodeCode writes a script made of code, every instruction on line 1
+func encodeCode(t *testing.T, code ...byte) []byte {
+	buf := &bytes.Buffer{}
+	require.NoError(t, Encode(buf, &Function{Chunk: newCodeChunk(code...)}))
+	return buf.Bytes()
+}
+
+// newCodeChunk makes a chunk of code without constants, every instruction on line 1
+func newCodeChunk(code ...byte) *Chunk {
+	chunk := NewChunk()
+	for _, b := range code {
+		chunk.Write(b, 1, Span{})
+	}
+	return chunk
+}
+
+func TestDecode_MissingUpvalues(t *testing.T) {
+	// a function reading the first upvalue of the function it is defined in
+	inner := &Function{Name: "f", UpvalueCount: 1, Chunk: newCodeChunk(byte(OpGetUpvalue), 0, byte(OpReturn))}
+	closure := newCodeChunk(byte(OpClosure), 0, 0, 0, 0, byte(OpReturn))
+	closure.Constants = append(closure.Constants, inner)
+
+	cases := []struct {
+		name     string
+		function *Function
+		message  string
+	}{
+		{
+			name:     "script with upvalues",
+			function: &Function{UpvalueCount: 1, Chunk: newCodeChunk(byte(OpGetUpvalue), 0, byte(OpReturn))},
+			message:  "invalid bytecode file: the script has 0 parameters and 1 upvalues",
+		},
+		{
+			name:     "script with parameters",
+			function: &Function{Arity: 1, Chunk: newCodeChunk(byte(OpNil), byte(OpReturn))},
+			message:  "invalid bytecode file: the script has 1 parameters and 0 upvalues",
+		},
+		{
+			name:     "script capturing an upvalue",
+			function: &Function{Chunk: closure},
+			message:  "invalid bytecode file: function <script>: invalid upvalue 0 at 0003",
+		},
+		{
+			name: "function capturing an upvalue it doesn't have",
+			function: &Function{Chunk: func() *Chunk {
+				chunk := newCodeChunk(byte(OpClosure), 0, 0, byte(OpNil), byte(OpReturn))
+				chunk.Constants = append(chunk.Constants, &Function{Name: "g", Chunk: closure})
+				return chunk
+			}()},
+			message: "invalid bytecode file: function <fn g>: invalid upvalue 0 at 0003",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			require.NoError(t, Encode(buf, tc.function))
+			_, err := Decode(buf.Bytes())
+			require.ErrorIs(t, err, ErrInvalidBytecode)
+			assert.Equal(t, tc.message, err.Error())
+		})
+	}
+}
+
+func TestDecode_UnsafeStack(t *testing.T) {
+	cases := []struct {
+		name    string
+		code    []byte
+		message string
+	}{
+		{
+			name:    "pop below the frame",
+			code:    []byte{byte(OpPop), byte(OpPop), byte(OpNil), byte(OpReturn)},
+			message: "OP_POP at 0001 needs 1 values on a stack of 0",
+		},
+		{
+			name:    "end try without a handler",
+			code:    []byte{byte(OpEndTry), byte(OpNil), byte(OpReturn)},
+			message: "OP_END_TRY at 0000 has no exception handler to remove",
+		},
+		{
+			name:    "return with a handler installed",
+			code:    []byte{byte(OpTry), 0, 2, byte(OpNil), byte(OpReturn), byte(OpPop), byte(OpNil), byte(OpReturn)},
+			message: "OP_RETURN at 0004 leaves 1 exception handlers installed",
+		},
+		{
+			name:    "pop below the handler",
+			code:    []byte{byte(OpNil), byte(OpTry), 0, 3, byte(OpPop), byte(OpEndTry), byte(OpReturn), byte(OpNil), byte(OpReturn)},
+			message: "OP_POP at 0004 pops below the stack of its exception handler",
+		},
+		{
+			name:    "local past the top of the stack",
+			code:    []byte{byte(OpGetLocal), 5, byte(OpReturn)},
+			message: "OP_GET_LOCAL at 0000 reads local 5 of a stack of 1",
+		},
+		{
+			name:    "call with missing arguments",
+			code:    []byte{byte(OpNil), byte(OpCall), 3, byte(OpReturn)},
+			message: "OP_CALL at 0001 needs 4 values on a stack of 2",
+		},
+		{
+			name:    "paths with different heights",
+			code:    []byte{byte(OpTrue), byte(OpJumpIfFalse), 0, 1, byte(OpNil), byte(OpReturn)},
+			message: "paths to 0005 disagree on the stack",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Decode(encodeCode(t, tc.code...))
+			require.ErrorIs(t, err, ErrInvalidBytecode)
+			assert.Equal(t, "invalid bytecode file: function <script>: "+tc.message, err.Error())
+		})
+	}
+
+	// code after a return is never run, so it isn't checked
+	_, err := Decode(encodeCode(t, byte(OpNil), byte(OpReturn), byte(OpPop), byte(OpPop), byte(OpReturn)))
+	assert.NoError(t, err)
+}
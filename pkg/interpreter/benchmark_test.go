@@ -0,0 +1,78 @@
+package interpreter
+
+import (
+	"io"
+	"testing"
+)
+
+var benchmarks = []struct {
+	name string
+	code string
+}{
+	{
+		name: "fib loop",
+		code: `var n = 0;
+while (n < 1000) {
+    var a = 0;
+    var temp;
+    for (var b = 1; a < 10000; b = temp + b) {
+        temp = a;
+        a = b;
+    }
+    n = n + 1;
+}
+`,
+	},
+	{
+		name: "fib recursive",
+		code: `fun fib(n) {
+    if (n < 2) return n;
+    return fib(n - 1) + fib(n - 2);
+}
+fib(20);
+`,
+	},
+	{
+		name: "nested loops",
+		code: `fun sum(n) {
+    var total = 0;
+    for (var i = 0; i < n; i = i + 1) {
+        for (var j = 0; j < n; j = j + 1) {
+            total = total + i * j;
+        }
+    }
+    return total;
+}
+sum(300);
+`,
+	},
+	{
+		name: "methods",
+		code: `class Counter {
+    init() { this.count = 0; }
+    inc() { this.count = this.count + 1; return this; }
+}
+var c = Counter();
+for (var i = 0; i < 10000; i = i + 1) c.inc().inc();
+`,
+	},
+}
+
+func BenchmarkInterpreter_Interpret(b *testing.B) {
+	for _, bm := range benchmarks {
+		for _, mode := range modes {
+			bm, mode := bm, mode
+			b.Run(bm.name+"/"+mode.name, func(b *testing.B) {
+				statements, locals := parse(b, bm.code)
+				in := NewInterpreter(Config{Stdout: io.Discard, CompileClosures: mode.compileClosures})
+
+				b.ResetTimer()
+				for n := 0; n < b.N; n++ {
+					if _, err := in.Interpret(statements, locals); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/ast"
+	"github.com/mtvarkovsky/golox/pkg/resolver"
+	"github.com/mtvarkovsky/golox/pkg/tokens"
+	"time"
+)
+
+type (
+	LoxCallable interface {
+		Arity() int
+		Call(i *interpreter, arguments []Value) (Value, error)
+	}
+
+	loxFunction struct {
+		declaration   ast.FunctionStatement
+		closure       Environment
+		isInitializer bool
+		// body is the compiled body when the program was compiled to closures, nil when it is walked
+		body []execFunc
+		// locals are the resolved slots of the program that declared the function, the walked body looks its variables up there
+		locals resolver.Locals
+	}
+
+	nativeFunction struct {
+		name  string
+		arity int
+		fn    func(arguments []Value) (Value, error)
+	}
+
+	// returnValue unwinds the statements of a function body up to the enclosing call
+	returnValue struct {
+		keyword tokens.Token
+		value   Value
+	}
+
+	// loopJump unwinds the statements of a loop body up to the loop, keyword is the break or continue that started it
+	loopJump struct {
+		keyword tokens.Token
+	}
+)
+
+var _ LoxCallable = (*loxFunction)(nil)
+var _ LoxCallable = (*nativeFunction)(nil)
+
+// newLoxFunction captures the environment the function was declared in,
+// so the function keeps it alive for as long as the function itself is reachable
+func newLoxFunction(declaration ast.FunctionStatement, closure Environment, isInitializer bool, locals resolver.Locals) *loxFunction {
+	return &loxFunction{
+		declaration:   declaration,
+		closure:       closure,
+		isInitializer: isInitializer,
+		locals:        locals,
+	}
+}
+
+// bind returns a copy of the method whose closure defines "this" as the given instance
+func (f *loxFunction) bind(instance *loxInstance) *loxFunction {
+	env := NewEnvironment(f.closure)
+	env.Define("this", newInstanceValue(instance))
+	bound := newLoxFunction(f.declaration, env, f.isInitializer, f.locals)
+	bound.body = f.body
+	return bound
+}
+
+func (f *loxFunction) Arity() int {
+	return len(f.declaration.Params())
+}
+
+func (f *loxFunction) Call(i *interpreter, arguments []Value) (Value, error) {
+	env := NewEnvironment(f.closure)
+	for i, param := range f.declaration.Params() {
+		env.Define(param.Lexeme(), arguments[i])
+	}
+
+	var err error
+	if f.body != nil {
+		err = i.runBlock(f.body, env)
+	} else {
+		// the function may have been declared by an earlier program than the one running
+		locals := i.locals
+		i.locals = f.locals
+		err = i.executeBlock(f.declaration.Body(), env)
+		i.locals = locals
+	}
+	if ret, ok := err.(*returnValue); ok {
+		if f.isInitializer {
+			return f.this(), nil
+		}
+		return ret.value, nil
+	}
+	if err != nil {
+		return Nil, err
+	}
+	if f.isInitializer {
+		return f.this(), nil
+	}
+	return Nil, nil
+}
+
+// this returns the instance an initializer is bound to, initializers always return it.
+// "this" is the only variable of the environment bind creates.
+func (f *loxFunction) this() Value {
+	return f.closure.GetAt(0, 0)
+}
+
+func (f *loxFunction) String() string {
+	return fmt.Sprintf("<fn %s>", f.declaration.Name().Lexeme())
+}
+
+func newNativeFunction(name string, arity int, fn func(arguments []Value) (Value, error)) LoxCallable {
+	return &nativeFunction{
+		name:  name,
+		arity: arity,
+		fn:    fn,
+	}
+}
+
+func (f *nativeFunction) Arity() int {
+	return f.arity
+}
+
+func (f *nativeFunction) Call(_ *interpreter, arguments []Value) (Value, error) {
+	return f.fn(arguments)
+}
+
+func (f *nativeFunction) String() string {
+	return "<native fn>"
+}
+
+func clock(_ []Value) (Value, error) {
+	return NewNumber(float64(time.Now().UnixNano()) / float64(time.Second)), nil
+}
+
+func (r *returnValue) Error() string {
+	return "can't return from top-level code"
+}
+
+func (j *loopJump) Error() string {
+	return fmt.Sprintf("can't use '%s' outside of a loop", j.keyword.Lexeme())
+}
+
+// endsLoop handles the error a loop body returned. A continue statement only ends the iteration,
+// a break statement ends the loop and any other error ends the loop and is returned from it.
+func endsLoop(err error) (bool, error) {
+	if jump, ok := err.(*loopJump); ok {
+		return jump.keyword.Type() == tokens.Break, nil
+	}
+	return err != nil, err
+}
@@ -0,0 +1,93 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/tokens"
+)
+
+type (
+	loxClass struct {
+		name       string
+		superclass *loxClass
+		methods    map[string]*loxFunction
+	}
+
+	loxInstance struct {
+		class  *loxClass
+		fields map[string]Value
+	}
+)
+
+var _ LoxCallable = (*loxClass)(nil)
+
+func newLoxClass(name string, superclass *loxClass, methods map[string]*loxFunction) *loxClass {
+	return &loxClass{
+		name:       name,
+		superclass: superclass,
+		methods:    methods,
+	}
+}
+
+// findMethod looks the method up in the class and then along its superclass chain
+func (c *loxClass) findMethod(name string) *loxFunction {
+	if method, found := c.methods[name]; found {
+		return method
+	}
+	if c.superclass != nil {
+		return c.superclass.findMethod(name)
+	}
+	return nil
+}
+
+func (c *loxClass) Arity() int {
+	if initializer := c.findMethod("init"); initializer != nil {
+		return initializer.Arity()
+	}
+	return 0
+}
+
+// Call creates a new instance of the class and runs its initializer, if the class has one
+func (c *loxClass) Call(i *interpreter, arguments []Value) (Value, error) {
+	instance := newLoxInstance(c)
+	if initializer := c.findMethod("init"); initializer != nil {
+		if _, err := initializer.bind(instance).Call(i, arguments); err != nil {
+			return Nil, err
+		}
+	}
+	return newInstanceValue(instance), nil
+}
+
+func (c *loxClass) String() string {
+	return c.name
+}
+
+func newLoxInstance(class *loxClass) *loxInstance {
+	return &loxInstance{
+		class:  class,
+		fields: make(map[string]Value),
+	}
+}
+
+// Get looks the property up in the instance fields first, so fields shadow methods
+func (i *loxInstance) Get(name tokens.Token) (Value, error) {
+	if value, found := i.fields[name.Lexeme()]; found {
+		return value, nil
+	}
+
+	if method := i.class.findMethod(name.Lexeme()); method != nil {
+		return newFunctionValue(method.bind(i)), nil
+	}
+
+	return Nil, &RuntimeError{
+		Token: name,
+		err:   fmt.Errorf("undefined property '%s'", name.Lexeme()),
+	}
+}
+
+func (i *loxInstance) Set(name tokens.Token, value Value) {
+	i.fields[name.Lexeme()] = value
+}
+
+func (i *loxInstance) String() string {
+	return fmt.Sprintf("%s instance", i.class.name)
+}
@@ -0,0 +1,681 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/ast"
+	"github.com/mtvarkovsky/golox/pkg/resolver"
+	"github.com/mtvarkovsky/golox/pkg/tokens"
+)
+
+type (
+	// execFunc and evalFunc are statements and expressions compiled to Go closures.
+	// Everything that can be decided by looking at the syntax tree, like the operator of a binary expression
+	// or the slot of a local variable, is decided once when the closure is built.
+	execFunc func(i *interpreter) error
+	evalFunc func(i *interpreter) (Value, error)
+
+	// closureCompiler turns the syntax tree of a resolved program into closures run by the interpreter
+	closureCompiler struct {
+		locals resolver.Locals
+		// countSteps makes every closure account for itself against Config.MaxSteps,
+		// when there's no limit the closures skip it altogether
+		countSteps bool
+	}
+)
+
+// runBlock is executeBlock for compiled statements
+func (i *interpreter) runBlock(statements []execFunc, env Environment) error {
+	outerEnv := i.env
+	i.env = env
+	defer func() {
+		i.env = outerEnv
+	}()
+	for _, statement := range statements {
+		if err := statement(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *closureCompiler) block(statements []ast.Statement) []execFunc {
+	compiled := make([]execFunc, 0, len(statements))
+	for _, statement := range statements {
+		compiled = append(compiled, c.statement(statement))
+	}
+	return compiled
+}
+
+func (c *closureCompiler) statement(statement ast.Statement) execFunc {
+	exec := c.compileStatement(statement)
+	if !c.countSteps {
+		return exec
+	}
+	return func(i *interpreter) error {
+		if err := i.step(); err != nil {
+			return err
+		}
+		return exec(i)
+	}
+}
+
+func (c *closureCompiler) expression(expression ast.Expression) evalFunc {
+	eval := c.compileExpression(expression)
+	if !c.countSteps {
+		return eval
+	}
+	return func(i *interpreter) (Value, error) {
+		if err := i.step(); err != nil {
+			return Nil, err
+		}
+		return eval(i)
+	}
+}
+
+func (c *closureCompiler) compileStatement(statement ast.Statement) execFunc {
+	switch statement.Type() {
+	case ast.ExpressionStatementStatementType:
+		expression := c.expression(statement.(ast.ExpressionStatement).Expression())
+		return func(i *interpreter) error {
+			_, err := expression(i)
+			return err
+		}
+	case ast.PrintStatementStatementType:
+		expression := c.expression(statement.(ast.PrintStatement).Expression())
+		return func(i *interpreter) error {
+			value, err := expression(i)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintln(i.config.Stdout, value.String())
+			return err
+		}
+	case ast.VarStatementStatementType:
+		return c.varStatement(statement.(ast.VarStatement))
+	case ast.BlockStatementStatementType:
+		statements := c.block(statement.(ast.BlockStatement).Statements())
+		return func(i *interpreter) error {
+			return i.runBlock(statements, NewEnvironment(i.env))
+		}
+	case ast.IfStatementStatementType:
+		return c.ifStatement(statement.(ast.IfStatement))
+	case ast.WhileStatementStatementType:
+		return c.whileStatement(statement.(ast.WhileStatement))
+	case ast.ForInStatementStatementType:
+		return c.forInStatement(statement.(ast.ForInStatement))
+	case ast.FunctionStatementStatementType:
+		declaration := statement.(ast.FunctionStatement)
+		body := c.block(declaration.Body())
+		return func(i *interpreter) error {
+			function := newLoxFunction(declaration, i.env, false, nil)
+			function.body = body
+			i.env.Define(declaration.Name().Lexeme(), newFunctionValue(function))
+			return nil
+		}
+	case ast.ClassStatementStatementType:
+		return c.classStatement(statement.(ast.ClassStatement))
+	case ast.ReturnStatementStatementType:
+		return c.returnStatement(statement.(ast.ReturnStatement))
+	case ast.ThrowStatementStatementType:
+		throwStatement := statement.(ast.ThrowStatement)
+		keyword := throwStatement.Keyword()
+		value := c.expression(throwStatement.Value())
+		return func(i *interpreter) error {
+			v, err := value(i)
+			if err != nil {
+				return err
+			}
+			return throw(v, keyword)
+		}
+	case ast.TryStatementStatementType:
+		return c.tryStatement(statement.(ast.TryStatement))
+	case ast.BreakStatementStatementType:
+		jump := &loopJump{keyword: statement.(ast.BreakStatement).Keyword()}
+		return func(i *interpreter) error {
+			return jump
+		}
+	case ast.ContinueStatementStatementType:
+		jump := &loopJump{keyword: statement.(ast.ContinueStatement).Keyword()}
+		return func(i *interpreter) error {
+			return jump
+		}
+	}
+
+	return func(i *interpreter) error {
+		return &RuntimeError{err: fmt.Errorf("unknow statement type")}
+	}
+}
+
+func (c *closureCompiler) varStatement(statement ast.VarStatement) execFunc {
+	name := statement.Name().Lexeme()
+	if statement.Initializer() == nil {
+		return func(i *interpreter) error {
+			i.env.Define(name, Nil)
+			return nil
+		}
+	}
+
+	initializer := c.expression(statement.Initializer())
+	return func(i *interpreter) error {
+		value, err := initializer(i)
+		if err != nil {
+			return err
+		}
+		i.env.Define(name, value)
+		return nil
+	}
+}
+
+func (c *closureCompiler) ifStatement(statement ast.IfStatement) execFunc {
+	condition := c.expression(statement.Condition())
+	thenBranch := c.statement(statement.ThenStatement())
+	var elseBranch execFunc
+	if statement.ElseStatement() != nil {
+		elseBranch = c.statement(statement.ElseStatement())
+	}
+
+	return func(i *interpreter) error {
+		value, err := condition(i)
+		if err != nil {
+			return err
+		}
+		if value.IsTruthy() {
+			return thenBranch(i)
+		}
+		if elseBranch != nil {
+			return elseBranch(i)
+		}
+		return nil
+	}
+}
+
+func (c *closureCompiler) whileStatement(statement ast.WhileStatement) execFunc {
+	condition := c.expression(statement.Condition())
+	body := c.statement(statement.Body())
+	var increment evalFunc
+	if statement.Increment() != nil {
+		increment = c.expression(statement.Increment())
+	}
+
+	return func(i *interpreter) error {
+		for {
+			value, err := condition(i)
+			if err != nil {
+				return err
+			}
+			if !value.IsTruthy() {
+				return nil
+			}
+			if err = i.checkCanceled(nil); err != nil {
+				return err
+			}
+			if stop, err := endsLoop(body(i)); stop {
+				return err
+			}
+			if increment != nil {
+				if _, err = increment(i); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (c *closureCompiler) forInStatement(statement ast.ForInStatement) execFunc {
+	keyword := statement.Keyword()
+	name := statement.Name().Lexeme()
+	iterable := c.expression(statement.Iterable())
+	body := []execFunc{c.statement(statement.Body())}
+
+	return func(i *interpreter) error {
+		value, err := iterable(i)
+		if err != nil {
+			return err
+		}
+		it, err := i.iterate(value, keyword)
+		if err != nil {
+			return err
+		}
+		for {
+			if err = i.checkCanceled(nil); err != nil {
+				return err
+			}
+			value, ok, err := it.next(i)
+			if err != nil || !ok {
+				return err
+			}
+			env := NewEnvironment(i.env)
+			env.Define(name, value)
+			if stop, err := endsLoop(i.runBlock(body, env)); stop {
+				return err
+			}
+		}
+	}
+}
+
+func (c *closureCompiler) tryStatement(statement ast.TryStatement) execFunc {
+	name := statement.Name()
+	body := c.block(statement.Body())
+	catchBody := c.block(statement.CatchBody())
+	finallyBody := c.block(statement.FinallyBody())
+
+	return func(i *interpreter) error {
+		return i.runTry(
+			func(env Environment) error {
+				return i.runBlock(body, env)
+			},
+			name,
+			func(env Environment) error {
+				return i.runBlock(catchBody, env)
+			},
+			func(env Environment) error {
+				return i.runBlock(finallyBody, env)
+			},
+		)
+	}
+}
+
+func (c *closureCompiler) classStatement(statement ast.ClassStatement) execFunc {
+	var superclass evalFunc
+	if statement.Superclass() != nil {
+		superclass = c.expression(statement.Superclass())
+	}
+	bodies := make([][]execFunc, 0, len(statement.Methods()))
+	for _, method := range statement.Methods() {
+		bodies = append(bodies, c.block(method.Body()))
+	}
+
+	return func(i *interpreter) error {
+		var value Value
+		if superclass != nil {
+			var err error
+			if value, err = superclass(i); err != nil {
+				return err
+			}
+		}
+		return i.defineClass(statement, value, bodies)
+	}
+}
+
+func (c *closureCompiler) returnStatement(statement ast.ReturnStatement) execFunc {
+	keyword := statement.Keyword()
+	if statement.Value() == nil {
+		return func(i *interpreter) error {
+			return &returnValue{keyword: keyword}
+		}
+	}
+
+	value := c.expression(statement.Value())
+	return func(i *interpreter) error {
+		v, err := value(i)
+		if err != nil {
+			return err
+		}
+		return &returnValue{keyword: keyword, value: v}
+	}
+}
+
+func (c *closureCompiler) compileExpression(expression ast.Expression) evalFunc {
+	switch expression.Type() {
+	case ast.LiteralExpressionType:
+		value := literalValue(expression.(ast.Literal).Value())
+		return func(i *interpreter) (Value, error) {
+			return value, nil
+		}
+	case ast.GroupingExpressionType:
+		return c.expression(expression.(ast.Grouping).Expression())
+	case ast.VariableExpressionType:
+		return c.lookUpVariable(expression.(ast.Variable).Name(), expression)
+	case ast.ThisExpressionType:
+		return c.lookUpVariable(expression.(ast.This).Keyword(), expression)
+	case ast.AssignmentExpressionType:
+		return c.assignment(expression.(ast.Assignment))
+	case ast.LogicalExpressionType:
+		return c.logical(expression.(ast.Logical))
+	case ast.UnaryExpressionType:
+		return c.unary(expression.(ast.Unary))
+	case ast.BinaryExpressionType:
+		return c.binary(expression.(ast.Binary))
+	case ast.CallExpressionType:
+		return c.call(expression.(ast.Call))
+	case ast.GetExpressionType:
+		return c.get(expression.(ast.Get))
+	case ast.SetExpressionType:
+		return c.set(expression.(ast.Set))
+	case ast.SuperExpressionType:
+		super := expression.(ast.Super)
+		local := c.locals[expression]
+		return func(i *interpreter) (Value, error) {
+			return i.superMethod(local, super)
+		}
+	case ast.ListExpressionType:
+		return c.list(expression.(ast.List))
+	case ast.SubscriptExpressionType:
+		return c.subscript(expression.(ast.Subscript))
+	case ast.SubscriptSetExpressionType:
+		return c.subscriptSet(expression.(ast.SubscriptSet))
+	case ast.MapLiteralExpressionType:
+		return c.mapLiteral(expression.(ast.MapLiteral))
+	}
+
+	return func(i *interpreter) (Value, error) {
+		return Nil, &RuntimeError{err: fmt.Errorf("unknow expression type")}
+	}
+}
+
+func (c *closureCompiler) lookUpVariable(name tokens.Token, expression ast.Expression) evalFunc {
+	if local, found := c.locals[expression]; found {
+		depth, index := local.Depth, local.Index
+		return func(i *interpreter) (Value, error) {
+			return i.env.GetAt(depth, index), nil
+		}
+	}
+	return func(i *interpreter) (Value, error) {
+		return i.globals.Get(name)
+	}
+}
+
+func (c *closureCompiler) assignment(expression ast.Assignment) evalFunc {
+	name := expression.Name()
+	value := c.expression(expression.Value())
+
+	if local, found := c.locals[expression]; found {
+		depth, index := local.Depth, local.Index
+		return func(i *interpreter) (Value, error) {
+			v, err := value(i)
+			if err != nil {
+				return Nil, err
+			}
+			i.env.AssignAt(depth, index, v)
+			return v, nil
+		}
+	}
+	return func(i *interpreter) (Value, error) {
+		v, err := value(i)
+		if err != nil {
+			return Nil, err
+		}
+		if err = i.globals.Assign(name, v); err != nil {
+			return Nil, err
+		}
+		return v, nil
+	}
+}
+
+func (c *closureCompiler) logical(expression ast.Logical) evalFunc {
+	left := c.expression(expression.Left())
+	right := c.expression(expression.Right())
+	// "or" returns the left operand when it is truthy, "and" when it is falsey
+	shortCircuitOn := expression.Operator().Type() == tokens.Or
+
+	return func(i *interpreter) (Value, error) {
+		value, err := left(i)
+		if err != nil {
+			return Nil, err
+		}
+		if value.IsTruthy() == shortCircuitOn {
+			return value, nil
+		}
+		return right(i)
+	}
+}
+
+func (c *closureCompiler) unary(expression ast.Unary) evalFunc {
+	operator := expression.Operator()
+	right := c.expression(expression.Right())
+
+	switch operator.Type() {
+	case tokens.Bang:
+		return func(i *interpreter) (Value, error) {
+			value, err := right(i)
+			if err != nil {
+				return Nil, err
+			}
+			return NewBool(!value.IsTruthy()), nil
+		}
+	case tokens.Minus:
+		return func(i *interpreter) (Value, error) {
+			value, err := right(i)
+			if err != nil {
+				return Nil, err
+			}
+			if value.kind != NumberKind {
+				return Nil, &RuntimeError{err: fmt.Errorf("operand must be a number"), Token: operator}
+			}
+			return NewNumber(-value.number), nil
+		}
+	}
+
+	return func(i *interpreter) (Value, error) {
+		return Nil, nil
+	}
+}
+
+func (c *closureCompiler) binary(expression ast.Binary) evalFunc {
+	operator := expression.Operator()
+	left := c.expression(expression.Left())
+	right := c.expression(expression.Right())
+
+	operands := func(i *interpreter) (Value, Value, error) {
+		l, err := left(i)
+		if err != nil {
+			return Nil, Nil, err
+		}
+		r, err := right(i)
+		if err != nil {
+			return Nil, Nil, err
+		}
+		return l, r, nil
+	}
+	numbers := func(fn func(l float64, r float64) Value) evalFunc {
+		return func(i *interpreter) (Value, error) {
+			l, r, err := operands(i)
+			if err != nil {
+				return Nil, err
+			}
+			if l.kind != NumberKind || r.kind != NumberKind {
+				return Nil, &RuntimeError{err: fmt.Errorf("operand must be a number"), Token: operator}
+			}
+			return fn(l.number, r.number), nil
+		}
+	}
+
+	switch operator.Type() {
+	case tokens.Greater:
+		return numbers(func(l float64, r float64) Value { return NewBool(l > r) })
+	case tokens.GreaterEqual:
+		return numbers(func(l float64, r float64) Value { return NewBool(l >= r) })
+	case tokens.Less:
+		return numbers(func(l float64, r float64) Value { return NewBool(l < r) })
+	case tokens.LessEqual:
+		return numbers(func(l float64, r float64) Value { return NewBool(l <= r) })
+	case tokens.Minus:
+		return numbers(func(l float64, r float64) Value { return NewNumber(l - r) })
+	case tokens.Slash:
+		return numbers(func(l float64, r float64) Value { return NewNumber(l / r) })
+	case tokens.Star:
+		return numbers(func(l float64, r float64) Value { return NewNumber(l * r) })
+	case tokens.Plus:
+		return func(i *interpreter) (Value, error) {
+			l, r, err := operands(i)
+			if err != nil {
+				return Nil, err
+			}
+			if l.kind == NumberKind && r.kind == NumberKind {
+				return NewNumber(l.number + r.number), nil
+			}
+			if l.kind == StringKind && r.kind == StringKind {
+				return NewString(l.object.(string) + r.object.(string)), nil
+			}
+			return Nil, &RuntimeError{err: fmt.Errorf("operands must be both numbers or both strings"), Token: operator}
+		}
+	case tokens.EqualEqual, tokens.BangEqual:
+		negate := operator.Type() == tokens.BangEqual
+		return func(i *interpreter) (Value, error) {
+			l, r, err := operands(i)
+			if err != nil {
+				return Nil, err
+			}
+			return NewBool(l.Equal(r) != negate), nil
+		}
+	}
+
+	return func(i *interpreter) (Value, error) {
+		return Nil, nil
+	}
+}
+
+func (c *closureCompiler) call(expression ast.Call) evalFunc {
+	paren := expression.Paren()
+	callee := c.expression(expression.Callee())
+	arguments := make([]evalFunc, 0, len(expression.Arguments()))
+	for _, argument := range expression.Arguments() {
+		arguments = append(arguments, c.expression(argument))
+	}
+
+	return func(i *interpreter) (Value, error) {
+		function, err := callee(i)
+		if err != nil {
+			return Nil, err
+		}
+		values := make([]Value, 0, len(arguments))
+		for _, argument := range arguments {
+			value, err := argument(i)
+			if err != nil {
+				return Nil, err
+			}
+			values = append(values, value)
+		}
+		return i.call(function, values, paren)
+	}
+}
+
+func (c *closureCompiler) get(expression ast.Get) evalFunc {
+	name := expression.Name()
+	object := c.expression(expression.Object())
+
+	return func(i *interpreter) (Value, error) {
+		value, err := object(i)
+		if err != nil {
+			return Nil, err
+		}
+		if instance, ok := value.instance(); ok {
+			return instance.Get(name)
+		}
+		if e, ok := value.errorValue(); ok {
+			return e.Get(name)
+		}
+		return Nil, &RuntimeError{err: fmt.Errorf("only instances have properties"), Token: name}
+	}
+}
+
+func (c *closureCompiler) set(expression ast.Set) evalFunc {
+	name := expression.Name()
+	object := c.expression(expression.Object())
+	value := c.expression(expression.Value())
+
+	return func(i *interpreter) (Value, error) {
+		o, err := object(i)
+		if err != nil {
+			return Nil, err
+		}
+		instance, ok := o.instance()
+		if !ok {
+			return Nil, &RuntimeError{err: fmt.Errorf("only instances have fields"), Token: name}
+		}
+		v, err := value(i)
+		if err != nil {
+			return Nil, err
+		}
+		instance.Set(name, v)
+		return v, nil
+	}
+}
+
+func (c *closureCompiler) list(expression ast.List) evalFunc {
+	elements := make([]evalFunc, 0, len(expression.Elements()))
+	for _, element := range expression.Elements() {
+		elements = append(elements, c.expression(element))
+	}
+
+	return func(i *interpreter) (Value, error) {
+		values := make([]Value, 0, len(elements))
+		for _, element := range elements {
+			value, err := element(i)
+			if err != nil {
+				return Nil, err
+			}
+			values = append(values, value)
+		}
+		return newListValue(newLoxList(values)), nil
+	}
+}
+
+func (c *closureCompiler) mapLiteral(expression ast.MapLiteral) evalFunc {
+	brace := expression.Brace()
+	keys := make([]evalFunc, 0, len(expression.Keys()))
+	values := make([]evalFunc, 0, len(expression.Values()))
+	for n, key := range expression.Keys() {
+		keys = append(keys, c.expression(key))
+		values = append(values, c.expression(expression.Values()[n]))
+	}
+
+	return func(i *interpreter) (Value, error) {
+		m := newLoxMap()
+		for n, key := range keys {
+			k, err := key(i)
+			if err != nil {
+				return Nil, err
+			}
+			v, err := values[n](i)
+			if err != nil {
+				return Nil, err
+			}
+			if err = m.set(k, v); err != nil {
+				return Nil, &RuntimeError{err: err, Token: brace}
+			}
+		}
+		return newMapValue(m), nil
+	}
+}
+
+func (c *closureCompiler) subscript(expression ast.Subscript) evalFunc {
+	bracket := expression.Bracket()
+	object := c.expression(expression.Object())
+	index := c.expression(expression.Index())
+
+	return func(i *interpreter) (Value, error) {
+		o, err := object(i)
+		if err != nil {
+			return Nil, err
+		}
+		idx, err := index(i)
+		if err != nil {
+			return Nil, err
+		}
+		return subscript(o, idx, bracket)
+	}
+}
+
+func (c *closureCompiler) subscriptSet(expression ast.SubscriptSet) evalFunc {
+	bracket := expression.Bracket()
+	object := c.expression(expression.Object())
+	index := c.expression(expression.Index())
+	value := c.expression(expression.Value())
+
+	return func(i *interpreter) (Value, error) {
+		o, err := object(i)
+		if err != nil {
+			return Nil, err
+		}
+		idx, err := index(i)
+		if err != nil {
+			return Nil, err
+		}
+		v, err := value(i)
+		if err != nil {
+			return Nil, err
+		}
+		return v, setSubscript(o, idx, v, bracket)
+	}
+}
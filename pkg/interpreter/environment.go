@@ -7,56 +7,70 @@ import (
 
 type (
 	Environment interface {
-		Define(name string, value any)
-		Get(name tokens.Token) (any, error)
-		Assign(name tokens.Token, value any) error
-		GetValues() map[string]any
-		SetEnclosing(enclosing Environment)
+		Define(name string, value Value)
+		Get(name tokens.Token) (Value, error)
+		Assign(name tokens.Token, value Value) error
+		GetAt(distance int, index int) Value
+		AssignAt(distance int, index int, value Value)
 		GetEnclosing() Environment
 	}
 
+	// environment holds the variables of one scope.
+	// The global environment looks its variables up by name, every other environment is a block, function or class scope
+	// whose variables are only read through the slots computed by the resolver, so it keeps the values without their names.
 	environment struct {
 		enclosing Environment
-		values    map[string]any
+		// values holds the local variables in the order they are defined
+		values []Value
+		// globals is only set in the global environment
+		globals map[string]Value
 	}
 )
 
+// NewEnvironment creates a local environment inside enclosing,
+// or the global environment when enclosing is nil
 func NewEnvironment(enclosing Environment) Environment {
-	return &environment{
-		enclosing: enclosing,
-		values:    make(map[string]any),
+	if enclosing == nil {
+		return &environment{globals: make(map[string]Value)}
 	}
+	return &environment{enclosing: enclosing}
 }
 
-func (e *environment) Define(name string, value any) {
-	e.values[name] = value
+// Define adds a variable to the environment.
+// Local variables must be defined in the order the resolver numbered them in.
+func (e *environment) Define(name string, value Value) {
+	if e.globals != nil {
+		e.globals[name] = value
+		return
+	}
+	e.values = append(e.values, value)
 }
 
-func (e *environment) Get(name tokens.Token) (any, error) {
-	if value, found := e.values[name.Lexeme()]; found {
-		return value, nil
+// Get reads a global variable
+func (e *environment) Get(name tokens.Token) (Value, error) {
+	if e.globals == nil {
+		return e.enclosing.Get(name)
 	}
 
-	if e.enclosing != nil {
-		return e.enclosing.Get(name)
+	if value, found := e.globals[name.Lexeme()]; found {
+		return value, nil
 	}
 
-	return nil, &RuntimeError{
+	return Nil, &RuntimeError{
 		Token: name,
 		err:   fmt.Errorf("undefined variable '%s'", name.Lexeme()),
 	}
 }
 
-func (e *environment) Assign(name tokens.Token, value any) error {
-	if _, found := e.values[name.Lexeme()]; found {
-		e.values[name.Lexeme()] = value
-		return nil
+// Assign updates a global variable
+func (e *environment) Assign(name tokens.Token, value Value) error {
+	if e.globals == nil {
+		return e.enclosing.Assign(name, value)
 	}
-	if e.enclosing != nil {
-		err := e.enclosing.Assign(name, value)
-		if err == nil {
-			return nil
-		}
+
+	if _, found := e.globals[name.Lexeme()]; found {
+		e.globals[name.Lexeme()] = value
+		return nil
 	}
 	return &RuntimeError{
 		Token: name,
@@ -64,12 +78,22 @@ func (e *environment) Assign(name tokens.Token, value any) error {
 	}
 }
 
-func (e *environment) GetValues() map[string]any {
-	return e.values
+// GetAt reads the local variable at index in the environment distance environments up the chain, as computed by the resolver
+func (e *environment) GetAt(distance int, index int) Value {
+	return e.ancestor(distance).values[index]
 }
 
-func (e *environment) SetEnclosing(enclosing Environment) {
-	e.enclosing = enclosing
+// AssignAt updates the local variable at index in the environment distance environments up the chain, as computed by the resolver
+func (e *environment) AssignAt(distance int, index int, value Value) {
+	e.ancestor(distance).values[index] = value
+}
+
+func (e *environment) ancestor(distance int) *environment {
+	env := e
+	for i := 0; i < distance; i++ {
+		env = env.enclosing.(*environment)
+	}
+	return env
 }
 
 func (e *environment) GetEnclosing() Environment {
@@ -0,0 +1,104 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/tokens"
+)
+
+type (
+	// loxError is the value a catch clause receives for a runtime error of the interpreter,
+	// it keeps the message and the token the error was reported at
+	loxError struct {
+		message string
+		token   tokens.Token
+	}
+
+	// thrownValue is the error of a throw statement, it unwinds the interpreter until a catch clause receives the value
+	thrownValue struct {
+		value Value
+	}
+)
+
+func newLoxError(message string, token tokens.Token) *loxError {
+	return &loxError{message: message, token: token}
+}
+
+// Get reads the message, line and position of the error, line and position are nil when the error has no token
+func (e *loxError) Get(name tokens.Token) (Value, error) {
+	switch name.Lexeme() {
+	case "message":
+		return NewString(e.message), nil
+	case "line":
+		if e.token == nil {
+			return Nil, nil
+		}
+		return NewNumber(float64(e.token.Line())), nil
+	case "position":
+		if e.token == nil {
+			return Nil, nil
+		}
+		return NewNumber(float64(e.token.Position())), nil
+	}
+	return Nil, &RuntimeError{err: fmt.Errorf("undefined property '%s'", name.Lexeme()), Token: name}
+}
+
+func (e *loxError) String() string {
+	return e.message
+}
+
+// Error is the message of an uncaught exception, a thrown error keeps its original message
+func (t *thrownValue) Error() string {
+	return t.value.String()
+}
+
+// throw unwinds the interpreter with value, errors are reported at keyword.
+// An error caught earlier keeps the place it was first reported at when it is thrown again, its stack trace starts at keyword.
+func throw(value Value, keyword tokens.Token) error {
+	if e, ok := value.errorValue(); ok && e.token != nil {
+		return &RuntimeError{err: &thrownValue{value: value}, Token: e.token, at: keyword}
+	}
+	return &RuntimeError{err: &thrownValue{value: value}, Token: keyword}
+}
+
+// caught returns the value a catch clause receives for err, ok is false for the errors that can't be caught:
+// returns, break and continue aren't errors of the program, and the limits set by the host end it
+func caught(err error) (Value, bool) {
+	re, ok := err.(*RuntimeError)
+	if !ok || exceedsLimits(err) {
+		return Nil, false
+	}
+	if thrown, ok := re.err.(*thrownValue); ok {
+		return thrown.value, true
+	}
+	return newErrorValue(newLoxError(re.Error(), re.Token)), true
+}
+
+// exceedsLimits reports whether err stops the program because it ran out of steps, call depth or time.
+// A script must not escape the limits set by the host, neither catch clauses nor finally blocks run for these errors.
+func exceedsLimits(err error) bool {
+	return errors.Is(err, ErrStepLimitExceeded) || errors.Is(err, ErrCallDepthExceeded) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// runTry runs the blocks of a try statement, each one in a new environment.
+// The catch block runs when the body fails with an error it can catch, with name defined as the caught value.
+// The finally block always runs last, a finally block that fails or jumps replaces the outcome of the other blocks.
+func (i *interpreter) runTry(body func(env Environment) error, name tokens.Token, catch func(env Environment) error, finally func(env Environment) error) error {
+	err := body(NewEnvironment(i.env))
+	if name != nil {
+		if value, ok := caught(err); ok {
+			env := NewEnvironment(i.env)
+			env.Define(name.Lexeme(), value)
+			err = catch(env)
+		}
+	}
+	if exceedsLimits(err) {
+		return err
+	}
+	if finallyErr := finally(NewEnvironment(i.env)); finallyErr != nil {
+		return finallyErr
+	}
+	return err
+}
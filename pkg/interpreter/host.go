@@ -0,0 +1,44 @@
+package interpreter
+
+import "github.com/mtvarkovsky/golox/pkg/tokens"
+
+// NativeFunction is a Go function exposed to Lox code.
+// It receives the evaluated arguments as Lox values: nil, bool, float64, string or an opaque Lox object.
+type NativeFunction func(arguments []any) (any, error)
+
+// DefineNative registers a Go function as a global Lox function.
+// A negative arity accepts any number of arguments.
+// Errors returned by fn are reported as runtime errors at the call site.
+func (i *interpreter) DefineNative(name string, arity int, fn NativeFunction) {
+	i.globals.Define(name, newFunctionValue(newNativeFunction(name, arity, func(arguments []Value) (Value, error) {
+		hostArguments := make([]any, 0, len(arguments))
+		for _, argument := range arguments {
+			hostArguments = append(hostArguments, argument.Interface())
+		}
+		result, err := fn(hostArguments)
+		if err != nil {
+			return Nil, err
+		}
+		return NewValue(result)
+	})))
+}
+
+// GetGlobal returns the current value of a global variable, see Value.Interface
+func (i *interpreter) GetGlobal(name string) (any, error) {
+	value, err := i.globals.Get(tokens.NewToken(tokens.Identifier, name, nil, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+// SetGlobal defines a global variable or overwrites its current value.
+// Go numbers of any type are converted to Lox numbers.
+func (i *interpreter) SetGlobal(name string, value any) error {
+	v, err := NewValue(value)
+	if err != nil {
+		return err
+	}
+	i.globals.Define(name, v)
+	return nil
+}
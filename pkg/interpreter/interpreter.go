@@ -1,26 +1,154 @@
 package interpreter
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/mtvarkovsky/golox/pkg/ast"
+	"github.com/mtvarkovsky/golox/pkg/resolver"
 	"github.com/mtvarkovsky/golox/pkg/tokens"
-	"math"
+	"io"
 	"os"
 )
 
 type (
+	// Interpreter executes resolved programs. Every interpreter owns its globals,
+	// so separate instances are isolated from each other and may run in parallel goroutines.
+	// A single instance is not safe for concurrent use.
+	Interpreter interface {
+		Interpret(statements []ast.Statement, locals resolver.Locals) (any, error)
+		InterpretContext(ctx context.Context, statements []ast.Statement, locals resolver.Locals) (any, error)
+		DefineNative(name string, arity int, fn NativeFunction)
+		GetGlobal(name string) (any, error)
+		SetGlobal(name string, value any) error
+	}
+
+	Config struct {
+		// Stdout receives the output of print statements, os.Stdout is used when it is nil
+		Stdout io.Writer
+		// MaxSteps limits how many statements and expressions a single Interpret call may evaluate, 0 means no limit
+		MaxSteps int
+		// MaxCallDepth limits how many frames may be active at once, the top-level script is the first one as on the VM.
+		// 0 means DefaultMaxCallDepth.
+		// Every call is a few Go calls deep, so very large limits can exhaust the Go stack before they are reached.
+		MaxCallDepth int
+		// CompileClosures turns every program into Go closures before running it instead of walking its syntax tree,
+		// which saves the dispatch on every node at the cost of one pass over the program
+		CompileClosures bool
+	}
+
+	interpreter struct {
+		config  Config
+		globals Environment
+		env     Environment
+
+		// locals are the resolved slots of the program being walked, functions keep the slots of the program
+		// that declared them, so nothing is held here once a program is done
+		locals resolver.Locals
+
+		// steps and callDepth are reset by every Interpret call
+		steps     int
+		callDepth int
+		// done is the cancellation channel of the context passed to InterpretContext, nil if it can't be canceled
+		done <-chan struct{}
+		ctx  context.Context
+
+		statementVisitor ast.StatementVisitor
+	}
+
 	RuntimeError struct {
 		err   error
 		Token tokens.Token
+		// Trace lists the function calls the error returned from, innermost first, it ends with the top-level script
+		Trace []StackFrame
+		// at is where the innermost function not yet in Trace is executing, Token is used while it is nil
+		at tokens.Token
+	}
+
+	// StackFrame is a function call that was active when a runtime error stopped the program
+	StackFrame struct {
+		// Function is the name of the called function, "script" for the top-level code
+		Function string
+		// Line is the line the function was executing, -1 when it isn't known
+		Line int
 	}
 )
 
-var Env = NewEnvironment(nil)
+func NewInterpreter(config Config) Interpreter {
+	if config.Stdout == nil {
+		config.Stdout = os.Stdout
+	}
+	if config.MaxCallDepth <= 0 {
+		config.MaxCallDepth = DefaultMaxCallDepth
+	}
 
-// TODO: refactor this
-func Interpret(statements []ast.Statement) (any, error) {
-	for _, statement := range statements {
-		err := execute(statement)
+	globals := NewEnvironment(nil)
+	globals.Define("clock", newFunctionValue(newNativeFunction("clock", 0, clock)))
+	globals.Define("range", newFunctionValue(newNativeFunction("range", -1, nativeRange)))
+	for _, native := range append(listNatives(), mapNatives()...) {
+		globals.Define(native.name, newFunctionValue(native))
+	}
+
+	i := &interpreter{
+		config:  config,
+		globals: globals,
+		env:     globals,
+	}
+	i.statementVisitor = i.visitStatement
+	return i
+}
+
+const (
+	// scriptFrame is the name of the top-level code in stack traces
+	scriptFrame = "script"
+	// DefaultMaxCallDepth is the call depth limit when Config.MaxCallDepth is 0, the same as vm.FramesMax
+	DefaultMaxCallDepth = 1024
+)
+
+// ErrStepLimitExceeded and ErrCallDepthExceeded are wrapped by the runtime errors of every backend that runs out of a limit
+var (
+	ErrStepLimitExceeded = errors.New("step limit exceeded")
+	ErrCallDepthExceeded = errors.New("maximum call depth exceeded")
+)
+
+func (i *interpreter) Interpret(statements []ast.Statement, locals resolver.Locals) (any, error) {
+	return i.InterpretContext(context.Background(), statements, locals)
+}
+
+// InterpretContext runs statements until they finish, fail or ctx is done.
+// Cancellation is checked on every loop iteration and function call
+// and is reported as a RuntimeError wrapping ctx.Err().
+func (i *interpreter) InterpretContext(ctx context.Context, statements []ast.Statement, locals resolver.Locals) (any, error) {
+	i.steps = 0
+	i.callDepth = 0
+	i.ctx = ctx
+	i.done = ctx.Done()
+	i.locals = locals
+	defer func() {
+		i.ctx = nil
+		i.done = nil
+		i.locals = nil
+	}()
+
+	var program []execFunc
+	if i.config.CompileClosures {
+		compiler := &closureCompiler{locals: locals, countSteps: i.config.MaxSteps > 0}
+		program = compiler.block(statements)
+	}
+
+	for s, statement := range statements {
+		var err error
+		if program != nil {
+			err = program[s](i)
+		} else {
+			err = i.execute(statement)
+		}
+		if ret, ok := err.(*returnValue); ok {
+			err = &RuntimeError{err: ret, Token: ret.keyword}
+		}
+		if re, ok := err.(*RuntimeError); ok {
+			re.unwind(scriptFrame, nil)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -28,43 +156,109 @@ func Interpret(statements []ast.Statement) (any, error) {
 	return nil, nil
 }
 
-func execute(statement ast.Statement) error {
-	_, err := statement.Accept(StatementVisitor)
+func (i *interpreter) execute(statement ast.Statement) error {
+	if err := i.step(); err != nil {
+		return err
+	}
+	_, err := statement.Accept(i.statementVisitor)
 	return err
 }
 
-func StatementVisitor(statement ast.Statement) (any, error) {
+// step accounts for one evaluated statement or expression against Config.MaxSteps
+func (i *interpreter) step() error {
+	i.steps++
+	if i.config.MaxSteps > 0 && i.steps > i.config.MaxSteps {
+		return &RuntimeError{err: ErrStepLimitExceeded}
+	}
+	return nil
+}
+
+// checkCanceled returns a runtime error once the context of the current Interpret call is done
+func (i *interpreter) checkCanceled(token tokens.Token) error {
+	if i.done == nil {
+		return nil
+	}
+	select {
+	case <-i.done:
+		return &RuntimeError{err: fmt.Errorf("execution interrupted: %w", i.ctx.Err()), Token: token}
+	default:
+		return nil
+	}
+}
+
+func (i *interpreter) visitStatement(statement ast.Statement) (any, error) {
 	switch statement.Type() {
 	case ast.VarStatementStatementType:
-		return visitVarStatement(statement.(ast.VarStatement))
+		return i.visitVarStatement(statement.(ast.VarStatement))
 	case ast.BlockStatementStatementType:
-		return visitBlockStatement(statement.(ast.BlockStatement))
+		return i.visitBlockStatement(statement.(ast.BlockStatement))
 	case ast.WhileStatementStatementType:
-		return visitWhileStatement(statement.(ast.WhileStatement))
+		return i.visitWhileStatement(statement.(ast.WhileStatement))
 	case ast.ExpressionStatementStatementType:
-		return visitExpressionStatement(statement.(ast.ExpressionStatement))
+		return i.visitExpressionStatement(statement.(ast.ExpressionStatement))
 	case ast.PrintStatementStatementType:
-		return visitPrintStatement(statement.(ast.PrintStatement))
+		return i.visitPrintStatement(statement.(ast.PrintStatement))
 	case ast.IfStatementStatementType:
-		return visitIfStatement(statement.(ast.IfStatement))
+		return i.visitIfStatement(statement.(ast.IfStatement))
+	case ast.FunctionStatementStatementType:
+		return i.visitFunctionStatement(statement.(ast.FunctionStatement))
+	case ast.ClassStatementStatementType:
+		return i.visitClassStatement(statement.(ast.ClassStatement))
+	case ast.ReturnStatementStatementType:
+		return i.visitReturnStatement(statement.(ast.ReturnStatement))
+	case ast.ForInStatementStatementType:
+		return i.visitForInStatement(statement.(ast.ForInStatement))
+	case ast.ThrowStatementStatementType:
+		return i.visitThrowStatement(statement.(ast.ThrowStatement))
+	case ast.TryStatementStatementType:
+		return i.visitTryStatement(statement.(ast.TryStatement))
+	case ast.BreakStatementStatementType:
+		return nil, &loopJump{keyword: statement.(ast.BreakStatement).Keyword()}
+	case ast.ContinueStatementStatementType:
+		return nil, &loopJump{keyword: statement.(ast.ContinueStatement).Keyword()}
 	}
 
 	return nil, &RuntimeError{err: fmt.Errorf("unknow statement type")}
 }
 
-func visitIfStatement(statement ast.IfStatement) (any, error) {
-	res, err := evaluate(statement.Condition())
+func (i *interpreter) visitIfStatement(statement ast.IfStatement) (any, error) {
+	res, err := i.evaluate(statement.Condition())
 	if err != nil {
 		return nil, err
 	}
-	b, err := toBoolean(res)
-	if b {
-		err = execute(statement.ThenStatement())
+	if res.IsTruthy() {
+		err = i.execute(statement.ThenStatement())
 		if err != nil {
 			return nil, err
 		}
 	} else if statement.ElseStatement() != nil {
-		err = execute(statement.ElseStatement())
+		err = i.execute(statement.ElseStatement())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (i *interpreter) visitWhileStatement(statement ast.WhileStatement) (any, error) {
+	cond, err := i.evaluate(statement.Condition())
+	if err != nil {
+		return nil, err
+	}
+	for cond.IsTruthy() {
+		if err = i.checkCanceled(nil); err != nil {
+			return nil, err
+		}
+		if stop, err := endsLoop(i.execute(statement.Body())); stop {
+			return nil, err
+		}
+		// the increment of a desugared for loop runs after every iteration, including the ones ended by continue
+		if statement.Increment() != nil {
+			if _, err = i.evaluate(statement.Increment()); err != nil {
+				return nil, err
+			}
+		}
+		cond, err = i.evaluate(statement.Condition())
 		if err != nil {
 			return nil, err
 		}
@@ -72,311 +266,589 @@ func visitIfStatement(statement ast.IfStatement) (any, error) {
 	return nil, nil
 }
 
-func visitWhileStatement(statement ast.WhileStatement) (any, error) {
-	cond, err := evaluate(statement.Condition())
+// visitForInStatement runs the body once for every value of the iterable,
+// each iteration defines the loop variable in a new environment so closures capture the value of their iteration
+func (i *interpreter) visitForInStatement(statement ast.ForInStatement) (any, error) {
+	iterable, err := i.evaluate(statement.Iterable())
 	if err != nil {
 		return nil, err
 	}
-	b, err := toBoolean(cond)
+	it, err := i.iterate(iterable, statement.Keyword())
 	if err != nil {
 		return nil, err
 	}
-	for b {
-		err = execute(statement.Body())
-		if err != nil {
+	body := []ast.Statement{statement.Body()}
+	for {
+		if err = i.checkCanceled(nil); err != nil {
 			return nil, err
 		}
-		cond, err = evaluate(statement.Condition())
-		if err != nil {
+		value, ok, err := it.next(i)
+		if err != nil || !ok {
 			return nil, err
 		}
-		b, err = toBoolean(cond)
-		if err != nil {
+		env := NewEnvironment(i.env)
+		env.Define(statement.Name().Lexeme(), value)
+		if stop, err := endsLoop(i.executeBlock(body, env)); stop {
 			return nil, err
 		}
 	}
-	return nil, nil
 }
 
-func visitPrintStatement(statement ast.PrintStatement) (any, error) {
-	val, err := evaluate(statement.Expression())
+func (i *interpreter) visitThrowStatement(statement ast.ThrowStatement) (any, error) {
+	value, err := i.evaluate(statement.Value())
 	if err != nil {
 		return nil, err
 	}
-	_, err = fmt.Fprintln(os.Stdout, StringifyResult(val))
+	return nil, throw(value, statement.Keyword())
+}
+
+func (i *interpreter) visitTryStatement(statement ast.TryStatement) (any, error) {
+	return nil, i.runTry(
+		func(env Environment) error {
+			return i.executeBlock(statement.Body(), env)
+		},
+		statement.Name(),
+		func(env Environment) error {
+			return i.executeBlock(statement.CatchBody(), env)
+		},
+		func(env Environment) error {
+			return i.executeBlock(statement.FinallyBody(), env)
+		},
+	)
+}
+
+func (i *interpreter) visitPrintStatement(statement ast.PrintStatement) (any, error) {
+	val, err := i.evaluate(statement.Expression())
+	if err != nil {
+		return nil, err
+	}
+	_, err = fmt.Fprintln(i.config.Stdout, val.String())
 	return nil, err
 }
 
-func visitExpressionStatement(statement ast.ExpressionStatement) (any, error) {
-	_, err := evaluate(statement.Expression())
+func (i *interpreter) visitExpressionStatement(statement ast.ExpressionStatement) (any, error) {
+	_, err := i.evaluate(statement.Expression())
 	if err != nil {
 		return nil, err
 	}
 	return nil, nil
 }
 
-func visitVarStatement(statement ast.VarStatement) (any, error) {
-	var value any
+func (i *interpreter) visitVarStatement(statement ast.VarStatement) (any, error) {
+	var value Value
 	var err error
 	if statement.Initializer() != nil {
-		value, err = evaluate(statement.Initializer())
+		value, err = i.evaluate(statement.Initializer())
 		if err != nil {
 			return nil, err
 		}
 	}
-	Env.Define(statement.Name().Lexeme(), value)
+	i.env.Define(statement.Name().Lexeme(), value)
 	return nil, nil
 }
 
-func visitBlockStatement(statement ast.BlockStatement) (any, error) {
-	err := executeBlock(statement.Statements(), NewEnvironment(Env))
-	return nil, err
+func (i *interpreter) visitFunctionStatement(statement ast.FunctionStatement) (any, error) {
+	i.env.Define(statement.Name().Lexeme(), newFunctionValue(newLoxFunction(statement, i.env, false, i.locals)))
+	return nil, nil
 }
 
-func executeBlock(statements []ast.Statement, env Environment) error {
-	outerEnv := Env
-	for k, v := range Env.GetValues() {
-		outerEnv.Define(k, v)
+func (i *interpreter) visitClassStatement(statement ast.ClassStatement) (any, error) {
+	var superclass Value
+	if statement.Superclass() != nil {
+		value, err := i.evaluate(statement.Superclass())
+		if err != nil {
+			return nil, err
+		}
+		superclass = value
 	}
-	outerEnv.SetEnclosing(Env.GetEnclosing())
 
-	var err error
-	Env = env
-	for _, statement := range statements {
-		err = execute(statement)
-		if err != nil {
-			return err
+	return nil, i.defineClass(statement, superclass, nil)
+}
+
+// defineClass declares the class in the current environment.
+// bodies holds the compiled bodies of the methods when the program was compiled to closures, it is nil otherwise.
+func (i *interpreter) defineClass(statement ast.ClassStatement, superclassValue Value, bodies [][]execFunc) error {
+	var superclass *loxClass
+	if statement.Superclass() != nil {
+		if superclassValue.Kind() != ClassKind {
+			return &RuntimeError{err: fmt.Errorf("superclass must be a class"), Token: statement.Superclass().Name()}
+		}
+		superclass = superclassValue.object.(*loxClass)
+	}
+
+	methodsEnv := i.env
+	if superclass != nil {
+		methodsEnv = NewEnvironment(i.env)
+		methodsEnv.Define("super", newClassValue(superclass))
+	}
+
+	methods := make(map[string]*loxFunction, len(statement.Methods()))
+	for m, method := range statement.Methods() {
+		function := newLoxFunction(method, methodsEnv, method.Name().Lexeme() == "init", i.locals)
+		if bodies != nil {
+			function.body = bodies[m]
 		}
+		methods[method.Name().Lexeme()] = function
 	}
-	Env = outerEnv
+
+	// the methods only look the class up when they run, so it can be defined once it is complete
+	i.env.Define(statement.Name().Lexeme(), newClassValue(newLoxClass(statement.Name().Lexeme(), superclass, methods)))
 	return nil
 }
 
-func StringifyResult(res any) string {
-	if res == nil {
-		return "nil"
-	}
-	if _, ok := res.(float64); ok {
-		if res.(float64) == math.Trunc(res.(float64)) {
-			return fmt.Sprintf("%.0f", res)
+func (i *interpreter) visitReturnStatement(statement ast.ReturnStatement) (any, error) {
+	var value Value
+	var err error
+	if statement.Value() != nil {
+		value, err = i.evaluate(statement.Value())
+		if err != nil {
+			return nil, err
 		}
+	}
+	return nil, &returnValue{keyword: statement.Keyword(), value: value}
+}
+
+func (i *interpreter) visitBlockStatement(statement ast.BlockStatement) (any, error) {
+	err := i.executeBlock(statement.Statements(), NewEnvironment(i.env))
+	return nil, err
+}
 
-		return fmt.Sprintf("%f", res)
+// executeBlock runs statements in env and restores the current environment afterwards.
+// Environments are never copied, so closures created inside the block keep referring to env.
+func (i *interpreter) executeBlock(statements []ast.Statement, env Environment) error {
+	outerEnv := i.env
+	i.env = env
+	defer func() {
+		i.env = outerEnv
+	}()
+	for _, statement := range statements {
+		err := i.execute(statement)
+		if err != nil {
+			return err
+		}
 	}
-	return fmt.Sprint(res)
+	return nil
 }
 
-func ExpressionVisitor(expression ast.Expression) (any, error) {
+func (i *interpreter) visitExpression(expression ast.Expression) (Value, error) {
 	switch expression.Type() {
 	case ast.AssignmentExpressionType:
-		return visitAssignmentExpression(expression.(ast.Assignment))
+		return i.visitAssignmentExpression(expression.(ast.Assignment))
 	case ast.BinaryExpressionType:
-		return visitBinaryExpression(expression.(ast.Binary))
+		return i.visitBinaryExpression(expression.(ast.Binary))
 	case ast.LogicalExpressionType:
-		return visitLogical(expression.(ast.Logical))
+		return i.visitLogical(expression.(ast.Logical))
 	case ast.UnaryExpressionType:
-		return visitUnaryExpression(expression.(ast.Unary))
+		return i.visitUnaryExpression(expression.(ast.Unary))
 	case ast.LiteralExpressionType:
-		return visitLiteral(expression.(ast.Literal))
+		return i.visitLiteral(expression.(ast.Literal))
 	case ast.GroupingExpressionType:
-		return visitGrouping(expression.(ast.Grouping))
+		return i.visitGrouping(expression.(ast.Grouping))
 	case ast.VariableExpressionType:
-		return visitVariable(expression.(ast.Variable))
-	}
-
-	return nil, &RuntimeError{err: fmt.Errorf("unknow expression type")}
+		return i.visitVariable(expression.(ast.Variable))
+	case ast.CallExpressionType:
+		return i.visitCallExpression(expression.(ast.Call))
+	case ast.GetExpressionType:
+		return i.visitGetExpression(expression.(ast.Get))
+	case ast.SetExpressionType:
+		return i.visitSetExpression(expression.(ast.Set))
+	case ast.ThisExpressionType:
+		return i.visitThisExpression(expression.(ast.This))
+	case ast.SuperExpressionType:
+		return i.visitSuperExpression(expression.(ast.Super))
+	case ast.ListExpressionType:
+		return i.visitListExpression(expression.(ast.List))
+	case ast.SubscriptExpressionType:
+		return i.visitSubscriptExpression(expression.(ast.Subscript))
+	case ast.SubscriptSetExpressionType:
+		return i.visitSubscriptSetExpression(expression.(ast.SubscriptSet))
+	case ast.MapLiteralExpressionType:
+		return i.visitMapLiteralExpression(expression.(ast.MapLiteral))
+	}
+
+	return Nil, &RuntimeError{err: fmt.Errorf("unknow expression type")}
 }
 
-func visitLogical(expression ast.Logical) (any, error) {
-	left, err := evaluate(expression.Left())
+func (i *interpreter) visitLogical(expression ast.Logical) (Value, error) {
+	left, err := i.evaluate(expression.Left())
 	if err != nil {
-		return nil, err
-	}
-	b, err := toBoolean(left)
-	if err != nil {
-		return nil, err
+		return Nil, err
 	}
 	if expression.Operator().Type() == tokens.Or {
-		if b {
+		if left.IsTruthy() {
 			return left, nil
 		}
 	} else {
-		if !b {
+		if !left.IsTruthy() {
 			return left, nil
 		}
 	}
 
-	return evaluate(expression.Right())
+	return i.evaluate(expression.Right())
 }
 
-func visitVariable(expression ast.Variable) (any, error) {
-	return Env.Get(expression.Name())
+func (i *interpreter) visitVariable(expression ast.Variable) (Value, error) {
+	return i.lookUpVariable(expression.Name(), expression)
 }
 
-func visitAssignmentExpression(expression ast.Assignment) (any, error) {
-	value, err := evaluate(expression.Value())
+func (i *interpreter) lookUpVariable(name tokens.Token, expression ast.Expression) (Value, error) {
+	if local, found := i.locals[expression]; found {
+		return i.env.GetAt(local.Depth, local.Index), nil
+	}
+	return i.globals.Get(name)
+}
+
+func (i *interpreter) visitAssignmentExpression(expression ast.Assignment) (Value, error) {
+	value, err := i.evaluate(expression.Value())
 	if err != nil {
-		return nil, err
+		return Nil, err
 	}
-	err = Env.Assign(expression.Name(), value)
+	if local, found := i.locals[expression]; found {
+		i.env.AssignAt(local.Depth, local.Index, value)
+		return value, nil
+	}
+	if err = i.globals.Assign(expression.Name(), value); err != nil {
+		return Nil, err
+	}
+	return value, nil
+}
+
+func (i *interpreter) visitCallExpression(expression ast.Call) (Value, error) {
+	callee, err := i.evaluate(expression.Callee())
 	if err != nil {
-		return nil, err
+		return Nil, err
+	}
+
+	arguments := make([]Value, 0, len(expression.Arguments()))
+	for _, argument := range expression.Arguments() {
+		value, e := i.evaluate(argument)
+		if e != nil {
+			return Nil, e
+		}
+		arguments = append(arguments, value)
+	}
+
+	return i.call(callee, arguments, expression.Paren())
+}
+
+// call checks the callee, its arity and the call limits, then calls it.
+// paren is the closing parenthesis of the call, errors are reported at it.
+func (i *interpreter) call(callee Value, arguments []Value, paren tokens.Token) (Value, error) {
+	function, ok := callee.callable()
+	if !ok {
+		return Nil, &RuntimeError{err: fmt.Errorf("can only call functions and classes"), Token: paren}
+	}
+	if function.Arity() >= 0 && len(arguments) != function.Arity() {
+		return Nil, &RuntimeError{
+			err:   fmt.Errorf("expected %d arguments but got %d", function.Arity(), len(arguments)),
+			Token: paren,
+		}
+	}
+
+	if err := i.checkCanceled(paren); err != nil {
+		return Nil, err
+	}
+	// the script is a frame too, so a limit of n allows n-1 calls like the VM
+	if i.callDepth+1 >= i.config.MaxCallDepth {
+		return Nil, &RuntimeError{err: ErrCallDepthExceeded, Token: paren}
+	}
+
+	i.callDepth++
+	value, err := function.Call(i, arguments)
+	i.callDepth--
+	if err != nil {
+		re, ok := err.(*RuntimeError)
+		if !ok {
+			if _, isNative := function.(*nativeFunction); isNative {
+				return Nil, &RuntimeError{err: err, Token: paren}
+			}
+			return Nil, err
+		}
+		if name, ok := frameName(function); ok {
+			re.unwind(name, paren)
+		}
+		return Nil, err
 	}
 	return value, nil
 }
 
-func visitLiteral(expression ast.Literal) (any, error) {
-	return expression.Value(), nil
+// frameName returns the name function has in stack traces.
+// Calling a class runs its initializer, natives have no frame, their errors are reported at the call.
+func frameName(function LoxCallable) (string, bool) {
+	switch f := function.(type) {
+	case *loxFunction:
+		return f.declaration.Name().Lexeme(), true
+	case *loxClass:
+		return "init", true
+	}
+	return "", false
 }
 
-func visitGrouping(expression ast.Grouping) (any, error) {
-	return evaluate(expression.Expression())
+func (i *interpreter) visitGetExpression(expression ast.Get) (Value, error) {
+	object, err := i.evaluate(expression.Object())
+	if err != nil {
+		return Nil, err
+	}
+	if instance, ok := object.instance(); ok {
+		return instance.Get(expression.Name())
+	}
+	if e, ok := object.errorValue(); ok {
+		return e.Get(expression.Name())
+	}
+	return Nil, &RuntimeError{err: fmt.Errorf("only instances have properties"), Token: expression.Name()}
 }
 
-func evaluate(expression ast.Expression) (any, error) {
-	v, err := expression.Accept(ExpressionVisitor)
+func (i *interpreter) visitSetExpression(expression ast.Set) (Value, error) {
+	object, err := i.evaluate(expression.Object())
 	if err != nil {
-		return nil, &RuntimeError{err: err}
+		return Nil, err
 	}
-	return v, nil
+	instance, ok := object.instance()
+	if !ok {
+		return Nil, &RuntimeError{err: fmt.Errorf("only instances have fields"), Token: expression.Name()}
+	}
+	value, err := i.evaluate(expression.Value())
+	if err != nil {
+		return Nil, err
+	}
+	instance.Set(expression.Name(), value)
+	return value, nil
 }
 
-func visitUnaryExpression(expression ast.Unary) (v any, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			v = nil
-			err = &RuntimeError{err: fmt.Errorf("can't interpret unary expression: %v", r)}
+func (i *interpreter) visitThisExpression(expression ast.This) (Value, error) {
+	return i.lookUpVariable(expression.Keyword(), expression)
+}
+
+func (i *interpreter) visitSuperExpression(expression ast.Super) (Value, error) {
+	return i.superMethod(i.locals[expression], expression)
+}
+
+// superMethod finds the method on the superclass of the class the method was declared in
+// and binds it to the current instance, which lives one environment below "super"
+func (i *interpreter) superMethod(super resolver.Local, expression ast.Super) (Value, error) {
+	superclass := i.env.GetAt(super.Depth, super.Index).object.(*loxClass)
+	object, _ := i.env.GetAt(super.Depth-1, 0).instance()
+
+	method := superclass.findMethod(expression.Method().Lexeme())
+	if method == nil {
+		return Nil, &RuntimeError{
+			err:   fmt.Errorf("undefined property '%s'", expression.Method().Lexeme()),
+			Token: expression.Method(),
 		}
-	}()
+	}
+	return newFunctionValue(method.bind(object)), nil
+}
 
-	right, err := evaluate(expression.Right())
+func (i *interpreter) visitListExpression(expression ast.List) (Value, error) {
+	elements := make([]Value, 0, len(expression.Elements()))
+	for _, element := range expression.Elements() {
+		value, err := i.evaluate(element)
+		if err != nil {
+			return Nil, err
+		}
+		elements = append(elements, value)
+	}
+	return newListValue(newLoxList(elements)), nil
+}
+
+func (i *interpreter) visitSubscriptExpression(expression ast.Subscript) (Value, error) {
+	object, err := i.evaluate(expression.Object())
 	if err != nil {
-		return nil, err
+		return Nil, err
 	}
+	index, err := i.evaluate(expression.Index())
+	if err != nil {
+		return Nil, err
+	}
+	return subscript(object, index, expression.Bracket())
+}
 
-	switch expression.Operator().Type() {
-	case tokens.Bang:
-		v, err = toBoolean(right)
-		return v, err
-	case tokens.Minus:
-		e := checkNumberOperands(expression.Operator(), right)
-		if e != nil {
-			return nil, e
-		}
-		return -right.(float64), nil
+func (i *interpreter) visitSubscriptSetExpression(expression ast.SubscriptSet) (Value, error) {
+	object, err := i.evaluate(expression.Object())
+	if err != nil {
+		return Nil, err
+	}
+	index, err := i.evaluate(expression.Index())
+	if err != nil {
+		return Nil, err
+	}
+	value, err := i.evaluate(expression.Value())
+	if err != nil {
+		return Nil, err
 	}
+	return value, setSubscript(object, index, value, expression.Bracket())
+}
 
-	return nil, nil
+func (i *interpreter) visitMapLiteralExpression(expression ast.MapLiteral) (Value, error) {
+	m := newLoxMap()
+	for n, keyExpression := range expression.Keys() {
+		key, err := i.evaluate(keyExpression)
+		if err != nil {
+			return Nil, err
+		}
+		value, err := i.evaluate(expression.Values()[n])
+		if err != nil {
+			return Nil, err
+		}
+		if err = m.set(key, value); err != nil {
+			return Nil, &RuntimeError{err: err, Token: expression.Brace()}
+		}
+	}
+	return newMapValue(m), nil
 }
 
-func toBoolean(o any) (v bool, err error) {
-	if o == nil {
-		return false, nil
+// subscript reads object[index] from a list or a map, errors are reported at the closing bracket
+func subscript(object Value, index Value, bracket tokens.Token) (Value, error) {
+	var value Value
+	var err error
+	switch object.kind {
+	case ListKind:
+		value, err = object.object.(*loxList).get(index)
+	case MapKind:
+		value, err = object.object.(*loxMap).get(index)
+	default:
+		err = fmt.Errorf("only lists and maps can be indexed")
+	}
+	if err != nil {
+		return Nil, &RuntimeError{err: err, Token: bracket}
 	}
+	return value, nil
+}
 
-	switch o.(type) {
-	case bool:
-		return o.(bool), nil
+// setSubscript assigns object[index] in a list or a map, errors are reported at the closing bracket
+func setSubscript(object Value, index Value, value Value, bracket tokens.Token) error {
+	var err error
+	switch object.kind {
+	case ListKind:
+		err = object.object.(*loxList).set(index, value)
+	case MapKind:
+		err = object.object.(*loxMap).set(index, value)
+	default:
+		err = fmt.Errorf("only lists and maps can be indexed")
 	}
+	if err != nil {
+		return &RuntimeError{err: err, Token: bracket}
+	}
+	return nil
+}
 
-	return true, nil
+func (i *interpreter) visitLiteral(expression ast.Literal) (Value, error) {
+	return literalValue(expression.Value()), nil
 }
 
-func visitBinaryExpression(expression ast.Binary) (v any, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			v = nil
-			err = &RuntimeError{err: fmt.Errorf("can't interpret binary expression: %v", r)}
+func (i *interpreter) visitGrouping(expression ast.Grouping) (Value, error) {
+	return i.evaluate(expression.Expression())
+}
+
+// evaluate dispatches on the expression type itself instead of going through Accept,
+// which would box every Value into an any
+func (i *interpreter) evaluate(expression ast.Expression) (Value, error) {
+	if err := i.step(); err != nil {
+		return Nil, err
+	}
+	v, err := i.visitExpression(expression)
+	if err != nil {
+		if _, ok := err.(*RuntimeError); ok {
+			return Nil, err
 		}
-	}()
+		return Nil, &RuntimeError{err: err}
+	}
+	return v, nil
+}
 
-	left, err := evaluate(expression.Left())
+func (i *interpreter) visitUnaryExpression(expression ast.Unary) (Value, error) {
+	right, err := i.evaluate(expression.Right())
 	if err != nil {
-		return nil, err
+		return Nil, err
 	}
-	right, err := evaluate(expression.Right())
+
+	switch expression.Operator().Type() {
+	case tokens.Bang:
+		return NewBool(!right.IsTruthy()), nil
+	case tokens.Minus:
+		e := checkNumberOperands(expression.Operator(), right)
+		if e != nil {
+			return Nil, e
+		}
+		return NewNumber(-right.number), nil
+	}
+
+	return Nil, nil
+}
+
+func (i *interpreter) visitBinaryExpression(expression ast.Binary) (Value, error) {
+	left, err := i.evaluate(expression.Left())
 	if err != nil {
-		return nil, err
+		return Nil, err
+	}
+	right, err := i.evaluate(expression.Right())
+	if err != nil {
+		return Nil, err
 	}
 
 	switch expression.Operator().Type() {
 	case tokens.Greater:
 		e := checkNumberOperands(expression.Operator(), left, right)
 		if e != nil {
-			return nil, e
+			return Nil, e
 		}
-		return left.(float64) > right.(float64), nil
+		return NewBool(left.number > right.number), nil
 	case tokens.GreaterEqual:
 		e := checkNumberOperands(expression.Operator(), left, right)
 		if e != nil {
-			return nil, e
+			return Nil, e
 		}
-		return left.(float64) >= right.(float64), nil
+		return NewBool(left.number >= right.number), nil
 	case tokens.Less:
 		e := checkNumberOperands(expression.Operator(), left, right)
 		if e != nil {
-			return nil, e
+			return Nil, e
 		}
-		return left.(float64) < right.(float64), nil
+		return NewBool(left.number < right.number), nil
 	case tokens.LessEqual:
 		e := checkNumberOperands(expression.Operator(), left, right)
 		if e != nil {
-			return nil, e
+			return Nil, e
 		}
-		return left.(float64) <= right.(float64), nil
+		return NewBool(left.number <= right.number), nil
 	case tokens.Minus:
 		e := checkNumberOperands(expression.Operator(), left, right)
 		if e != nil {
-			return nil, e
+			return Nil, e
 		}
-		return left.(float64) - right.(float64), nil
+		return NewNumber(left.number - right.number), nil
 	case tokens.Slash:
 		e := checkNumberOperands(expression.Operator(), left, right)
 		if e != nil {
-			return nil, e
+			return Nil, e
 		}
-		return left.(float64) / right.(float64), nil
+		return NewNumber(left.number / right.number), nil
 	case tokens.Star:
 		e := checkNumberOperands(expression.Operator(), left, right)
 		if e != nil {
-			return nil, e
+			return Nil, e
 		}
-		return left.(float64) * right.(float64), nil
+		return NewNumber(left.number * right.number), nil
 	case tokens.Plus:
-		if _, lIsNumber := left.(float64); lIsNumber {
-			if _, rIsNumber := right.(float64); rIsNumber {
-				return left.(float64) + right.(float64), nil
-			}
+		if left.kind == NumberKind && right.kind == NumberKind {
+			return NewNumber(left.number + right.number), nil
 		}
-		if _, lIsString := left.(string); lIsString {
-			if _, rIsString := right.(string); rIsString {
-				return left.(string) + right.(string), nil
-			}
+		if left.kind == StringKind && right.kind == StringKind {
+			return NewString(left.object.(string) + right.object.(string)), nil
 		}
-		return nil, &RuntimeError{err: fmt.Errorf("operands must be both numbers or both strings"), Token: expression.Operator()}
+		return Nil, &RuntimeError{err: fmt.Errorf("operands must be both numbers or both strings"), Token: expression.Operator()}
 	case tokens.EqualEqual:
-		return isEqual(left, right)
+		return NewBool(left.Equal(right)), nil
 	case tokens.BangEqual:
-		val, e := isEqual(left, right)
-		return !val, e
+		return NewBool(!left.Equal(right)), nil
 	}
 
-	return nil, nil
-}
-
-func isEqual(left any, right any) (bool, error) {
-	if left == nil && right == nil {
-		return true, nil
-	}
-	if left == nil {
-		return false, nil
-	}
-	return fmt.Sprint(left) == fmt.Sprint(right), nil
+	return Nil, nil
 }
 
-func checkNumberOperands(operator tokens.Token, operands ...any) error {
+func checkNumberOperands(operator tokens.Token, operands ...Value) error {
 	for _, operand := range operands {
-		if _, ok := operand.(float64); !ok {
+		if operand.kind != NumberKind {
 			return &RuntimeError{err: fmt.Errorf("operand must be a number"), Token: operator}
 		}
 	}
@@ -387,3 +859,22 @@ func checkNumberOperands(operator tokens.Token, operands ...any) error {
 func (re *RuntimeError) Error() string {
 	return re.err.Error()
 }
+
+// unwind adds the call of function to the stack trace as the error returns from it,
+// call is the token the calling function is executing, nil when the error leaves the top-level code
+func (re *RuntimeError) unwind(function string, call tokens.Token) {
+	at := re.at
+	if at == nil {
+		at = re.Token
+	}
+	line := -1
+	if at != nil {
+		line = at.Line()
+	}
+	re.Trace = append(re.Trace, StackFrame{Function: function, Line: line})
+	re.at = call
+}
+
+func (re *RuntimeError) Unwrap() error {
+	return re.err
+}
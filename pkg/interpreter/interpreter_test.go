@@ -0,0 +1,610 @@
+package interpreter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/ast"
+	"github.com/mtvarkovsky/golox/pkg/parser"
+	"github.com/mtvarkovsky/golox/pkg/resolver"
+	"github.com/mtvarkovsky/golox/pkg/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func parse(t testing.TB, code string) ([]ast.Statement, resolver.Locals) {
+	tkns, scanErrs := scanner.NewScanner(code).ScanTokens()
+	require.Empty(t, scanErrs)
+	statements, parseErrs := parser.NewParser(tkns).Parse()
+	require.Empty(t, parseErrs)
+	locals, resolveErrs := resolver.NewResolver().Resolve(statements)
+	require.Empty(t, resolveErrs)
+	return statements, locals
+}
+
+func run(t *testing.T, in Interpreter, code string) error {
+	statements, locals := parse(t, code)
+	_, err := in.Interpret(statements, locals)
+	return err
+}
+
+// modes are the ways an interpreter can run a program, they must behave the same
+var modes = []struct {
+	name            string
+	compileClosures bool
+}{
+	{name: "walk", compileClosures: false},
+	{name: "closures", compileClosures: true},
+}
+
+func TestInterpreter_Programs(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		code   string
+		output string
+	}{
+		{
+			name:   "arithmetic",
+			code:   "print 1 + 2 * 3;\nprint (1 + 2) * 3;\nprint 7 / 2;\n",
+			output: "7\n9\n3.500000\n",
+		},
+		{
+			name:   "logical not",
+			code:   "print !true;\nprint !nil;\nprint !0;\n",
+			output: "false\ntrue\nfalse\n",
+		},
+		{
+			name:   "string concatenation",
+			code:   "var a = \"foo\";\nprint a + \"bar\";\n",
+			output: "foobar\n",
+		},
+		{
+			name:   "recursion",
+			code:   "fun fib(n) { if (n < 2) return n; return fib(n - 1) + fib(n - 2); }\nprint fib(15);\n",
+			output: "610\n",
+		},
+		{
+			name:   "closure counter",
+			code:   "fun make() { var i = 0; fun inc() { i = i + 1; return i; } return inc; }\nvar c = make();\nc();\nprint c();\n",
+			output: "2\n",
+		},
+		{
+			name:   "closure binds to declaration scope",
+			code:   "var a = \"global\";\n{ fun show() { print a; } show(); var a = \"block\"; show(); }\n",
+			output: "global\nglobal\n",
+		},
+		{
+			name:   "class with initializer",
+			code:   "class P { init(x) { this.x = x; } get() { return this.x; } }\nprint P(3).get();\nprint P;\nprint P(1);\n",
+			output: "3\nP\nP instance\n",
+		},
+		{
+			name:   "super call",
+			code:   "class A { f() { return \"A\"; } }\nclass B < A { f() { return \"B\" + super.f(); } }\nprint B().f();\n",
+			output: "BA\n",
+		},
+		{
+			name:   "equality doesn't convert types",
+			code:   "print 1 == \"1\";\nprint nil == false;\nprint \"a\" + \"b\" == \"ab\";\nprint clock == clock;\n",
+			output: "false\nfalse\ntrue\ntrue\n",
+		},
+		{
+			name:   "local slots",
+			code:   "fun f(a, b) {\n  var c = a + b;\n  { var a = c * 2; var d = a + 1; b = d; }\n  return a + b + c;\n}\nprint f(1, 2);\n",
+			output: "11\n",
+		},
+		{
+			name:   "local class",
+			code:   "{\n  var x = \"x\";\n  class A { make() { return A(); } name() { return x; } }\n  print A().make().name();\n}\n",
+			output: "x\n",
+		},
+		{
+			name:   "closures in a loop",
+			code:   "var fs = nil;\nfor (var i = 0; i < 2; i = i + 1) {\n  var j = i;\n  fun f() { return j; }\n  if (i == 0) fs = f;\n}\nprint fs();\n",
+			output: "0\n",
+		},
+		{
+			name:   "lists",
+			code:   "var xs = [1, \"two\", [nil]];\nxs[0] = xs[0] + 1;\npush(xs, xs);\nprint xs;\nprint len(xs);\nprint pop(xs) == xs;\ninsert(xs, 1, true);\nprint slice(xs, 1, 3);\nprint contains(xs, \"two\");\n",
+			output: "[2, \"two\", [nil], [...]]\n4\ntrue\n[true, \"two\"]\ntrue\n",
+		},
+		{
+			name:   "lists are shared",
+			code:   "var a = [1];\nvar b = a;\nb[0] = 2;\nprint a[0];\nprint [1] == [1];\n",
+			output: "2\nfalse\n",
+		},
+		{
+			name:   "maps",
+			code:   "var m = {\"a\": 1, 2: [3], -0: nil};\nm[\"a\"] = m[\"a\"] + 1;\nm[true] = m;\nprint m;\nprint m[0];\nprint delete(m, 2);\nprint has(m, 2);\nprint keys(m);\nprint values(m)[0];\nprint len(m);\n",
+			output: "{\"a\": 2, 2: [3], -0: nil, true: {...}}\nnil\ntrue\nfalse\n[\"a\", -0, true]\n2\n3\n",
+		},
+		{
+			name:   "for-in over built-in iterables",
+			code:   "for (x in [1, \"a\"]) print x;\nfor (k in {\"k\": 1, 2: 3}) print k;\nfor (c in \"hé\") print c;\nfor (n in range(3, 0, -1.5)) print n;\nvar xs = [1];\nfor (x in xs) if (x < 3) push(xs, x + 1);\nprint xs;\n",
+			output: "1\na\nk\n2\nh\né\n3\n1.500000\n[1, 2, 3]\n",
+		},
+		{
+			name:   "for-in iterator protocol",
+			code:   "class Down { init(n) { this.n = n; } hasNext() { return this.n > 0; } next() { this.n = this.n - 1; return this.n; } }\nclass Box { iterator() { return Down(2); } }\nfor (n in Down(2)) print n;\nfor (n in Box()) print n;\n",
+			output: "1\n0\n1\n0\n",
+		},
+		{
+			name:   "for-in variable per iteration",
+			code:   "var fs = [];\nfor (i in range(0, 2)) { fun f() { return i; } push(fs, f); }\nprint fs[0]() + fs[1]();\nprint range(0, 1);\n",
+			output: "1\nrange(0, 1, 1)\n",
+		},
+		{
+			name:   "break and continue",
+			code:   "for (var i = 0; i < 10; i = i + 1) { if (i == 1) continue; if (i == 4) break; print i; }\nvar j = 0;\nwhile (true) { j = j + 1; if (j < 3) continue; print j; break; }\nfor (x in [1, 2, 3, 4]) { var y = x * 10; if (x == 2) continue; if (x == 4) break; print y; }\n",
+			output: "0\n2\n3\n3\n10\n30\n",
+		},
+		{
+			name:   "break only leaves the innermost loop",
+			code:   "for (var i = 0; i < 2; i = i + 1) { for (j in range(0, 5)) { if (j == 1) break; print i + j; } }\n",
+			output: "0\n1\n",
+		},
+		{
+			name:   "break and continue close captured variables",
+			code:   "var fs = [];\nfor (var i = 0; i < 4; i = i + 1) { var k = i; fun f() { return k; } push(fs, f); if (i == 1) continue; if (i == 2) break; }\nprint fs[0]() + fs[1]() + fs[2]();\nfor (x in [1]) { fun g() { return x; } push(fs, g); break; }\nprint fs[3]();\n",
+			output: "3\n1\n",
+		},
+		{
+			name:   "return from a loop in a function",
+			code:   "fun find(xs, v) { for (x in xs) { while (true) { if (x == v) return x; break; } } return nil; }\nprint find([1, 2, 3], 2);\nprint find([1], 5);\n",
+			output: "2\nnil\n",
+		},
+		{
+			name:   "catch runtime errors",
+			code:   "try { print 1 + nil; } catch (e) { print e; print e.message; print e.line; }\nfun f(n) { if (n == 0) [][0]; f(n - 1); }\ntry { f(3); print \"unreachable\"; } catch (e) { print e.message; }\n",
+			output: "operands must be both numbers or both strings\noperands must be both numbers or both strings\n1\nlist index 0 is out of range for a list of length 0\n",
+		},
+		{
+			name:   "throw any value",
+			code:   "class Oops { init(code) { this.code = code; } }\ntry { throw Oops(42); } catch (e) { print e.code; }\ntry { throw \"text\"; } catch (e) { print e; }\nvar first;\ntry { nil(); } catch (e) { first = e; }\ntry { throw first; } catch (e) { print e == first; }\n",
+			output: "42\ntext\ntrue\n",
+		},
+		{
+			name:   "finally runs on every way out",
+			code:   "fun f() { try { return \"try\"; } finally { print \"finally after return\"; } }\nprint f();\nfun g() { try { throw 1; } finally { return \"finally wins\"; } }\nprint g();\nfor (var i = 0; i < 3; i = i + 1) { try { if (i == 0) continue; if (i == 2) break; print i; } finally { print \"finally\"; } }\ntry { try { throw \"inner\"; } finally { print \"inner finally\"; } } catch (e) { print e; }\n",
+			output: "finally after return\ntry\nfinally wins\nfinally\n1\nfinally\nfinally\ninner finally\ninner\n",
+		},
+		{
+			name:   "exceptions thrown by catch and finally",
+			code:   "try { try { throw 1; } catch (e) { throw e + 1; } finally { print \"cleanup\"; } } catch (e) { print e; }\ntry { try { throw 1; } finally { throw 3; } } catch (e) { print e; }\nwhile (true) { try { throw 4; } finally { break; } }\nprint \"done\";\n",
+			output: "cleanup\n2\n3\ndone\n",
+		},
+		{
+			name:   "caught locals and closures",
+			code:   "var fs = [];\nfor (x in [1, 2]) { try { var y = x * 10; fun h() { return y; } push(fs, h); throw y; } catch (e) { var z = e + 1; fun k() { return z; } push(fs, k); } }\nprint fs[0]() + fs[1]() + fs[2]() + fs[3]();\n",
+			output: "62\n",
+		},
+	}
+
+	for _, mode := range modes {
+		for _, tc := range cases {
+			mode, tc := mode, tc
+			t.Run(mode.name+"/"+tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				stdout := &bytes.Buffer{}
+				err := run(t, NewInterpreter(Config{Stdout: stdout, CompileClosures: mode.compileClosures}), tc.code)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.output, stdout.String())
+			})
+		}
+	}
+}
+
+func TestInterpreter_RuntimeErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		code    string
+		message string
+	}{
+		{
+			name:    "undefined variable",
+			code:    "print a;\n",
+			message: "undefined variable 'a'",
+		},
+		{
+			name:    "wrong arity",
+			code:    "fun f(a) {}\nf(1, 2);\n",
+			message: "expected 1 arguments but got 2",
+		},
+		{
+			name:    "call non callable",
+			code:    "\"f\"();\n",
+			message: "can only call functions and classes",
+		},
+		{
+			name:    "superclass is not a class",
+			code:    "var A = 1;\nclass B < A {}\n",
+			message: "superclass must be a class",
+		},
+		{
+			name:    "negate a string",
+			code:    "print -\"a\";\n",
+			message: "operand must be a number",
+		},
+		{
+			name:    "compare a string",
+			code:    "print 1 < \"a\";\n",
+			message: "operand must be a number",
+		},
+		{
+			name:    "add mixed types",
+			code:    "print 1 + nil;\n",
+			message: "operands must be both numbers or both strings",
+		},
+		{
+			name:    "undefined property",
+			code:    "class A {}\nA().b;\n",
+			message: "undefined property 'b'",
+		},
+		{
+			name:    "field of a number",
+			code:    "var a = 1;\na.b = 2;\n",
+			message: "only instances have fields",
+		},
+		{
+			name:    "assign undefined variable",
+			code:    "a = 1;\n",
+			message: "undefined variable 'a'",
+		},
+		{
+			name:    "index a string",
+			code:    "var s = \"abc\";\nprint s[0];\n",
+			message: "only lists and maps can be indexed",
+		},
+		{
+			name:    "fractional index",
+			code:    "var xs = [1];\nprint xs[0.5];\n",
+			message: "list index must be an integer",
+		},
+		{
+			name:    "negative index",
+			code:    "var xs = [1];\nxs[-1] = 2;\n",
+			message: "list index -1 is negative",
+		},
+		{
+			name:    "index out of range",
+			code:    "var xs = [1];\nprint xs[1];\n",
+			message: "list index 1 is out of range for a list of length 1",
+		},
+		{
+			name:    "pop an empty list",
+			code:    "pop([]);\n",
+			message: "can't pop from an empty list",
+		},
+		{
+			name:    "push to a number",
+			code:    "push(1, 2);\n",
+			message: "push expects a list",
+		},
+		{
+			name:    "list key",
+			code:    "var m = {};\nm[[]] = 1;\n",
+			message: "list can't be a map key",
+		},
+		{
+			name:    "NaN key in a literal",
+			code:    "var nan = 0 / 0;\nprint {nan: 1};\n",
+			message: "NaN can't be a map key",
+		},
+		{
+			name:    "missing key",
+			code:    "var m = {\"a\": 1};\nprint m[\"b\"];\n",
+			message: "key \"b\" is not in the map",
+		},
+		{
+			name:    "keys of a list",
+			code:    "keys([]);\n",
+			message: "keys expects a map",
+		},
+		{
+			name:    "for-in over a number",
+			code:    "for (x in 1) print x;\n",
+			message: "number is not iterable",
+		},
+		{
+			name:    "iterator without next",
+			code:    "class A { hasNext() { return true; } }\nfor (x in A()) print x;\n",
+			message: "undefined property 'next'",
+		},
+		{
+			name:    "iterator that isn't an instance",
+			code:    "class A { iterator() { return 1; } }\nfor (x in A()) print x;\n",
+			message: "only instances have properties",
+		},
+		{
+			name:    "range step",
+			code:    "range(0, 1, 0);\n",
+			message: "range step must be finite and not 0",
+		},
+		{
+			name:    "uncaught exception",
+			code:    "try { throw \"boom\"; } finally { print 1; }\n",
+			message: "boom",
+		},
+		{
+			name:    "property of a caught error",
+			code:    "try { nil(); } catch (e) { print e.code; }\n",
+			message: "undefined property 'code'",
+		},
+	}
+
+	for _, mode := range modes {
+		for _, tc := range cases {
+			mode, tc := mode, tc
+			t.Run(mode.name+"/"+tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				err := run(t, NewInterpreter(Config{Stdout: &bytes.Buffer{}, CompileClosures: mode.compileClosures}), tc.code)
+				assert.EqualError(t, err, tc.message)
+			})
+		}
+	}
+}
+
+func TestInterpreter_StackTrace(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		code  string
+		trace []StackFrame
+	}{
+		{
+			name:  "top-level error",
+			code:  "print 1;\nprint -nil;\n",
+			trace: []StackFrame{{Function: "script", Line: 2}},
+		},
+		{
+			name: "nested calls",
+			code: "fun inner() {\nreturn nil + 1;\n}\nfun outer() {\nreturn inner();\n}\nclass A {\ninit() { outer(); }\n}\n\nA();\n",
+			trace: []StackFrame{
+				{Function: "inner", Line: 2},
+				{Function: "outer", Line: 5},
+				{Function: "init", Line: 8},
+				{Function: "script", Line: 11},
+			},
+		},
+		{
+			name: "natives have no frame",
+			code: "fun f() {\nreturn len(1);\n}\nf();\n",
+			trace: []StackFrame{
+				{Function: "f", Line: 2},
+				{Function: "script", Line: 4},
+			},
+		},
+		{
+			name: "exception passing through finally blocks",
+			code: "fun f() {\ntry { g(); } finally { print 1; }\n}\nfun g() {\nthrow \"boom\";\n}\ntry {\nf();\n} finally {\nprint 2;\n}\n",
+			trace: []StackFrame{
+				{Function: "g", Line: 5},
+				{Function: "f", Line: 2},
+				{Function: "script", Line: 8},
+			},
+		},
+		{
+			name: "caught error thrown again",
+			code: "fun f() {\nnil();\n}\nfun rethrow(e) {\nthrow e;\n}\ntry { f(); } catch (e) {\nrethrow(e);\n}\n",
+			trace: []StackFrame{
+				{Function: "rethrow", Line: 5},
+				{Function: "script", Line: 8},
+			},
+		},
+	}
+
+	for _, mode := range modes {
+		for _, tc := range cases {
+			mode, tc := mode, tc
+			t.Run(mode.name+"/"+tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				err := run(t, NewInterpreter(Config{Stdout: &bytes.Buffer{}, CompileClosures: mode.compileClosures}), tc.code)
+				require.IsType(t, &RuntimeError{}, err)
+				assert.Equal(t, tc.trace, err.(*RuntimeError).Trace)
+			})
+		}
+	}
+}
+
+func TestInterpreter_InstancesAreIsolated(t *testing.T) {
+	t.Parallel()
+
+	const workers = 8
+	outputs := make([]*bytes.Buffer, workers)
+	errs := make([]error, workers)
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		outputs[w] = &bytes.Buffer{}
+		statements, locals := parse(t, fmt.Sprintf("var n = %d;\nfor (var i = 0; i < 1000; i = i + 1) { n = n + 1; }\nprint n;\n", w))
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			in := NewInterpreter(Config{Stdout: outputs[w]})
+			_, errs[w] = in.Interpret(statements, locals)
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		assert.NoError(t, errs[w])
+		assert.Equal(t, fmt.Sprintf("%d\n", w+1000), outputs[w].String())
+	}
+}
+
+func TestInterpreter_GlobalsPersistBetweenPrograms(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range modes {
+		mode := mode
+		t.Run(mode.name, func(t *testing.T) {
+			t.Parallel()
+
+			stdout := &bytes.Buffer{}
+			in := NewInterpreter(Config{Stdout: stdout, CompileClosures: mode.compileClosures})
+			assert.NoError(t, run(t, in, "fun greet(name) { var greeting = \"hi \"; return greeting + name; }\n"))
+			assert.NoError(t, run(t, in, "class A { name() { return \"a\"; } }\nclass B < A { name() { var n = super.name(); return n + this.suffix; } }\n"))
+			assert.NoError(t, run(t, in, "var b = B();\nb.suffix = \"b\";\n{ var local = greet(b.name()); print local; }\n"))
+			assert.Equal(t, "hi ab\n", stdout.String())
+			// the resolved slots of a finished program are only kept by the functions it declared
+			assert.Nil(t, in.(*interpreter).locals)
+		})
+	}
+}
+
+func TestInterpreter_DefineNative(t *testing.T) {
+	t.Parallel()
+
+	stdout := &bytes.Buffer{}
+	in := NewInterpreter(Config{Stdout: stdout})
+	in.DefineNative("double", 1, func(arguments []any) (any, error) {
+		n, ok := arguments[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("double expects a number")
+		}
+		return int(n) * 2, nil
+	})
+	in.DefineNative("join", -1, func(arguments []any) (any, error) {
+		res := ""
+		for _, argument := range arguments {
+			value, err := NewValue(argument)
+			if err != nil {
+				return nil, err
+			}
+			res += value.String()
+		}
+		return res, nil
+	})
+
+	assert.NoError(t, run(t, in, "print double(21) + 1;\nprint join(\"a\", 1, true, nil);\nprint double;\n"))
+	assert.Equal(t, "43\na1truenil\n<native fn>\n", stdout.String())
+
+	err := run(t, in, "double(\"x\");\n")
+	assert.EqualError(t, err, "double expects a number")
+	assert.Equal(t, 1, err.(*RuntimeError).Token.Line())
+}
+
+func TestInterpreter_Globals(t *testing.T) {
+	t.Parallel()
+
+	stdout := &bytes.Buffer{}
+	in := NewInterpreter(Config{Stdout: stdout})
+	assert.NoError(t, in.SetGlobal("limit", 3))
+	assert.NoError(t, in.SetGlobal("name", "rule"))
+	assert.EqualError(t, in.SetGlobal("bad", struct{}{}), "unsupported value type struct {}")
+
+	assert.NoError(t, run(t, in, "var total = 0;\nfor (var i = 0; i < limit; i = i + 1) { total = total + i; }\nprint name;\n"))
+	assert.Equal(t, "rule\n", stdout.String())
+
+	total, err := in.GetGlobal("total")
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, total)
+
+	_, err = in.GetGlobal("missing")
+	assert.EqualError(t, err, "undefined variable 'missing'")
+}
+
+func TestInterpreter_Limits(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range modes {
+		mode := mode
+
+		t.Run(mode.name+"/max steps", func(t *testing.T) {
+			t.Parallel()
+
+			in := NewInterpreter(Config{Stdout: &bytes.Buffer{}, MaxSteps: 1000, CompileClosures: mode.compileClosures})
+			err := run(t, in, "while (true) {}\n")
+			assert.ErrorIs(t, err, ErrStepLimitExceeded)
+
+			assert.NoError(t, run(t, in, "var a = 1;\n"), "steps are counted per Interpret call")
+		})
+
+		t.Run(mode.name+"/limits can't be caught", func(t *testing.T) {
+			t.Parallel()
+
+			stdout := &bytes.Buffer{}
+			in := NewInterpreter(Config{Stdout: stdout, MaxSteps: 1000, CompileClosures: mode.compileClosures})
+			err := run(t, in, "try { while (true) {} } catch (e) { print e; } finally { print 1; }\n")
+			assert.ErrorIs(t, err, ErrStepLimitExceeded)
+
+			in = NewInterpreter(Config{Stdout: stdout, MaxCallDepth: 50, CompileClosures: mode.compileClosures})
+			err = run(t, in, "fun f() { try { f(); } finally { print 2; } }\nf();\n")
+			assert.ErrorIs(t, err, ErrCallDepthExceeded)
+			assert.Empty(t, stdout.String())
+		})
+
+		t.Run(mode.name+"/max call depth", func(t *testing.T) {
+			t.Parallel()
+
+			in := NewInterpreter(Config{Stdout: &bytes.Buffer{}, MaxCallDepth: 50, CompileClosures: mode.compileClosures})
+			err := run(t, in, "fun f(n) { return f(n + 1); }\nf(0);\n")
+			assert.ErrorIs(t, err, ErrCallDepthExceeded)
+			assert.Equal(t, 1, err.(*RuntimeError).Token.Line())
+
+			// the script is the first of the 50 frames
+			assert.NoError(t, run(t, in, "fun g(n) { if (n > 0) return g(n - 1); }\ng(48);\n"))
+		})
+
+		t.Run(mode.name+"/default call depth", func(t *testing.T) {
+			t.Parallel()
+
+			in := NewInterpreter(Config{Stdout: &bytes.Buffer{}, CompileClosures: mode.compileClosures})
+			err := run(t, in, "fun f(n) { return f(n + 1); }\nf(0);\n")
+			assert.ErrorIs(t, err, ErrCallDepthExceeded)
+			assert.Len(t, err.(*RuntimeError).Trace, DefaultMaxCallDepth)
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		in := NewInterpreter(Config{Stdout: &bytes.Buffer{}})
+		statements, locals := parse(t, "while (true) {}\n")
+		_, err := in.InterpretContext(ctx, statements, locals)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.NotErrorIs(t, err, ErrStepLimitExceeded)
+	})
+
+	t.Run("canceled during recursion", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		in := NewInterpreter(Config{Stdout: &bytes.Buffer{}})
+		statements, locals := parse(t, "fun f() { return 1; }\nf();\n")
+		_, err := in.InterpretContext(ctx, statements, locals)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestInterpreter_ClosuresCountTheSameSteps(t *testing.T) {
+	t.Parallel()
+
+	code := "class A { init(n) { this.n = (n); } get() { return this.n; } }\n" +
+		"fun f(n) { if (n < 2) return n; return f(n - 1) + f(n - 2); }\n" +
+		"var a = A(f(10));\nwhile (a.get() > 0 and !false) { a.n = a.n - 1; }\n{ var b; b = -1; print b; }\n"
+
+	steps := make([]int, 0, len(modes))
+	for _, mode := range modes {
+		in := NewInterpreter(Config{Stdout: &bytes.Buffer{}, MaxSteps: 1 << 30, CompileClosures: mode.compileClosures})
+		require.NoError(t, run(t, in, code))
+		steps = append(steps, in.(*interpreter).steps)
+	}
+	assert.Equal(t, steps[0], steps[1])
+}
@@ -0,0 +1,203 @@
+package interpreter
+
+import (
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/tokens"
+	"math"
+	"unicode/utf8"
+)
+
+type (
+	// iterator produces the values a for-in loop runs its body with
+	iterator interface {
+		// next returns the next value, ok is false once there are no values left
+		next(i *interpreter) (value Value, ok bool, err error)
+	}
+
+	// loxRange is the sequence of numbers from start up to end, excluded, created by the range built-in
+	loxRange struct {
+		start float64
+		end   float64
+		step  float64
+	}
+
+	// listIterator reads the list by index as the loop runs, so elements pushed by the body are visited too
+	listIterator struct {
+		list     *loxList
+		position int
+	}
+
+	// keysIterator visits the keys a map had when the loop started
+	keysIterator struct {
+		keys     []Value
+		position int
+	}
+
+	stringIterator struct {
+		s      string
+		offset int
+	}
+
+	rangeIterator struct {
+		r        *loxRange
+		position int
+	}
+
+	// objectIterator runs the iterator protocol of a Lox object: it calls hasNext() and then next() for every value.
+	// Both methods are looked up on every iteration, like any other method call.
+	objectIterator struct {
+		object      Value
+		keyword     tokens.Token
+		hasNextName tokens.Token
+		nextName    tokens.Token
+	}
+)
+
+// iterate returns the iterator of a for-in loop over iterable, errors are reported at keyword.
+// Lists, maps, strings and ranges have built-in iterators. Any other object is its own iterator
+// unless it has an iterator() method, whose result is used instead.
+func (i *interpreter) iterate(iterable Value, keyword tokens.Token) (iterator, error) {
+	switch iterable.kind {
+	case ListKind:
+		return &listIterator{list: iterable.object.(*loxList)}, nil
+	case MapKind:
+		return &keysIterator{keys: iterable.object.(*loxMap).keys()}, nil
+	case StringKind:
+		return &stringIterator{s: iterable.AsString()}, nil
+	case RangeKind:
+		return &rangeIterator{r: iterable.object.(*loxRange)}, nil
+	case InstanceKind:
+		it := &objectIterator{
+			object:      iterable,
+			keyword:     keyword,
+			hasNextName: protocolToken("hasNext", keyword),
+			nextName:    protocolToken("next", keyword),
+		}
+		instance := iterable.object.(*loxInstance)
+		if _, found := instance.fields["iterator"]; !found && instance.class.findMethod("iterator") == nil {
+			return it, nil
+		}
+		method, err := instance.Get(protocolToken("iterator", keyword))
+		if err != nil {
+			return nil, atKeyword(err, keyword)
+		}
+		if it.object, err = i.call(method, nil, keyword); err != nil {
+			return nil, err
+		}
+		return it, nil
+	}
+	return nil, &RuntimeError{err: fmt.Errorf("%s is not iterable", iterable.kind), Token: keyword}
+}
+
+// protocolToken names an iterator protocol method, it points at the for keyword so errors are reported there
+func protocolToken(name string, keyword tokens.Token) tokens.Token {
+	return tokens.NewSourceToken(tokens.Identifier, name, nil, keyword.Line(), keyword.Position(), keyword.Start(), keyword.Source())
+}
+
+// atKeyword reports an error raised at a protocol method name at the for keyword itself,
+// the name isn't in the source and its span would cover whatever follows the keyword
+func atKeyword(err error, keyword tokens.Token) error {
+	if re, ok := err.(*RuntimeError); ok && re.Token != nil && re.Token.Start() == keyword.Start() {
+		re.Token = keyword
+	}
+	return err
+}
+
+func (it *listIterator) next(_ *interpreter) (Value, bool, error) {
+	if it.position >= len(it.list.elements) {
+		return Nil, false, nil
+	}
+	it.position++
+	return it.list.elements[it.position-1], true, nil
+}
+
+func (it *keysIterator) next(_ *interpreter) (Value, bool, error) {
+	if it.position >= len(it.keys) {
+		return Nil, false, nil
+	}
+	it.position++
+	return it.keys[it.position-1], true, nil
+}
+
+func (it *stringIterator) next(_ *interpreter) (Value, bool, error) {
+	if it.offset >= len(it.s) {
+		return Nil, false, nil
+	}
+	_, size := utf8.DecodeRuneInString(it.s[it.offset:])
+	it.offset += size
+	return NewString(it.s[it.offset-size : it.offset]), true, nil
+}
+
+func (it *rangeIterator) next(_ *interpreter) (Value, bool, error) {
+	value, ok := it.r.at(it.position)
+	if !ok {
+		return Nil, false, nil
+	}
+	it.position++
+	return NewNumber(value), true, nil
+}
+
+func (it *objectIterator) next(i *interpreter) (Value, bool, error) {
+	more, err := it.invoke(i, it.hasNextName)
+	if err != nil || !more.IsTruthy() {
+		return Nil, false, err
+	}
+	value, err := it.invoke(i, it.nextName)
+	if err != nil {
+		return Nil, false, err
+	}
+	return value, true, nil
+}
+
+// invoke calls a method of the iterator object without arguments
+func (it *objectIterator) invoke(i *interpreter, name tokens.Token) (Value, error) {
+	instance, ok := it.object.instance()
+	if !ok {
+		return Nil, &RuntimeError{err: fmt.Errorf("only instances have properties"), Token: it.keyword}
+	}
+	method, err := instance.Get(name)
+	if err != nil {
+		return Nil, atKeyword(err, it.keyword)
+	}
+	value, err := i.call(method, nil, it.keyword)
+	return value, atKeyword(err, it.keyword)
+}
+
+func newLoxRange(start float64, end float64, step float64) *loxRange {
+	return &loxRange{start: start, end: end, step: step}
+}
+
+// at returns the number at position, ok is false when the range ends before it.
+// The numbers are computed from the start instead of adding up steps, so fractional steps don't drift.
+func (r *loxRange) at(position int) (float64, bool) {
+	value := r.start + float64(position)*r.step
+	if r.step > 0 && value >= r.end || r.step < 0 && value <= r.end {
+		return 0, false
+	}
+	return value, true
+}
+
+func (r *loxRange) String() string {
+	return fmt.Sprintf("range(%s, %s, %s)", formatNumber(r.start), formatNumber(r.end), formatNumber(r.step))
+}
+
+// nativeRange creates the range from a start number up to an end number, excluded.
+// The optional third argument is the step between the numbers, it is 1 by default and may be negative.
+func nativeRange(arguments []Value) (Value, error) {
+	if len(arguments) != 2 && len(arguments) != 3 {
+		return Nil, fmt.Errorf("range expects 2 or 3 arguments but got %d", len(arguments))
+	}
+	step := NewNumber(1)
+	if len(arguments) == 3 {
+		step = arguments[2]
+	}
+	for _, argument := range []Value{arguments[0], arguments[1], step} {
+		if argument.kind != NumberKind || math.IsNaN(argument.number) {
+			return Nil, fmt.Errorf("range expects numbers")
+		}
+	}
+	if step.number == 0 || math.IsInf(step.number, 0) {
+		return Nil, fmt.Errorf("range step must be finite and not 0")
+	}
+	return newRangeValue(newLoxRange(arguments[0].number, arguments[1].number, step.number)), nil
+}
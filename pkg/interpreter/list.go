@@ -0,0 +1,189 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// loxList is a growable list of values, lists are shared by reference like instances
+	loxList struct {
+		elements []Value
+	}
+)
+
+func newLoxList(elements []Value) *loxList {
+	return &loxList{elements: elements}
+}
+
+// get reads the element at index, which must be a number between 0 and the length of the list
+func (l *loxList) get(index Value) (Value, error) {
+	i, err := listIndex(index, len(l.elements))
+	if err != nil {
+		return Nil, err
+	}
+	return l.elements[i], nil
+}
+
+// set overwrites the element at index, lists only grow with push and insert
+func (l *loxList) set(index Value, value Value) error {
+	i, err := listIndex(index, len(l.elements))
+	if err != nil {
+		return err
+	}
+	l.elements[i] = value
+	return nil
+}
+
+func (l *loxList) String() string {
+	return l.format(make(map[any]bool))
+}
+
+// format prints the list with its string elements quoted.
+// seen holds the lists and maps being printed, a list that contains itself is printed as [...] the second time.
+func (l *loxList) format(seen map[any]bool) string {
+	if seen[l] {
+		return "[...]"
+	}
+	seen[l] = true
+	defer delete(seen, l)
+
+	b := strings.Builder{}
+	b.WriteString("[")
+	for i, element := range l.elements {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(element.format(seen))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// listIndex checks that index is an integer between 0 and length, excluded, and converts it
+func listIndex(index Value, length int) (int, error) {
+	if index.kind != NumberKind || index.number != math.Trunc(index.number) || math.IsInf(index.number, 0) {
+		return 0, fmt.Errorf("list index must be an integer")
+	}
+	if index.number < 0 {
+		return 0, fmt.Errorf("list index %s is negative", formatNumber(index.number))
+	}
+	if index.number >= float64(length) {
+		return 0, fmt.Errorf("list index %s is out of range for a list of length %d", formatNumber(index.number), length)
+	}
+	return int(index.number), nil
+}
+
+// listNatives are the global functions that work with lists
+func listNatives() []*nativeFunction {
+	return []*nativeFunction{
+		{name: "len", arity: 1, fn: nativeLen},
+		{name: "push", arity: 2, fn: nativePush},
+		{name: "pop", arity: 1, fn: nativePop},
+		{name: "insert", arity: 3, fn: nativeInsert},
+		{name: "slice", arity: 3, fn: nativeSlice},
+		{name: "contains", arity: 2, fn: nativeContains},
+	}
+}
+
+// listArgument returns the first argument of the native function name, which must be a list
+func listArgument(name string, arguments []Value) (*loxList, error) {
+	list, ok := arguments[0].list()
+	if !ok {
+		return nil, fmt.Errorf("%s expects a list", name)
+	}
+	return list, nil
+}
+
+// nativeLen returns the number of elements of a list, the number of entries of a map or the number of characters of a string
+func nativeLen(arguments []Value) (Value, error) {
+	switch arguments[0].kind {
+	case ListKind:
+		return NewNumber(float64(len(arguments[0].object.(*loxList).elements))), nil
+	case MapKind:
+		return NewNumber(float64(len(arguments[0].object.(*loxMap).index))), nil
+	case StringKind:
+		return NewNumber(float64(utf8.RuneCountInString(arguments[0].AsString()))), nil
+	}
+	return Nil, fmt.Errorf("len expects a list, a map or a string")
+}
+
+// nativePush appends a value to the end of a list
+func nativePush(arguments []Value) (Value, error) {
+	list, err := listArgument("push", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	list.elements = append(list.elements, arguments[1])
+	return Nil, nil
+}
+
+// nativePop removes the last element of a list and returns it
+func nativePop(arguments []Value) (Value, error) {
+	list, err := listArgument("pop", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	if len(list.elements) == 0 {
+		return Nil, fmt.Errorf("can't pop from an empty list")
+	}
+	last := list.elements[len(list.elements)-1]
+	list.elements[len(list.elements)-1] = Nil
+	list.elements = list.elements[:len(list.elements)-1]
+	return last, nil
+}
+
+// nativeInsert inserts a value before the element at an index, the index may be the length of the list to append
+func nativeInsert(arguments []Value) (Value, error) {
+	list, err := listArgument("insert", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	i, err := listIndex(arguments[1], len(list.elements)+1)
+	if err != nil {
+		return Nil, err
+	}
+	list.elements = append(list.elements, Nil)
+	copy(list.elements[i+1:], list.elements[i:])
+	list.elements[i] = arguments[2]
+	return Nil, nil
+}
+
+// nativeSlice returns a new list with the elements from a start index up to an end index, excluded
+func nativeSlice(arguments []Value) (Value, error) {
+	list, err := listArgument("slice", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	// both bounds may be the length of the list
+	start, err := listIndex(arguments[1], len(list.elements)+1)
+	if err != nil {
+		return Nil, err
+	}
+	end, err := listIndex(arguments[2], len(list.elements)+1)
+	if err != nil {
+		return Nil, err
+	}
+	if start > end {
+		return Nil, fmt.Errorf("slice start %d is after its end %d", start, end)
+	}
+	elements := make([]Value, end-start)
+	copy(elements, list.elements[start:end])
+	return newListValue(newLoxList(elements)), nil
+}
+
+// nativeContains reports whether a list has an element equal to a value
+func nativeContains(arguments []Value) (Value, error) {
+	list, err := listArgument("contains", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	for _, element := range list.elements {
+		if element.Equal(arguments[1]) {
+			return NewBool(true), nil
+		}
+	}
+	return NewBool(false), nil
+}
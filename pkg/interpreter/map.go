@@ -0,0 +1,224 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type (
+	// loxMap maps keys to values and remembers the order the keys were first inserted in.
+	// Maps are shared by reference like lists and instances.
+	loxMap struct {
+		// entries holds the entries in insertion order, deleted entries stay as holes until the map is compacted
+		entries []mapEntry
+		// index maps the key of every live entry to its position in entries
+		index map[Key]int
+	}
+
+	mapEntry struct {
+		key     Value
+		value   Value
+		deleted bool
+	}
+)
+
+func newLoxMap() *loxMap {
+	return &loxMap{index: make(map[Key]int)}
+}
+
+// mapKey checks that a value can be used as a map key.
+// Lists and maps can change after they are inserted, and NaN isn't equal to itself, so none of them can be found again.
+func mapKey(key Value) (Key, error) {
+	switch {
+	case key.kind == ListKind || key.kind == MapKind:
+		return Key{}, fmt.Errorf("%s can't be a map key", key.kind)
+	case key.kind == NumberKind && math.IsNaN(key.number):
+		return Key{}, fmt.Errorf("NaN can't be a map key")
+	}
+	return key.Key(), nil
+}
+
+// get reads the value of a key, reading a missing key is an error
+func (m *loxMap) get(key Value) (Value, error) {
+	k, err := mapKey(key)
+	if err != nil {
+		return Nil, err
+	}
+	i, found := m.index[k]
+	if !found {
+		return Nil, fmt.Errorf("key %s is not in the map", key.format(make(map[any]bool)))
+	}
+	return m.entries[i].value, nil
+}
+
+// set adds a key at the end of the map or updates its value, updating a key doesn't move it
+func (m *loxMap) set(key Value, value Value) error {
+	k, err := mapKey(key)
+	if err != nil {
+		return err
+	}
+	if i, found := m.index[k]; found {
+		m.entries[i].value = value
+		return nil
+	}
+	m.index[k] = len(m.entries)
+	m.entries = append(m.entries, mapEntry{key: key, value: value})
+	return nil
+}
+
+func (m *loxMap) has(key Value) (bool, error) {
+	k, err := mapKey(key)
+	if err != nil {
+		return false, err
+	}
+	_, found := m.index[k]
+	return found, nil
+}
+
+// delete removes a key and reports whether it was in the map
+func (m *loxMap) delete(key Value) (bool, error) {
+	k, err := mapKey(key)
+	if err != nil {
+		return false, err
+	}
+	i, found := m.index[k]
+	if !found {
+		return false, nil
+	}
+	delete(m.index, k)
+	m.entries[i] = mapEntry{deleted: true}
+	// the holes are dropped once they are the majority, which keeps deletion cheap and iteration proportional to the size
+	if len(m.index) < len(m.entries)/2 {
+		m.compact()
+	}
+	return true, nil
+}
+
+func (m *loxMap) compact() {
+	entries := make([]mapEntry, 0, len(m.index))
+	for _, entry := range m.entries {
+		if !entry.deleted {
+			m.index[entry.key.Key()] = len(entries)
+			entries = append(entries, entry)
+		}
+	}
+	m.entries = entries
+}
+
+// keys returns the keys in insertion order
+func (m *loxMap) keys() []Value {
+	keys := make([]Value, 0, len(m.index))
+	for _, entry := range m.entries {
+		if !entry.deleted {
+			keys = append(keys, entry.key)
+		}
+	}
+	return keys
+}
+
+// values returns the values in the insertion order of their keys
+func (m *loxMap) values() []Value {
+	values := make([]Value, 0, len(m.index))
+	for _, entry := range m.entries {
+		if !entry.deleted {
+			values = append(values, entry.value)
+		}
+	}
+	return values
+}
+
+func (m *loxMap) String() string {
+	return m.format(make(map[any]bool))
+}
+
+// format prints the entries in insertion order with their string keys and values quoted.
+// seen holds the lists and maps being printed, a map that contains itself is printed as {...} the second time.
+func (m *loxMap) format(seen map[any]bool) string {
+	if seen[m] {
+		return "{...}"
+	}
+	seen[m] = true
+	defer delete(seen, m)
+
+	b := strings.Builder{}
+	b.WriteString("{")
+	first := true
+	for _, entry := range m.entries {
+		if entry.deleted {
+			continue
+		}
+		if !first {
+			b.WriteString(", ")
+		}
+		first = false
+		b.WriteString(entry.key.format(seen))
+		b.WriteString(": ")
+		b.WriteString(entry.value.format(seen))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// mapNatives are the global functions that work with maps, len works with maps too
+func mapNatives() []*nativeFunction {
+	return []*nativeFunction{
+		{name: "keys", arity: 1, fn: nativeKeys},
+		{name: "values", arity: 1, fn: nativeValues},
+		{name: "has", arity: 2, fn: nativeHas},
+		{name: "delete", arity: 2, fn: nativeDelete},
+	}
+}
+
+// mapArgument returns the first argument of the native function name, which must be a map
+func mapArgument(name string, arguments []Value) (*loxMap, error) {
+	m, ok := arguments[0].mapping()
+	if !ok {
+		return nil, fmt.Errorf("%s expects a map", name)
+	}
+	return m, nil
+}
+
+// nativeKeys returns a new list with the keys of a map in insertion order
+func nativeKeys(arguments []Value) (Value, error) {
+	m, err := mapArgument("keys", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	return newListValue(newLoxList(m.keys())), nil
+}
+
+// nativeValues returns a new list with the values of a map in the insertion order of their keys
+func nativeValues(arguments []Value) (Value, error) {
+	m, err := mapArgument("values", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	return newListValue(newLoxList(m.values())), nil
+}
+
+// nativeHas reports whether a map has a key
+func nativeHas(arguments []Value) (Value, error) {
+	m, err := mapArgument("has", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	found, err := m.has(arguments[1])
+	if err != nil {
+		return Nil, err
+	}
+	return NewBool(found), nil
+}
+
+// nativeDelete removes a key from a map and reports whether it was there
+func nativeDelete(arguments []Value) (Value, error) {
+	m, err := mapArgument("delete", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	found, err := m.delete(arguments[1])
+	if err != nil {
+		return Nil, err
+	}
+	return NewBool(found), nil
+}
@@ -0,0 +1,34 @@
+package interpreter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestLoxMap_DeleteKeepsInsertionOrder(t *testing.T) {
+	t.Parallel()
+
+	m := newLoxMap()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, m.set(NewNumber(float64(i)), NewString("v")))
+	}
+	// deleting most of the keys compacts the entries, the remaining keys must keep their order and stay reachable
+	for i := 0; i < 10; i++ {
+		if i%3 != 0 {
+			found, err := m.delete(NewNumber(float64(i)))
+			require.NoError(t, err)
+			assert.True(t, found)
+		}
+	}
+	require.NoError(t, m.set(NewNumber(1), NewString("again")))
+
+	assert.Equal(t, "{0: \"v\", 3: \"v\", 6: \"v\", 9: \"v\", 1: \"again\"}", m.String())
+	for _, key := range []float64{0, 3, 6, 9, 1} {
+		_, err := m.get(NewNumber(key))
+		assert.NoError(t, err, "key %v", key)
+	}
+	found, err := m.delete(NewNumber(2))
+	require.NoError(t, err)
+	assert.False(t, found)
+}
@@ -0,0 +1,330 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+)
+
+type (
+	Kind byte
+
+	// Value is a Lox value.
+	// Booleans and numbers are stored inline in number, so arithmetic doesn't allocate.
+	// Strings are kept in object, every other object is compared by identity.
+	Value struct {
+		kind   Kind
+		number float64
+		object any
+	}
+
+	// Key identifies a value in a Go map, values that are equal have equal keys
+	Key struct {
+		kind   Kind
+		number float64
+		object any
+	}
+)
+
+const (
+	NilKind Kind = iota
+	BoolKind
+	NumberKind
+	StringKind
+	// FunctionKind covers functions, bound methods and native functions
+	FunctionKind
+	ClassKind
+	InstanceKind
+	ListKind
+	MapKind
+	RangeKind
+	// ErrorKind is the kind of the values catch clauses receive for runtime errors
+	ErrorKind
+)
+
+var Nil = Value{}
+
+func NewBool(b bool) Value {
+	if b {
+		return Value{kind: BoolKind, number: 1}
+	}
+	return Value{kind: BoolKind}
+}
+
+func NewNumber(n float64) Value {
+	return Value{kind: NumberKind, number: n}
+}
+
+func NewString(s string) Value {
+	return Value{kind: StringKind, object: s}
+}
+
+// NewValue converts a Go value into a Lox value.
+// Go numbers of any type become Lox numbers, functions, classes and instances are only created by the interpreter.
+func NewValue(value any) (Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return Nil, nil
+	case Value:
+		return v, nil
+	case bool:
+		return NewBool(v), nil
+	case string:
+		return NewString(v), nil
+	case float64:
+		return NewNumber(v), nil
+	case float32:
+		return NewNumber(float64(v)), nil
+	case int:
+		return NewNumber(float64(v)), nil
+	case int8:
+		return NewNumber(float64(v)), nil
+	case int16:
+		return NewNumber(float64(v)), nil
+	case int32:
+		return NewNumber(float64(v)), nil
+	case int64:
+		return NewNumber(float64(v)), nil
+	case uint:
+		return NewNumber(float64(v)), nil
+	case uint8:
+		return NewNumber(float64(v)), nil
+	case uint16:
+		return NewNumber(float64(v)), nil
+	case uint32:
+		return NewNumber(float64(v)), nil
+	case uint64:
+		return NewNumber(float64(v)), nil
+	case *loxClass:
+		return Value{kind: ClassKind, object: v}, nil
+	case *loxInstance:
+		return Value{kind: InstanceKind, object: v}, nil
+	case *loxList:
+		return Value{kind: ListKind, object: v}, nil
+	case *loxMap:
+		return Value{kind: MapKind, object: v}, nil
+	case *loxRange:
+		return Value{kind: RangeKind, object: v}, nil
+	case *loxError:
+		return Value{kind: ErrorKind, object: v}, nil
+	case LoxCallable:
+		return Value{kind: FunctionKind, object: v}, nil
+	}
+
+	return Nil, fmt.Errorf("unsupported value type %T", value)
+}
+
+func newFunctionValue(function LoxCallable) Value {
+	return Value{kind: FunctionKind, object: function}
+}
+
+func newClassValue(class *loxClass) Value {
+	return Value{kind: ClassKind, object: class}
+}
+
+func newInstanceValue(instance *loxInstance) Value {
+	return Value{kind: InstanceKind, object: instance}
+}
+
+func newListValue(list *loxList) Value {
+	return Value{kind: ListKind, object: list}
+}
+
+func newMapValue(m *loxMap) Value {
+	return Value{kind: MapKind, object: m}
+}
+
+func newRangeValue(r *loxRange) Value {
+	return Value{kind: RangeKind, object: r}
+}
+
+func newErrorValue(e *loxError) Value {
+	return Value{kind: ErrorKind, object: e}
+}
+
+// literalValue converts the value of a literal token, which is nil, a bool, a float64 or a string
+func literalValue(literal any) Value {
+	switch v := literal.(type) {
+	case bool:
+		return NewBool(v)
+	case float64:
+		return NewNumber(v)
+	case string:
+		return NewString(v)
+	}
+	return Nil
+}
+
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+func (v Value) IsNil() bool {
+	return v.kind == NilKind
+}
+
+func (v Value) AsBool() bool {
+	return v.number != 0
+}
+
+func (v Value) AsNumber() float64 {
+	return v.number
+}
+
+func (v Value) AsString() string {
+	s, _ := v.object.(string)
+	return s
+}
+
+// Interface returns the Go form of the value: nil, a bool, a float64, a string or an opaque Lox object
+func (v Value) Interface() any {
+	switch v.kind {
+	case NilKind:
+		return nil
+	case BoolKind:
+		return v.number != 0
+	case NumberKind:
+		return v.number
+	}
+	return v.object
+}
+
+// callable returns the value as something that can be called, ok is false for values that can't
+func (v Value) callable() (LoxCallable, bool) {
+	if v.kind == FunctionKind || v.kind == ClassKind {
+		return v.object.(LoxCallable), true
+	}
+	return nil, false
+}
+
+// instance returns the value as an instance, ok is false for values that aren't instances
+func (v Value) instance() (*loxInstance, bool) {
+	if v.kind == InstanceKind {
+		return v.object.(*loxInstance), true
+	}
+	return nil, false
+}
+
+// list returns the value as a list, ok is false for values that aren't lists
+func (v Value) list() (*loxList, bool) {
+	if v.kind == ListKind {
+		return v.object.(*loxList), true
+	}
+	return nil, false
+}
+
+// mapping returns the value as a map, ok is false for values that aren't maps
+func (v Value) mapping() (*loxMap, bool) {
+	if v.kind == MapKind {
+		return v.object.(*loxMap), true
+	}
+	return nil, false
+}
+
+// errorValue returns the value as a runtime error caught by a catch clause, ok is false for other values
+func (v Value) errorValue() (*loxError, bool) {
+	if v.kind == ErrorKind {
+		return v.object.(*loxError), true
+	}
+	return nil, false
+}
+
+// IsTruthy follows Lox truthiness: only nil and false are falsey
+func (v Value) IsTruthy() bool {
+	switch v.kind {
+	case NilKind:
+		return false
+	case BoolKind:
+		return v.number != 0
+	}
+	return true
+}
+
+// Equal reports whether two values are equal in Lox.
+// Values of different kinds are never equal, numbers follow IEEE 754 and objects are equal only to themselves.
+func (v Value) Equal(other Value) bool {
+	if v.kind != other.kind {
+		return false
+	}
+	switch v.kind {
+	case NilKind:
+		return true
+	case BoolKind, NumberKind:
+		return v.number == other.number
+	}
+	// strings compare by content, other objects by identity
+	return v.object == other.object
+}
+
+// Key returns the map key of the value.
+// Zero and negative zero are equal so they share a key. NaN isn't equal to anything, not even itself,
+// so a NaN key never matches another one.
+func (v Value) Key() Key {
+	number := v.number
+	if number == 0 {
+		number = 0
+	}
+	return Key{kind: v.kind, number: number, object: v.object}
+}
+
+func (v Value) String() string {
+	switch v.kind {
+	case NilKind:
+		return "nil"
+	case BoolKind:
+		return fmt.Sprint(v.number != 0)
+	case NumberKind:
+		return formatNumber(v.number)
+	case StringKind:
+		return v.object.(string)
+	}
+	return fmt.Sprint(v.object)
+}
+
+// format prints a value inside a list or a map, strings are quoted so they can be told apart from other values.
+// seen holds the lists and maps being printed, so a container that contains itself isn't printed forever.
+func (v Value) format(seen map[any]bool) string {
+	switch v.kind {
+	case StringKind:
+		return `"` + v.object.(string) + `"`
+	case ListKind:
+		return v.object.(*loxList).format(seen)
+	case MapKind:
+		return v.object.(*loxMap).format(seen)
+	}
+	return v.String()
+}
+
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) {
+		return fmt.Sprintf("%.0f", n)
+	}
+	return fmt.Sprintf("%f", n)
+}
+
+func (k Kind) String() string {
+	switch k {
+	case NilKind:
+		return "nil"
+	case BoolKind:
+		return "boolean"
+	case NumberKind:
+		return "number"
+	case StringKind:
+		return "string"
+	case FunctionKind:
+		return "function"
+	case ClassKind:
+		return "class"
+	case InstanceKind:
+		return "instance"
+	case ListKind:
+		return "list"
+	case MapKind:
+		return "map"
+	case RangeKind:
+		return "range"
+	case ErrorKind:
+		return "error"
+	}
+	return "unknown"
+}
@@ -0,0 +1,163 @@
+package interpreter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestValue_String(t *testing.T) {
+	t.Parallel()
+
+	class := newLoxClass("A", nil, nil)
+	cases := []struct {
+		value    Value
+		expected string
+	}{
+		{value: Nil, expected: "nil"},
+		{value: NewBool(true), expected: "true"},
+		{value: NewBool(false), expected: "false"},
+		{value: NewNumber(3), expected: "3"},
+		{value: NewNumber(-0.5), expected: "-0.500000"},
+		{value: NewNumber(math.NaN()), expected: "NaN"},
+		{value: NewString("a b"), expected: "a b"},
+		{value: NewString(""), expected: ""},
+		{value: newClassValue(class), expected: "A"},
+		{value: newInstanceValue(newLoxInstance(class)), expected: "A instance"},
+		{value: newFunctionValue(newNativeFunction("clock", 0, clock)), expected: "<native fn>"},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.expected, tc.value.String(), "%s value", tc.value.Kind())
+	}
+}
+
+func TestValue_IsTruthy(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, Nil.IsTruthy())
+	assert.False(t, NewBool(false).IsTruthy())
+	assert.True(t, NewBool(true).IsTruthy())
+	assert.True(t, NewNumber(0).IsTruthy())
+	assert.True(t, NewString("").IsTruthy())
+	assert.True(t, newInstanceValue(newLoxInstance(newLoxClass("A", nil, nil))).IsTruthy())
+}
+
+func TestNewValue(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		value    any
+		expected Value
+	}{
+		{name: "nil", value: nil, expected: Nil},
+		{name: "bool", value: true, expected: NewBool(true)},
+		{name: "string", value: "s", expected: NewString("s")},
+		{name: "float64", value: 1.5, expected: NewNumber(1.5)},
+		{name: "int", value: -2, expected: NewNumber(-2)},
+		{name: "uint8", value: uint8(7), expected: NewNumber(7)},
+		{name: "value", value: NewString("v"), expected: NewString("v")},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			value, err := NewValue(tc.value)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, value)
+		})
+	}
+
+	_, err := NewValue([]int{1})
+	assert.EqualError(t, err, "unsupported value type []int")
+
+	instance := newLoxInstance(newLoxClass("A", nil, nil))
+	value, err := NewValue(instance)
+	require.NoError(t, err)
+	assert.Equal(t, InstanceKind, value.Kind())
+	assert.Same(t, instance, value.Interface())
+}
+
+func TestValue_Key(t *testing.T) {
+	t.Parallel()
+
+	class := newLoxClass("A", nil, nil)
+	a, b := newLoxInstance(class), newLoxInstance(class)
+
+	m := map[Key]string{
+		NewNumber(1).Key():          "number",
+		NewString("1").Key():        "string",
+		NewBool(true).Key():         "bool",
+		Nil.Key():                   "nil",
+		newInstanceValue(a).Key():   "a",
+		newInstanceValue(b).Key():   "b",
+		newClassValue(class).Key():  "class",
+		NewNumber(math.NaN()).Key(): "nan",
+	}
+	assert.Len(t, m, 8)
+	assert.Equal(t, "number", m[NewNumber(1).Key()])
+	assert.Equal(t, "string", m[NewString("1").Key()])
+	assert.Equal(t, "bool", m[NewBool(true).Key()])
+	assert.Equal(t, "nil", m[Nil.Key()])
+	assert.Equal(t, "a", m[newInstanceValue(a).Key()])
+	assert.Equal(t, "b", m[newInstanceValue(b).Key()])
+
+	_, found := m[NewNumber(math.NaN()).Key()]
+	assert.False(t, found, "NaN never equals another NaN")
+
+	assert.Equal(t, NewNumber(0).Key(), NewNumber(math.Copysign(0, -1)).Key(), "0 and -0 are equal")
+}
+
+// TestValue_Equal checks every pair of value kinds, values in the same group are equal and all the others are not
+func TestValue_Equal(t *testing.T) {
+	t.Parallel()
+
+	class := newLoxClass("A", nil, map[string]*loxFunction{})
+	other := newLoxClass("A", nil, map[string]*loxFunction{})
+	instance := newLoxInstance(class)
+	native := newNativeFunction("clock", 0, clock)
+
+	// values with the same group are equal, NaN has no group because it isn't even equal to itself
+	const noGroup = -1
+	values := []struct {
+		name  string
+		value Value
+		group int
+	}{
+		{name: "nil", value: Nil, group: 0},
+		{name: "true", value: NewBool(true), group: 1},
+		{name: "false", value: NewBool(false), group: 2},
+		{name: "0", value: NewNumber(0), group: 3},
+		{name: "-0", value: NewNumber(math.Copysign(0, -1)), group: 3},
+		{name: "1", value: NewNumber(1), group: 4},
+		{name: "NaN", value: NewNumber(math.NaN()), group: noGroup},
+		{name: "+Inf", value: NewNumber(math.Inf(1)), group: 5},
+		{name: `""`, value: NewString(""), group: 6},
+		{name: `"1"`, value: NewString("1"), group: 7},
+		{name: `"true"`, value: NewString("true"), group: 8},
+		{name: `"nil"`, value: NewString("nil"), group: 9},
+		{name: `"A instance"`, value: NewString("A instance"), group: 10},
+		{name: "native", value: newFunctionValue(native), group: 11},
+		{name: "same native", value: newFunctionValue(native), group: 11},
+		{name: "another native", value: newFunctionValue(newNativeFunction("clock", 0, clock)), group: 12},
+		{name: "class", value: newClassValue(class), group: 13},
+		{name: "class with the same name", value: newClassValue(other), group: 14},
+		{name: "instance", value: newInstanceValue(instance), group: 15},
+		{name: "same instance", value: newInstanceValue(instance), group: 15},
+		{name: "instance of the same class", value: newInstanceValue(newLoxInstance(class)), group: 16},
+	}
+
+	for _, left := range values {
+		for _, right := range values {
+			expected := left.group != noGroup && left.group == right.group
+			assert.Equal(t, expected, left.value.Equal(right.value), "%s == %s", left.name, right.name)
+			if expected {
+				assert.Equal(t, left.value.Key(), right.value.Key(), "equal %s and %s have different keys", left.name, right.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package lox
+
+import (
+	"context"
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/compiler"
+	"github.com/mtvarkovsky/golox/pkg/interpreter"
+	"github.com/mtvarkovsky/golox/pkg/vm"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type (
+	// BytecodeInterpreter compiles programs to bytecode and runs them on a stack-based virtual machine.
+	// It shares the scanner, parser and resolver with TreeWalkInterpreter and produces the same output.
+	BytecodeInterpreter struct {
+		frontend
+		vm vm.VM
+	}
+)
+
+// CompiledExt is the extension of files holding compiled bytecode
+const CompiledExt = ".loxc"
+
+var _ Interpreter = (*BytecodeInterpreter)(nil)
+
+func NewBytecodeInterpreter(config Config) *BytecodeInterpreter {
+	fe := newFrontend(config)
+	config = fe.config
+
+	return &BytecodeInterpreter{
+		frontend: fe,
+		vm: vm.NewVM(vm.Config{
+			Stdout:       config.Stdout,
+			MaxSteps:     config.MaxSteps,
+			MaxCallDepth: config.MaxCallDepth,
+			Trace:        config.Trace,
+			GC:           config.GC,
+		}),
+	}
+}
+
+// DefineNative exposes a Go function to the scripts run by this interpreter, see interpreter.Interpreter.DefineNative.
+// Lox objects other than strings are passed to fn as opaque VM objects.
+func (lox *BytecodeInterpreter) DefineNative(name string, arity int, fn interpreter.NativeFunction) {
+	lox.vm.DefineNative(name, arity, vm.NativeFunction(fn))
+}
+
+// GetGlobal reads a global variable defined by the scripts run so far
+func (lox *BytecodeInterpreter) GetGlobal(name string) (any, error) {
+	return lox.vm.GetGlobal(name)
+}
+
+// SetGlobal defines or overwrites a global variable visible to the scripts
+func (lox *BytecodeInterpreter) SetGlobal(name string, value any) error {
+	return lox.vm.SetGlobal(name, value)
+}
+
+// GCStats reports the state of the VM heap and how much work the garbage collector has done
+func (lox *BytecodeInterpreter) GCStats() vm.GCStats {
+	return lox.vm.GCStats()
+}
+
+// CollectGarbage frees every heap object the scripts can no longer reach
+func (lox *BytecodeInterpreter) CollectGarbage() {
+	lox.vm.CollectGarbage()
+}
+
+// RunFile runs the script at path, files with the .loxc extension are loaded as compiled bytecode.
+// The returned error is only set when the file can't be read or isn't valid bytecode.
+func (lox *BytecodeInterpreter) RunFile(path string) (Result, error) {
+	if filepath.Ext(path) != CompiledExt {
+		return lox.runFile(path, lox.run)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Result{}, err
+	}
+	function, err := compiler.Decode(data)
+	if err != nil {
+		return Result{}, err
+	}
+	return lox.runFunction(context.Background(), path, function), nil
+}
+
+// CompileFile compiles the script at path and writes its bytecode to out in the .loxc format
+func (lox *BytecodeInterpreter) CompileFile(path string, out io.Writer) (Result, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return Result{}, err
+	}
+
+	function, result := lox.Compile(string(source))
+	if function == nil {
+		return result, nil
+	}
+	if err = compiler.Encode(out, function); err != nil {
+		return result, err
+	}
+	result.Success = true
+	return result, nil
+}
+
+// RunPrompt runs every line read from stdin until it is exhausted.
+// Globals persist between lines and errors on one line don't stop the prompt.
+func (lox *BytecodeInterpreter) RunPrompt() error {
+	return lox.runPrompt(lox.Run)
+}
+
+// Run scans, parses, resolves, compiles and executes source.
+// Every diagnostic is written to the configured Stderr and returned in the result.
+func (lox *BytecodeInterpreter) Run(source string) Result {
+	return lox.RunContext(context.Background(), source)
+}
+
+// RunContext is like Run but stops the program with a runtime error once ctx is done
+func (lox *BytecodeInterpreter) RunContext(ctx context.Context, source string) Result {
+	return lox.run(ctx, "", source)
+}
+
+// run compiles and executes source, file is the path it was read from and names it in stack traces
+func (lox *BytecodeInterpreter) run(ctx context.Context, file string, source string) Result {
+	function, result := lox.Compile(source)
+	if function == nil {
+		return result
+	}
+
+	return lox.runFunction(ctx, file, function)
+}
+
+// RunFunction executes a function returned by Compile or loaded with compiler.Decode
+func (lox *BytecodeInterpreter) RunFunction(ctx context.Context, function *compiler.Function) Result {
+	return lox.runFunction(ctx, "", function)
+}
+
+func (lox *BytecodeInterpreter) runFunction(ctx context.Context, file string, function *compiler.Function) Result {
+	result := Result{}
+	runtimeErr := lox.vm.InterpretContext(ctx, function)
+	if runtimeErr != nil {
+		result.Diagnostics = append(result.Diagnostics, lox.RuntimeError(file, runtimeErr))
+		return result
+	}
+
+	result.Success = true
+	return result
+}
+
+// Compile runs the front end and the bytecode compiler over source without executing it,
+// the returned function is nil when source has errors
+func (lox *BytecodeInterpreter) Compile(source string) (*compiler.Function, Result) {
+	statements, _, result := lox.analyze(source)
+	if len(result.Diagnostics) > 0 {
+		return nil, result
+	}
+
+	cmplr := compiler.NewCompiler()
+	function, compilerErrs := cmplr.Compile(statements)
+	for _, compilerErr := range compilerErrs {
+		result.Diagnostics = append(result.Diagnostics, lox.CompilerError(compilerErr))
+	}
+	if len(compilerErrs) > 0 {
+		return nil, result
+	}
+
+	return function, result
+}
+
+// Disassemble compiles source and writes the bytecode of every function in it to w
+func (lox *BytecodeInterpreter) Disassemble(source string, w io.Writer) Result {
+	function, result := lox.Compile(source)
+	if function == nil {
+		return result
+	}
+
+	compiler.Disassemble(w, function)
+	result.Success = true
+	return result
+}
+
+func (lox *BytecodeInterpreter) CompilerError(err *compiler.Error) Diagnostic {
+	d := Diagnostic{
+		Kind:     CompilerDiagnostic,
+		Line:     err.Line,
+		Position: -1,
+		Where:    fmt.Sprintf(" at line %d", err.Line),
+		Message:  err.Error(),
+		Start:    -1,
+		End:      -1,
+		Err:      err,
+	}
+	if err.Span.Column != 0 {
+		d.Position = err.Span.Column
+		locate(&d, err.Source, err.Span.Start, err.Span.End)
+	}
+	lox.Report(d)
+	return d
+}
+
+// RuntimeError reports an error that stopped the program with its stack trace, file names the script in the trace
+func (lox *BytecodeInterpreter) RuntimeError(file string, err error) Diagnostic {
+	d := Diagnostic{Kind: RuntimeDiagnostic, Line: -1, Position: -1, Message: err.Error(), Start: -1, End: -1, Err: err}
+	if e, ok := err.(*vm.RuntimeError); ok {
+		d.Line = e.Line
+		d.Where = fmt.Sprintf(" at line %d", e.Line)
+		if e.Span.Column != 0 {
+			d.Position = e.Span.Column
+			locate(&d, e.Source, e.Span.Start, e.Span.End)
+		}
+		for _, frame := range e.Trace {
+			d.StackTrace = append(d.StackTrace, StackFrame{Function: frame.Function, File: file, Line: frame.Line})
+		}
+	}
+	lox.Report(d)
+	return d
+}
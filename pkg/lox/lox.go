@@ -2,109 +2,322 @@ package lox
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/ast"
 	"github.com/mtvarkovsky/golox/pkg/interpreter"
 	"github.com/mtvarkovsky/golox/pkg/parser"
+	"github.com/mtvarkovsky/golox/pkg/resolver"
 	"github.com/mtvarkovsky/golox/pkg/scanner"
 	"github.com/mtvarkovsky/golox/pkg/tokens"
+	"github.com/mtvarkovsky/golox/pkg/vm"
+	"io"
 	"os"
 )
 
 type (
 	Interpreter interface {
-		RunFile(path string)
-		RunPrompt()
-		Error(line int, message string)
+		RunFile(path string) (Result, error)
+		RunPrompt() error
+		Run(source string) Result
+		RunContext(ctx context.Context, source string) Result
+		// DefineNative, GetGlobal and SetGlobal let Go programs embed the interpreter, every backend has them
+		DefineNative(name string, arity int, fn interpreter.NativeFunction)
+		GetGlobal(name string) (any, error)
+		SetGlobal(name string, value any) error
+	}
+
+	Config struct {
+		// Stdout receives the program output and the REPL prompt, os.Stdout is used when it is nil
+		Stdout io.Writer
+		// Stderr receives scanner, parser, resolver and runtime diagnostics, os.Stderr is used when it is nil.
+		// Diagnostics are written in color when it is a terminal and the NO_COLOR environment variable isn't set.
+		Stderr io.Writer
+		// MaxSteps limits how many statements and expressions a single run may evaluate, 0 means no limit
+		MaxSteps int
+		// MaxCallDepth limits how many frames may be active at once, the top-level script included,
+		// 0 means the default of 1024 frames. BytecodeInterpreter never allows more than vm.FramesMax.
+		MaxCallDepth int
+		// CompileClosures makes TreeWalkInterpreter compile the syntax tree to Go closures before running it,
+		// see interpreter.Config.CompileClosures
+		CompileClosures bool
+		// Trace receives the execution trace of BytecodeInterpreter: the VM stack and every instruction before it runs.
+		// Nothing is traced when it is nil.
+		Trace io.Writer
+		// GC configures the garbage collector of BytecodeInterpreter
+		GC vm.GCConfig
+	}
+
+	// frontend holds what every backend shares: scanning, parsing, resolving and reporting diagnostics
+	frontend struct {
+		config Config
+		// palette colors the diagnostics written to Stderr
+		palette palette
 	}
 
 	TreeWalkInterpreter struct {
-		hadError        bool
-		hadRuntimeError bool
+		frontend
+		interpreter interpreter.Interpreter
 	}
 )
 
-func (lox *TreeWalkInterpreter) RunFile(path string) {
-	bytes, err := os.ReadFile(path)
-	if err != nil {
-		panic(err)
+var _ Interpreter = (*TreeWalkInterpreter)(nil)
+
+// ErrStepLimitExceeded and ErrCallDepthExceeded are wrapped by the Err of the diagnostic of a run that ran out of
+// MaxSteps or MaxCallDepth, whatever the backend
+var (
+	ErrStepLimitExceeded = interpreter.ErrStepLimitExceeded
+	ErrCallDepthExceeded = interpreter.ErrCallDepthExceeded
+)
+
+func newFrontend(config Config) frontend {
+	if config.Stdout == nil {
+		config.Stdout = os.Stdout
 	}
+	if config.Stderr == nil {
+		config.Stderr = os.Stderr
+	}
+	fe := frontend{config: config, palette: plain}
+	if isTerminal(config.Stderr) {
+		fe.palette = colors
+	}
+	return fe
+}
 
-	lox.Run(string(bytes))
+func NewTreeWalkInterpreter(config Config) *TreeWalkInterpreter {
+	fe := newFrontend(config)
+	config = fe.config
 
-	if lox.hadError {
-		os.Exit(65)
+	return &TreeWalkInterpreter{
+		frontend: fe,
+		interpreter: interpreter.NewInterpreter(interpreter.Config{
+			Stdout:          config.Stdout,
+			MaxSteps:        config.MaxSteps,
+			MaxCallDepth:    config.MaxCallDepth,
+			CompileClosures: config.CompileClosures,
+		}),
 	}
-	if lox.hadRuntimeError {
-		os.Exit(70)
+}
+
+// DefineNative exposes a Go function to the scripts run by this interpreter,
+// see interpreter.Interpreter.DefineNative
+func (lox *TreeWalkInterpreter) DefineNative(name string, arity int, fn interpreter.NativeFunction) {
+	lox.interpreter.DefineNative(name, arity, fn)
+}
+
+// GetGlobal reads a global variable defined by the scripts run so far
+func (lox *TreeWalkInterpreter) GetGlobal(name string) (any, error) {
+	return lox.interpreter.GetGlobal(name)
+}
+
+// SetGlobal defines or overwrites a global variable visible to the scripts
+func (lox *TreeWalkInterpreter) SetGlobal(name string, value any) error {
+	return lox.interpreter.SetGlobal(name, value)
+}
+
+// RunFile runs the script at path, the returned error is only set when the file can't be read
+func (lox *TreeWalkInterpreter) RunFile(path string) (Result, error) {
+	return lox.runFile(path, lox.run)
+}
+
+// RunPrompt runs every line read from stdin until it is exhausted.
+// Globals persist between lines and errors on one line don't stop the prompt.
+func (lox *TreeWalkInterpreter) RunPrompt() error {
+	return lox.runPrompt(lox.Run)
+}
+
+func (fe *frontend) runFile(path string, run func(ctx context.Context, file string, source string) Result) (Result, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return Result{}, err
 	}
+
+	return run(context.Background(), path, string(bytes)), nil
 }
 
-func (lox *TreeWalkInterpreter) RunPrompt() {
+func (fe *frontend) runPrompt(run func(source string) Result) error {
 	reader := bufio.NewReader(os.Stdin)
 	for {
-		fmt.Print("> ")
+		_, _ = fmt.Fprint(fe.config.Stdout, "> ")
 		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			break
+			return err
 		}
-		lox.Run(line)
-		lox.hadError = false
-		lox.hadRuntimeError = false
+		_ = run(line)
+	}
+}
+
+// Run scans, parses, resolves and interprets source.
+// Every diagnostic is written to the configured Stderr and returned in the result.
+func (lox *TreeWalkInterpreter) Run(source string) Result {
+	return lox.RunContext(context.Background(), source)
+}
+
+// RunContext is like Run but stops the program with a runtime error once ctx is done
+func (lox *TreeWalkInterpreter) RunContext(ctx context.Context, source string) Result {
+	return lox.run(ctx, "", source)
+}
+
+// run interprets source, file is the path it was read from and names it in stack traces
+func (lox *TreeWalkInterpreter) run(ctx context.Context, file string, source string) Result {
+	statements, locals, result := lox.analyze(source)
+	if len(result.Diagnostics) > 0 {
+		return result
+	}
+
+	_, runtimeErr := lox.interpreter.InterpretContext(ctx, statements, locals)
+	if runtimeErr != nil {
+		result.Diagnostics = append(result.Diagnostics, lox.RuntimeError(file, runtimeErr))
+		return result
 	}
+
+	result.Success = true
+	return result
 }
 
-func (lox *TreeWalkInterpreter) Run(source string) {
+// analyze scans, parses and resolves source, it stops after the first phase that reports errors
+func (fe *frontend) analyze(source string) ([]ast.Statement, resolver.Locals, Result) {
+	result := Result{}
+
 	scnr := scanner.NewScanner(source)
 	tokens, scannerErrs := scnr.ScanTokens()
 	for _, err := range scannerErrs {
-		lox.ScannerError(err)
-		return
+		result.Diagnostics = append(result.Diagnostics, fe.ScannerError(err))
+	}
+	if len(scannerErrs) > 0 {
+		return nil, nil, result
 	}
 
 	prsr := parser.NewParser(tokens)
 	statements, parserErrs := prsr.Parse()
 	for _, parseErr := range parserErrs {
-		lox.ParserError(parseErr)
-		return
+		result.Diagnostics = append(result.Diagnostics, fe.ParserError(parseErr))
+	}
+	if len(parserErrs) > 0 {
+		return nil, nil, result
 	}
 
-	_, runtimeErr := interpreter.Interpret(statements)
-	if runtimeErr != nil {
-		lox.RuntimeError(runtimeErr)
+	rslvr := resolver.NewResolver()
+	locals, resolverErrs := rslvr.Resolve(statements)
+	for _, resolverErr := range resolverErrs {
+		result.Diagnostics = append(result.Diagnostics, fe.ResolverError(resolverErr))
+	}
+	if len(resolverErrs) > 0 {
+		return nil, nil, result
 	}
+
+	return statements, locals, result
 }
 
-func (lox *TreeWalkInterpreter) ScannerError(err *scanner.Error) {
-	lox.Report(err.Line, err.Pos, "", err.Error())
-	lox.hadError = true
+// locate points d at the code of source from start to end, it is left without a place when source isn't known
+func locate(d *Diagnostic, source string, start int, end int) {
+	if source == "" || start < 0 || start > len(source) || end < start {
+		return
+	}
+	if end > len(source) {
+		end = len(source)
+	}
+	d.Start, d.End, d.source = start, end, source
 }
 
-func (lox *TreeWalkInterpreter) ParserError(err *parser.Error) {
+func (fe *frontend) ScannerError(err *scanner.Error) Diagnostic {
+	d := Diagnostic{
+		Kind:     ScannerDiagnostic,
+		Line:     err.Line,
+		Position: err.Pos,
+		Message:  err.Error(),
+		Start:    -1,
+		End:      -1,
+		Hint:     err.Hint,
+		Err:      err,
+	}
+	locate(&d, err.Source, err.Start, err.End)
+	fe.Report(d)
+	return d
+}
+
+func (fe *frontend) ParserError(err *parser.Error) Diagnostic {
+	d := Diagnostic{
+		Kind:     ParserDiagnostic,
+		Line:     err.Token.Line(),
+		Position: err.Token.Position(),
+		Message:  err.Error(),
+		Start:    -1,
+		End:      -1,
+		Hint:     err.Hint,
+		Err:      err,
+	}
 	if err.Token.Type() == tokens.EOF {
-		lox.Report(err.Token.Line(), err.Token.Position(), " at end", err.Error())
+		d.Where = " at end"
 	} else {
-		lox.Report(err.Token.Line(), err.Token.Position(), fmt.Sprintf(" at line %d", err.Token.Line()), err.Error())
+		d.Where = fmt.Sprintf(" at line %d", err.Token.Line())
 	}
-	lox.hadError = true
+	locate(&d, err.Token.Source(), err.Token.Start(), err.Token.End())
+	fe.Report(d)
+	return d
 }
 
-func (lox *TreeWalkInterpreter) RuntimeError(err error) {
+func (fe *frontend) ResolverError(err *resolver.Error) Diagnostic {
+	d := Diagnostic{
+		Kind:     ResolverDiagnostic,
+		Line:     err.Token.Line(),
+		Position: err.Token.Position(),
+		Where:    fmt.Sprintf(" at '%s'", err.Token.Lexeme()),
+		Message:  err.Error(),
+		Start:    -1,
+		End:      -1,
+		Err:      err,
+	}
+	locate(&d, err.Token.Source(), err.Token.Start(), err.Token.End())
+	fe.Report(d)
+	return d
+}
+
+// RuntimeError reports an error that stopped the program with its stack trace, file names the script in the trace
+func (lox *TreeWalkInterpreter) RuntimeError(file string, err error) Diagnostic {
+	d := Diagnostic{Kind: RuntimeDiagnostic, Line: -1, Position: -1, Message: err.Error(), Start: -1, End: -1, Err: err}
 	e, ok := err.(*interpreter.RuntimeError)
-	if ok {
-		if e.Token != nil {
-			lox.Report(e.Token.Line(), e.Token.Position(), fmt.Sprintf(" at line %d", e.Token.Line()), err.Error())
-		}
-		lox.Report(-1, -1, "", err.Error())
-	} else {
-		lox.Report(-1, -1, "", "unknown error")
+	if !ok {
+		d.Message = "unknown error"
+		lox.Report(d)
+		return d
+	}
+
+	if e.Token != nil {
+		d.Line = e.Token.Line()
+		d.Position = e.Token.Position()
+		d.Where = fmt.Sprintf(" at line %d", e.Token.Line())
+		locate(&d, e.Token.Source(), e.Token.Start(), e.Token.End())
+	}
+	for _, frame := range e.Trace {
+		d.StackTrace = append(d.StackTrace, StackFrame{Function: frame.Function, File: file, Line: frame.Line})
 	}
-	lox.hadError = true
-	lox.hadRuntimeError = true
+	lox.Report(d)
+	return d
 }
 
-func (lox *TreeWalkInterpreter) Report(line int, pos int, where string, message string) {
-	_, _ = fmt.Fprintln(
-		os.Stderr,
-		fmt.Sprintf("[Line %d][%d] Error %s: %s", line, pos, where, message),
-	)
+// Report writes a diagnostic to Stderr: its summary, the source line it points at with the code underlined,
+// the hint and, for runtime errors, the stack trace, one call per line.
+// Long runs of identical calls, left by deep recursion, are written once with the number of repetitions.
+func (fe *frontend) Report(d Diagnostic) {
+	p := fe.palette
+	_, _ = fmt.Fprintf(fe.config.Stderr, "%s%s%s\n%s", p.header, d, p.reset, d.snippet(p))
+	for f := 0; f < len(d.StackTrace); {
+		frame := d.StackTrace[f]
+		repeated := 0
+		for f++; f < len(d.StackTrace) && d.StackTrace[f] == frame; f++ {
+			repeated++
+		}
+		_, _ = fmt.Fprintf(fe.config.Stderr, "    at %s\n", frame)
+		if repeated > 2 {
+			_, _ = fmt.Fprintf(fe.config.Stderr, "    ... repeated %d more times\n", repeated)
+			continue
+		}
+		for ; repeated > 0; repeated-- {
+			_, _ = fmt.Fprintf(fe.config.Stderr, "    at %s\n", frame)
+		}
+	}
 }
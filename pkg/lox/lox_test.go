@@ -0,0 +1,565 @@
+package lox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/compiler"
+	"github.com/mtvarkovsky/golox/pkg/vm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const examplesDir = "../../example"
+
+// backends creates every interpreter implementation, all of them must produce the same output
+var backends = []struct {
+	name string
+	new  func(config Config) Interpreter
+}{
+	{name: "tree", new: func(config Config) Interpreter { return NewTreeWalkInterpreter(config) }},
+	{name: "closure", new: func(config Config) Interpreter {
+		config.CompileClosures = true
+		return NewTreeWalkInterpreter(config)
+	}},
+	{name: "vm", new: func(config Config) Interpreter { return NewBytecodeInterpreter(config) }},
+	{name: "vm-gc-stress", new: func(config Config) Interpreter {
+		config.GC.Stress = true
+		return NewBytecodeInterpreter(config)
+	}},
+}
+
+func TestInterpreter_Examples(t *testing.T) {
+	t.Parallel()
+
+	examples, err := filepath.Glob(filepath.Join(examplesDir, "*.lox"))
+	require.NoError(t, err)
+	require.NotEmpty(t, examples)
+
+	for _, backend := range backends {
+		for _, example := range examples {
+			backend, example := backend, example
+			name := strings.TrimSuffix(filepath.Base(example), ".lox")
+			t.Run(backend.name+"/"+name, func(t *testing.T) {
+				t.Parallel()
+
+				source, err := os.ReadFile(example)
+				require.NoError(t, err)
+				expected, err := os.ReadFile(filepath.Join("testdata", name+".out"))
+				require.NoError(t, err)
+
+				stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+				backend.new(Config{Stdout: stdout, Stderr: stderr}).Run(string(source))
+				assert.Empty(t, stderr.String())
+				assert.Equal(t, string(expected), stdout.String())
+			})
+		}
+	}
+}
+
+// TestInterpreter_Equality compares every pair of values with == and != on every backend,
+// values in the same group are equal and all the others are not
+func TestInterpreter_Equality(t *testing.T) {
+	t.Parallel()
+
+	const noGroup = -1
+	values := []struct {
+		code  string
+		group int
+	}{
+		{code: "nil", group: 0},
+		{code: "true", group: 1},
+		{code: "false", group: 2},
+		{code: "0", group: 3},
+		{code: "-0", group: 3},
+		{code: "1", group: 4},
+		{code: "0 / 0", group: noGroup},
+		{code: `""`, group: 5},
+		{code: `"1"`, group: 6},
+		{code: `"a"`, group: 7},
+		{code: `"" + "a"`, group: 7},
+		{code: `"nil"`, group: 8},
+		{code: `"<fn f>"`, group: 9},
+		{code: `"A instance"`, group: 10},
+		{code: "f", group: 11},
+		{code: "g", group: 12},
+		{code: "clock", group: 13},
+		{code: "A", group: 14},
+		{code: "B", group: 15},
+		{code: "a", group: 16},
+		{code: "b", group: 17},
+		{code: "a.m", group: noGroup},
+	}
+
+	source := &strings.Builder{}
+	expected := &strings.Builder{}
+	source.WriteString("fun f() {}\nfun g() {}\nclass A { m() {} }\nclass B < A {}\nvar a = A();\nvar b = A();\n")
+	for _, left := range values {
+		for _, right := range values {
+			equal := left.group != noGroup && left.group == right.group
+			fmt.Fprintf(source, "print (%s) == (%s);\nprint (%s) != (%s);\n", left.code, right.code, left.code, right.code)
+			fmt.Fprintf(expected, "%t\n%t\n", equal, !equal)
+		}
+	}
+
+	for _, backend := range backends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			t.Parallel()
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			backend.new(Config{Stdout: stdout, Stderr: stderr}).Run(source.String())
+			assert.Empty(t, stderr.String())
+			assert.Equal(t, expected.String(), stdout.String())
+		})
+	}
+}
+
+func TestInterpreter_DefaultCallDepth(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range backends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			t.Parallel()
+
+			// unbounded recursion is a runtime error with the zero Config, it never exhausts the Go stack
+			stderr := &bytes.Buffer{}
+			res := backend.new(Config{Stdout: &bytes.Buffer{}, Stderr: stderr}).Run("fun f(n) { return f(n + 1); }\nf(0);\n")
+			require.Len(t, res.Diagnostics, 1)
+			assert.Equal(t, RuntimeDiagnostic, res.Diagnostics[0].Kind)
+			assert.Equal(t, "maximum call depth exceeded", res.Diagnostics[0].Message)
+			assert.Contains(t, stderr.String(), "    at f (line 1)\n    ... repeated 1022 more times\n    at script (line 2)\n")
+		})
+	}
+}
+
+func TestInterpreter_Limits(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		config   Config
+		code     string
+		expected error
+		trace    int
+		// sameReport is false when the backends stop at different places, steps are nodes on one and instructions on the other
+		sameReport bool
+	}{
+		{
+			name:     "max steps",
+			config:   Config{MaxSteps: 1000},
+			code:     "while (true) {}\n",
+			expected: ErrStepLimitExceeded,
+			trace:    1,
+		},
+		{
+			name:       "max call depth",
+			config:     Config{MaxCallDepth: 5},
+			code:       "fun f(n) { return f(n + 1); }\nf(0);\n",
+			expected:   ErrCallDepthExceeded,
+			trace:      5,
+			sameReport: true,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// every backend stops with the same error and stack trace
+			reports := make([]string, 0, len(backends))
+			for _, backend := range backends {
+				config := tc.config
+				stderr := &bytes.Buffer{}
+				config.Stdout, config.Stderr = &bytes.Buffer{}, stderr
+				res := backend.new(config).Run(tc.code)
+				require.Len(t, res.Diagnostics, 1, backend.name)
+				assert.ErrorIs(t, res.Diagnostics[0].Err, tc.expected, backend.name)
+				assert.Len(t, res.Diagnostics[0].StackTrace, tc.trace, backend.name)
+				reports = append(reports, stderr.String())
+			}
+			for i := 1; tc.sameReport && i < len(reports); i++ {
+				assert.Equal(t, reports[0], reports[i], "%s and %s", backends[0].name, backends[i].name)
+			}
+		})
+	}
+
+	in := NewTreeWalkInterpreter(Config{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}, MaxCallDepth: 5})
+	assert.True(t, in.Run("fun g(n) { if (n > 0) g(n - 1); }\ng(3);\n").Success, "the script and 4 calls fit in 5 frames")
+}
+
+func TestInterpreter_Embedding(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range backends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			t.Parallel()
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			inst := backend.new(Config{Stdout: stdout, Stderr: stderr})
+			inst.DefineNative("double", 1, func(args []any) (any, error) {
+				n, ok := args[0].(float64)
+				if !ok {
+					return nil, fmt.Errorf("double expects a number")
+				}
+				return n * 2, nil
+			})
+			require.NoError(t, inst.SetGlobal("limit", 10))
+			require.NoError(t, inst.SetGlobal("name", "golox"))
+
+			res := inst.Run("var result = double(limit);\nprint name;\n")
+			require.True(t, res.Success)
+			assert.Equal(t, "golox\n", stdout.String())
+			result, err := inst.GetGlobal("result")
+			require.NoError(t, err)
+			assert.Equal(t, 20.0, result)
+			_, err = inst.GetGlobal("missing")
+			assert.EqualError(t, err, "undefined variable 'missing'")
+
+			res = inst.Run("double(name);\n")
+			require.Len(t, res.Diagnostics, 1)
+			assert.Equal(t, "double expects a number", res.Diagnostics[0].Message)
+			assert.Equal(t, 1, res.Diagnostics[0].Line)
+		})
+	}
+}
+
+func TestTreeWalkInterpreter_Diagnostics(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		code   string
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "parser error",
+			code:   "print (1;\n",
+			stderr: "[Line 1][9] Error  at line 1: Expect ')' after expression.\n 1 | print (1;\n   |         ^\n",
+		},
+		{
+			name:   "resolver error",
+			code:   "return;\n",
+			stderr: "[Line 1][1] Error  at 'return': Can't return from top-level code.\n 1 | return;\n   | ^^^^^^\n",
+		},
+		{
+			name:   "output before runtime error",
+			code:   "print \"before\";\nprint -\"a\";\n",
+			stdout: "before\n",
+			stderr: "[Line 2][7] Error  at line 2: operand must be a number\n 2 | print -\"a\";\n   |       ^\n    at script (line 2)\n",
+		},
+		{
+			name:   "stack trace",
+			code:   "fun f(n) {\nif (n == 0) return nil + 1;\nreturn f(n - 1);\n}\nf(4);\n",
+			stderr: "[Line 2][24] Error  at line 2: operands must be both numbers or both strings\n 2 | if (n == 0) return nil + 1;\n   |                        ^\n    at f (line 2)\n    at f (line 3)\n    ... repeated 3 more times\n    at script (line 5)\n",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			NewTreeWalkInterpreter(Config{Stdout: stdout, Stderr: stderr}).Run(tc.code)
+			assert.Equal(t, tc.stdout, stdout.String())
+			assert.Equal(t, tc.stderr, stderr.String())
+		})
+	}
+}
+
+func TestTreeWalkInterpreter_Result(t *testing.T) {
+	t.Parallel()
+
+	inst := NewTreeWalkInterpreter(Config{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}})
+
+	res := inst.Run("var a = 1;\n")
+	assert.True(t, res.Success)
+	assert.Empty(t, res.Diagnostics)
+
+	res = inst.Run("var b = @;\nvar c = #;\n")
+	assert.False(t, res.Success)
+	assert.True(t, res.HadError())
+	assert.False(t, res.HadRuntimeError())
+	require.Len(t, res.Diagnostics, 2)
+	assert.Equal(t, ScannerDiagnostic, res.Diagnostics[0].Kind)
+	assert.Equal(t, 1, res.Diagnostics[0].Line)
+	assert.Equal(t, 2, res.Diagnostics[1].Line)
+
+	res = inst.Run("print a + nil;\n")
+	assert.False(t, res.Success)
+	assert.False(t, res.HadError())
+	assert.True(t, res.HadRuntimeError())
+	require.Len(t, res.Diagnostics, 1)
+	assert.Equal(t, RuntimeDiagnostic, res.Diagnostics[0].Kind)
+	assert.Equal(t, "operands must be both numbers or both strings", res.Diagnostics[0].Message)
+	assert.Equal(t, 1, res.Diagnostics[0].Line)
+
+	_, err := inst.RunFile("does-not-exist.lox")
+	assert.Error(t, err)
+}
+
+func TestBytecodeInterpreter_Diagnostics(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		code   string
+		kind   DiagnosticKind
+		line   int
+		stdout string
+		stderr string
+	}{
+		{
+			name:   "parser error",
+			code:   "print (1;\n",
+			kind:   ParserDiagnostic,
+			line:   1,
+			stderr: "[Line 1][9] Error  at line 1: Expect ')' after expression.\n 1 | print (1;\n   |         ^\n",
+		},
+		{
+			name:   "compiler error",
+			code:   tooManyLocals(),
+			kind:   CompilerDiagnostic,
+			line:   257,
+			stderr: "[Line 257][5] Error  at line 257: Too many local variables in function.\n 257 | var a255;\n     |     ^^^^\n",
+		},
+		{
+			name:   "output before runtime error",
+			code:   "print \"before\";\nprint -\"a\";\n",
+			kind:   RuntimeDiagnostic,
+			line:   2,
+			stdout: "before\n",
+			stderr: "[Line 2][7] Error  at line 2: operand must be a number\n 2 | print -\"a\";\n   |       ^\n    at script (line 2)\n",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			res := NewBytecodeInterpreter(Config{Stdout: stdout, Stderr: stderr}).Run(tc.code)
+			require.Len(t, res.Diagnostics, 1)
+			assert.Equal(t, tc.kind, res.Diagnostics[0].Kind)
+			assert.Equal(t, tc.line, res.Diagnostics[0].Line)
+			assert.Equal(t, tc.stdout, stdout.String())
+			assert.Equal(t, tc.stderr, stderr.String())
+		})
+	}
+}
+
+func TestInterpreter_StackTrace(t *testing.T) {
+	t.Parallel()
+
+	script := filepath.Join(t.TempDir(), "trace.lox")
+	require.NoError(t, os.WriteFile(script, []byte("fun f() {\n  g();\n}\nfun g() {\n  throw \"boom\";\n}\nf();\n"), 0o644))
+	expected := []StackFrame{
+		{Function: "g", File: script, Line: 5},
+		{Function: "f", File: script, Line: 2},
+		{Function: "script", File: script, Line: 7},
+	}
+
+	for _, backend := range backends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			t.Parallel()
+
+			stderr := &bytes.Buffer{}
+			res, err := backend.new(Config{Stdout: &bytes.Buffer{}, Stderr: stderr}).RunFile(script)
+			require.NoError(t, err)
+			require.Len(t, res.Diagnostics, 1)
+			assert.Equal(t, "boom", res.Diagnostics[0].Message)
+			assert.Equal(t, expected, res.Diagnostics[0].StackTrace)
+			assert.Contains(t, stderr.String(), "\n    at g ("+script+":5)\n    at f ("+script+":2)\n    at script ("+script+":7)\n")
+		})
+	}
+}
+
+func tooManyLocals() string {
+	code := strings.Builder{}
+	code.WriteString("{\n")
+	for i := 0; i < compiler.MaxLocals; i++ {
+		code.WriteString(fmt.Sprintf("var a%d;\n", i))
+	}
+	code.WriteString("}\n")
+	return code.String()
+}
+
+func TestBytecodeInterpreter_CompiledFile(t *testing.T) {
+	t.Parallel()
+
+	script := filepath.Join(examplesDir, "closures.lox")
+	compiled := filepath.Join(t.TempDir(), "closures"+CompiledExt)
+	expected, err := os.ReadFile(filepath.Join("testdata", "closures.out"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	res, err := NewBytecodeInterpreter(Config{}).CompileFile(script, buf)
+	require.NoError(t, err)
+	require.True(t, res.Success)
+	require.NoError(t, os.WriteFile(compiled, buf.Bytes(), 0o644))
+
+	stdout := &bytes.Buffer{}
+	res, err = NewBytecodeInterpreter(Config{Stdout: stdout}).RunFile(compiled)
+	require.NoError(t, err)
+	assert.True(t, res.Success)
+	assert.Equal(t, string(expected), stdout.String())
+
+	require.NoError(t, os.WriteFile(compiled, buf.Bytes()[:buf.Len()-1], 0o644))
+	_, err = NewBytecodeInterpreter(Config{}).RunFile(compiled)
+	assert.ErrorIs(t, err, compiler.ErrInvalidBytecode)
+}
+
+func TestBytecodeInterpreter_GCStats(t *testing.T) {
+	t.Parallel()
+
+	inst := NewBytecodeInterpreter(Config{Stdout: &bytes.Buffer{}, GC: vm.GCConfig{InitialHeap: 1024}})
+	res := inst.Run("class A {}\nvar keep = A();\nfor (var i = 0; i < 1000; i = i + 1) { var a = A(); a.x = i; }\n")
+	require.True(t, res.Success)
+
+	stats := inst.GCStats()
+	assert.Greater(t, stats.Collections, 0)
+	assert.Greater(t, stats.ObjectsFreed, 900)
+
+	inst.CollectGarbage()
+	stats = inst.GCStats()
+	assert.Equal(t, stats.ObjectsAllocated-stats.ObjectsFreed, stats.HeapObjects)
+	res = inst.Run("print keep;\n")
+	assert.True(t, res.Success)
+}
+
+func TestDiagnostic_Snippet(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		d        Diagnostic
+		expected string
+	}{
+		{
+			name:     "token",
+			d:        Diagnostic{Start: 21, End: 24, source: "var a = 1;\nvar b = a.bad;\n"},
+			expected: " 2 | var b = a.bad;\n   |           ^^^\n",
+		},
+		{
+			name:     "tabs are kept",
+			d:        Diagnostic{Start: 9, End: 10, source: "{\n\tprint -a;\n}\n"},
+			expected: " 2 | \tprint -a;\n   | \t      ^\n",
+		},
+		{
+			name:     "span past the end of the line",
+			d:        Diagnostic{Start: 6, End: 17, source: "print \"one\ntwo\";\n"},
+			expected: " 1 | print \"one\n   |       ^^^^\n",
+		},
+		{
+			name:     "empty span",
+			d:        Diagnostic{Start: 9, End: 9, source: "print 1 +\n"},
+			expected: " 1 | print 1 +\n   |          ^\n",
+		},
+		{
+			name:     "characters wider than a byte",
+			d:        Diagnostic{Start: 14, End: 15, source: "print \"héé\" -;\n"},
+			expected: " 1 | print \"héé\" -;\n   |             ^\n",
+		},
+		{
+			name:     "hint",
+			d:        Diagnostic{Start: 10, End: 15, Hint: "add ';' at the end of line 1", source: "var a = 1\nprint a;\n"},
+			expected: " 2 | print a;\n   | ^^^^^\n   = hint: add ';' at the end of line 1\n",
+		},
+		{
+			name:     "unknown place",
+			d:        Diagnostic{Start: -1, End: -1, Hint: "check the loop"},
+			expected: "  = hint: check the loop\n",
+		},
+		{
+			name: "nothing to show",
+			d:    Diagnostic{Start: -1, End: -1},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, tc.d.Snippet())
+		})
+	}
+}
+
+func TestInterpreter_DiagnosticSpans(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range backends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			t.Parallel()
+
+			stderr := &bytes.Buffer{}
+			inst := backend.new(Config{Stdout: &bytes.Buffer{}, Stderr: stderr})
+			require.True(t, inst.Run("fun half(x) {\n  return x / 2;\n}\n").Success)
+
+			// the error is in a function defined by an earlier run, the snippet comes from that run's source
+			res := inst.Run("print half(\"four\");\n")
+			require.Len(t, res.Diagnostics, 1)
+			d := res.Diagnostics[0]
+			assert.Equal(t, 2, d.Line)
+			assert.Equal(t, 12, d.Position)
+			assert.Equal(t, 25, d.Start)
+			assert.Equal(t, 26, d.End)
+			assert.Equal(t, " 2 |   return x / 2;\n   |            ^\n", d.Snippet())
+			assert.Contains(t, stderr.String(), d.Snippet())
+		})
+	}
+}
+
+func TestBytecodeInterpreter_CompiledFileSpans(t *testing.T) {
+	t.Parallel()
+
+	// a program run before the compiled one must not lend it its source
+	inst := NewBytecodeInterpreter(Config{Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}})
+	require.True(t, inst.Run("var a = 1;\nvar b = 2;\n").Success)
+
+	buf := &bytes.Buffer{}
+	function, res := inst.Compile("print 1;\nprint -\"two\";\n")
+	require.NotNil(t, function)
+	require.Empty(t, res.Diagnostics)
+	require.NoError(t, compiler.Encode(buf, function))
+	decoded, err := compiler.Decode(buf.Bytes())
+	require.NoError(t, err)
+
+	res = inst.RunFunction(context.Background(), decoded)
+	require.Len(t, res.Diagnostics, 1)
+	d := res.Diagnostics[0]
+	assert.Equal(t, 2, d.Line)
+	assert.Equal(t, 7, d.Position)
+	assert.Equal(t, -1, d.Start)
+	assert.Equal(t, -1, d.End)
+	assert.Empty(t, d.Snippet())
+}
+
+func TestFrontend_ReportInColor(t *testing.T) {
+	t.Parallel()
+
+	stderr := &bytes.Buffer{}
+	inst := NewTreeWalkInterpreter(Config{Stdout: &bytes.Buffer{}, Stderr: stderr})
+	inst.palette = colors
+	inst.Run("var a = 1\nprint a;\n")
+
+	expected := "\x1b[1;31m[Line 2][1] Error  at line 2: Expect ';' after variable declaration.\x1b[0m\n" +
+		"\x1b[1;34m 2 |\x1b[0m print a;\n" +
+		"\x1b[1;34m   |\x1b[0m \x1b[1;31m^^^^^\x1b[0m\n" +
+		"\x1b[1;34m   =\x1b[0m \x1b[1;36mhint:\x1b[0m add ';' at the end of line 1\n"
+	assert.Equal(t, expected, stderr.String())
+	assert.False(t, isTerminal(stderr), "diagnostics written to a buffer are never colored")
+}
@@ -0,0 +1,99 @@
+package lox
+
+import "fmt"
+
+type (
+	DiagnosticKind int
+
+	// Diagnostic describes a single error found while scanning, parsing, resolving, compiling or running a program
+	Diagnostic struct {
+		Kind     DiagnosticKind
+		Line     int
+		Position int
+		Where    string
+		Message  string
+		// Start and End are the byte offsets of the code the diagnostic is about in its source, End excluded,
+		// both are -1 when the place isn't known
+		Start int
+		End   int
+		// Hint suggests how to fix the error, it is empty when there is no suggestion
+		Hint string
+		// Err is the original error: *scanner.Error, *parser.Error, *resolver.Error, *compiler.Error,
+		// *interpreter.RuntimeError or *vm.RuntimeError
+		Err error
+		// StackTrace lists the function calls that were active when a runtime error stopped the program, innermost first
+		StackTrace []StackFrame
+		// source is the program Start and End refer to
+		source string
+	}
+
+	// StackFrame is a function call that was active when a runtime error stopped the program
+	StackFrame struct {
+		// Function is the name of the called function, "script" for the top-level code
+		Function string
+		// File is the path of the script passed to RunFile, it is empty for the sources passed to Run
+		File string
+		// Line is the line the function was executing, -1 when it isn't known
+		Line int
+	}
+
+	// Result is the outcome of running a program
+	Result struct {
+		Diagnostics []Diagnostic
+		Success     bool
+	}
+)
+
+const (
+	ScannerDiagnostic DiagnosticKind = iota
+	ParserDiagnostic
+	ResolverDiagnostic
+	CompilerDiagnostic
+	RuntimeDiagnostic
+)
+
+func (dk DiagnosticKind) String() string {
+	return [...]string{
+		"SCANNER",
+		"PARSER",
+		"RESOLVER",
+		"COMPILER",
+		"RUNTIME",
+	}[dk]
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("[Line %d][%d] Error %s: %s", d.Line, d.Position, d.Where, d.Message)
+}
+
+func (f StackFrame) String() string {
+	switch {
+	case f.File != "" && f.Line >= 0:
+		return fmt.Sprintf("%s (%s:%d)", f.Function, f.File, f.Line)
+	case f.File != "":
+		return fmt.Sprintf("%s (%s)", f.Function, f.File)
+	case f.Line >= 0:
+		return fmt.Sprintf("%s (line %d)", f.Function, f.Line)
+	}
+	return f.Function
+}
+
+// HadError reports whether the program was rejected before it started running
+func (r Result) HadError() bool {
+	for _, d := range r.Diagnostics {
+		if d.Kind != RuntimeDiagnostic {
+			return true
+		}
+	}
+	return false
+}
+
+// HadRuntimeError reports whether the program stopped because of a runtime error
+func (r Result) HadRuntimeError() bool {
+	for _, d := range r.Diagnostics {
+		if d.Kind == RuntimeDiagnostic {
+			return true
+		}
+	}
+	return false
+}
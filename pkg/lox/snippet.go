@@ -0,0 +1,89 @@
+package lox
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// palette holds the escape sequences that color the parts of a diagnostic, they are all empty for plain text
+	palette struct {
+		header string
+		gutter string
+		caret  string
+		hint   string
+		reset  string
+	}
+)
+
+var (
+	plain  = palette{}
+	colors = palette{
+		header: "\x1b[1;31m",
+		gutter: "\x1b[1;34m",
+		caret:  "\x1b[1;31m",
+		hint:   "\x1b[1;36m",
+		reset:  "\x1b[0m",
+	}
+)
+
+// Snippet returns the source line a diagnostic is about with the code it points at underlined by carets,
+// followed by the hint. The line is left out when the place of the diagnostic isn't known.
+func (d Diagnostic) Snippet() string {
+	return d.snippet(plain)
+}
+
+func (d Diagnostic) snippet(p palette) string {
+	sb := strings.Builder{}
+	gutter := ""
+	if d.Start >= 0 && d.Start <= len(d.source) {
+		lineStart := strings.LastIndexByte(d.source[:d.Start], '\n') + 1
+		lineEnd := len(d.source)
+		if n := strings.IndexByte(d.source[d.Start:], '\n'); n >= 0 {
+			lineEnd = d.Start + n
+		}
+		end := d.End
+		if end > lineEnd {
+			// only the first line of a span that goes on is underlined
+			end = lineEnd
+		}
+		line := fmt.Sprint(strings.Count(d.source[:lineStart], "\n") + 1)
+		gutter = strings.Repeat(" ", len(line))
+
+		// tabs are kept, so the carets line up with the code however wide the terminal shows them
+		indent := strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return r
+			}
+			return ' '
+		}, d.source[lineStart:d.Start])
+		width := 1
+		if end > d.Start {
+			width = utf8.RuneCountInString(d.source[d.Start:end])
+		}
+
+		_, _ = fmt.Fprintf(&sb, "%s %s |%s %s\n", p.gutter, line, p.reset, strings.TrimSuffix(d.source[lineStart:lineEnd], "\r"))
+		_, _ = fmt.Fprintf(&sb, "%s %s |%s %s%s%s%s\n", p.gutter, gutter, p.reset, indent, p.caret, strings.Repeat("^", width), p.reset)
+	}
+	if d.Hint != "" {
+		_, _ = fmt.Fprintf(&sb, "%s %s =%s %shint:%s %s\n", p.gutter, gutter, p.reset, p.hint, p.reset, d.Hint)
+	}
+	return sb.String()
+}
+
+// isTerminal reports whether diagnostics written to w are read by a person, who gets them in color.
+// Setting the NO_COLOR environment variable turns colors off.
+func isTerminal(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
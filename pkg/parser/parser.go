@@ -15,10 +15,16 @@ import (
 // term           -> factor ( ( "-" | "+" ) factor )* ;
 // factor         -> unary ( ( "/" | "*" ) unary )* ;
 // unary          -> ( "!" | "-" ) unary )
-//                 | primary ;
+//                 | call ;
+// call           -> primary ( "(" arguments? ")" | "." IDENTIFIER | "[" expression "]" )* ;
+// arguments      -> expression ( "," expression )* ;
 //
-// primary        -> number | string | "true" | "false" | "nil"
-//                 | "(" expression ")" ;
+// primary        -> number | string | "true" | "false" | "nil" | "this"
+//                 | IDENTIFIER | "(" expression ")" | "[" arguments? "]"
+//                 | "{" entries? "}" | "super" "." IDENTIFIER ;
+// entries        -> expression ":" expression ( "," expression ":" expression )* ;
+//
+// A "{" that starts a statement always opens a block, map literals only appear where an expression is expected.
 //
 // -----------------------------------------------------------------
 
@@ -34,20 +40,30 @@ type (
 
 	Error struct {
 		Token tokens.Token
-		err   error
+		// Hint suggests how to fix the error, it is empty when there is nothing to suggest
+		Hint string
+		err  error
 	}
 )
 
+const (
+	MaxArguments = 255
+)
+
 var (
 	StopSyncTokensSet = map[tokens.TokenType]bool{
-		tokens.Class:  true,
-		tokens.Fun:    true,
-		tokens.Var:    true,
-		tokens.For:    true,
-		tokens.If:     true,
-		tokens.While:  true,
-		tokens.Print:  true,
-		tokens.Return: true,
+		tokens.Class:    true,
+		tokens.Fun:      true,
+		tokens.Var:      true,
+		tokens.For:      true,
+		tokens.If:       true,
+		tokens.While:    true,
+		tokens.Print:    true,
+		tokens.Return:   true,
+		tokens.Break:    true,
+		tokens.Continue: true,
+		tokens.Try:      true,
+		tokens.Throw:    true,
 	}
 )
 
@@ -75,6 +91,22 @@ func (p *parser) Parse() ([]ast.Statement, []*Error) {
 func (p *parser) declaration() (ast.Statement, *Error) {
 	var statement ast.Statement
 	var err *Error
+	if p.match(tokens.Class) {
+		statement, err = p.classDeclaration()
+		if err != nil {
+			p.synchronize()
+			return nil, err
+		}
+		return statement, nil
+	}
+	if p.match(tokens.Fun) {
+		statement, err = p.function("function")
+		if err != nil {
+			p.synchronize()
+			return nil, err
+		}
+		return statement, nil
+	}
 	if p.match(tokens.Var) {
 		statement, err = p.varDeclaration()
 		if err != nil {
@@ -91,6 +123,87 @@ func (p *parser) declaration() (ast.Statement, *Error) {
 	return statement, nil
 }
 
+func (p *parser) classDeclaration() (ast.Statement, *Error) {
+	name, err := p.consume(tokens.Identifier, "Expect class name.")
+	if err != nil {
+		return nil, err
+	}
+
+	var superclass ast.Variable
+	if p.match(tokens.Less) {
+		superclassName, e := p.consume(tokens.Identifier, "Expect superclass name.")
+		if e != nil {
+			return nil, e
+		}
+		superclass = ast.NewVariable(superclassName)
+	}
+
+	_, err = p.consume(tokens.LeftBrace, "Expect '{' before class body.")
+	if err != nil {
+		return nil, err
+	}
+
+	var methods []ast.FunctionStatement
+	for !p.check(tokens.RightBrace) && !p.isAtEnd() {
+		method, e := p.function("method")
+		if e != nil {
+			return nil, e
+		}
+		methods = append(methods, method)
+	}
+
+	_, err = p.consume(tokens.RightBrace, "Expect '}' after class body.")
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewClassStatement(name, superclass, methods), nil
+}
+
+func (p *parser) function(kind string) (ast.FunctionStatement, *Error) {
+	name, err := p.consume(tokens.Identifier, fmt.Sprintf("Expect %s name.", kind))
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.consume(tokens.LeftParen, fmt.Sprintf("Expect '(' after %s name.", kind))
+	if err != nil {
+		return nil, err
+	}
+
+	var params []tokens.Token
+	if !p.check(tokens.RightParen) {
+		for {
+			if len(params) >= MaxArguments {
+				return nil, &Error{
+					Token: p.peek(),
+					err:   fmt.Errorf("Can't have more than %d parameters.", MaxArguments),
+				}
+			}
+			param, e := p.consume(tokens.Identifier, "Expect parameter name.")
+			if e != nil {
+				return nil, e
+			}
+			params = append(params, param)
+			if !p.match(tokens.Comma) {
+				break
+			}
+		}
+	}
+	_, err = p.consume(tokens.RightParen, "Expect ')' after parameters.")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.consume(tokens.LeftBrace, fmt.Sprintf("Expect '{' before %s body.", kind))
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.blockStatement()
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewFunctionStatement(name, params, body), nil
+}
+
 func (p *parser) varDeclaration() (ast.Statement, *Error) {
 	name, err := p.consume(tokens.Identifier, "Expect variable name.")
 	if err != nil {
@@ -122,6 +235,21 @@ func (p *parser) statement() (ast.Statement, *Error) {
 	if p.match(tokens.Print) {
 		return p.printStatement()
 	}
+	if p.match(tokens.Return) {
+		return p.returnStatement()
+	}
+	if p.match(tokens.Break) {
+		return p.breakStatement()
+	}
+	if p.match(tokens.Continue) {
+		return p.continueStatement()
+	}
+	if p.match(tokens.Throw) {
+		return p.throwStatement()
+	}
+	if p.match(tokens.Try) {
+		return p.tryStatement()
+	}
 	if p.match(tokens.While) {
 		return p.whileStatement()
 	}
@@ -137,10 +265,18 @@ func (p *parser) statement() (ast.Statement, *Error) {
 }
 
 func (p *parser) forStatement() (ast.Statement, *Error) {
+	keyword := p.previous()
 	_, err := p.consume(tokens.LeftParen, "Expect '(' after 'for'.")
 	if err != nil {
 		return nil, err
 	}
+	// the loop variable of a for-in loop may be declared with or without var,
+	// in isn't a keyword so it can still name variables, it only starts the iterable after a loop variable
+	if p.checkAhead(0, tokens.Identifier) && p.checkWordAhead(1, "in") ||
+		p.checkAhead(0, tokens.Var) && p.checkAhead(1, tokens.Identifier) && p.checkWordAhead(2, "in") {
+		return p.forInStatement(keyword)
+	}
+
 	var initializer ast.Statement
 	if p.match(tokens.Semicolon) {
 		initializer = nil
@@ -185,19 +321,11 @@ func (p *parser) forStatement() (ast.Statement, *Error) {
 		return nil, err
 	}
 
-	if increment != nil {
-		body = ast.NewBlockStatement(
-			[]ast.Statement{
-				body,
-				ast.NewExpressionStatement(increment),
-			},
-		)
-	}
-
 	if condition == nil {
 		condition = ast.NewLiteral(true)
 	}
-	body = ast.NewWhileStatement(condition, body)
+	// the increment stays out of the body so a continue statement in the body still runs it
+	body = ast.NewWhileStatement(condition, body, increment)
 
 	if initializer != nil {
 		body = ast.NewBlockStatement(
@@ -211,6 +339,29 @@ func (p *parser) forStatement() (ast.Statement, *Error) {
 	return body, nil
 }
 
+// forInStatement parses the rest of a for-in loop after its opening parenthesis.
+// Unlike the C-style for loop it isn't desugared, the interpreters run it with the iterator protocol.
+func (p *parser) forInStatement(keyword tokens.Token) (ast.Statement, *Error) {
+	p.match(tokens.Var)
+	name := p.advance()
+	_ = p.advance()
+
+	iterable, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = p.consume(tokens.RightParen, "Expect ')' after for-in clause."); err != nil {
+		return nil, err
+	}
+
+	body, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+
+	return ast.NewForInStatement(keyword, name, iterable, body), nil
+}
+
 func (p *parser) whileStatement() (ast.Statement, *Error) {
 	_, err := p.consume(tokens.LeftParen, "Expect '(' after 'while'.")
 	if err != nil {
@@ -228,7 +379,7 @@ func (p *parser) whileStatement() (ast.Statement, *Error) {
 	if err != nil {
 		return nil, err
 	}
-	return ast.NewWhileStatement(condition, body), nil
+	return ast.NewWhileStatement(condition, body, nil), nil
 }
 
 func (p *parser) ifStatement() (ast.Statement, *Error) {
@@ -287,6 +438,105 @@ func (p *parser) printStatement() (ast.Statement, *Error) {
 	return ast.NewPrintStatement(val), nil
 }
 
+func (p *parser) returnStatement() (ast.Statement, *Error) {
+	keyword := p.previous()
+	var value ast.Expression
+	var err *Error
+	if !p.check(tokens.Semicolon) {
+		value, err = p.expression()
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = p.consume(tokens.Semicolon, "Expect ';' after return value.")
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewReturnStatement(keyword, value), nil
+}
+
+func (p *parser) breakStatement() (ast.Statement, *Error) {
+	keyword := p.previous()
+	_, err := p.consume(tokens.Semicolon, "Expect ';' after 'break'.")
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewBreakStatement(keyword), nil
+}
+
+func (p *parser) continueStatement() (ast.Statement, *Error) {
+	keyword := p.previous()
+	_, err := p.consume(tokens.Semicolon, "Expect ';' after 'continue'.")
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewContinueStatement(keyword), nil
+}
+
+func (p *parser) throwStatement() (ast.Statement, *Error) {
+	keyword := p.previous()
+	value, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.consume(tokens.Semicolon, "Expect ';' after thrown value.")
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewThrowStatement(keyword, value), nil
+}
+
+// tryStatement parses a try block followed by a catch clause, a finally block or both,
+// the name of the catch variable is nil when there is no catch clause
+func (p *parser) tryStatement() (ast.Statement, *Error) {
+	keyword := p.previous()
+	_, err := p.consume(tokens.LeftBrace, "Expect '{' after 'try'.")
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.blockStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	var name tokens.Token
+	var catchBody []ast.Statement
+	if p.match(tokens.Catch) {
+		if _, err = p.consume(tokens.LeftParen, "Expect '(' after 'catch'."); err != nil {
+			return nil, err
+		}
+		if name, err = p.consume(tokens.Identifier, "Expect catch variable name."); err != nil {
+			return nil, err
+		}
+		if _, err = p.consume(tokens.RightParen, "Expect ')' after catch variable."); err != nil {
+			return nil, err
+		}
+		if _, err = p.consume(tokens.LeftBrace, "Expect '{' before catch body."); err != nil {
+			return nil, err
+		}
+		if catchBody, err = p.blockStatement(); err != nil {
+			return nil, err
+		}
+	}
+
+	var finallyBody []ast.Statement
+	if p.match(tokens.Finally) {
+		if _, err = p.consume(tokens.LeftBrace, "Expect '{' after 'finally'."); err != nil {
+			return nil, err
+		}
+		if finallyBody, err = p.blockStatement(); err != nil {
+			return nil, err
+		}
+	} else if name == nil {
+		return nil, &Error{
+			Token: p.peek(),
+			err:   fmt.Errorf("Expect 'catch' or 'finally' after try block."),
+		}
+	}
+
+	return ast.NewTryStatement(keyword, body, name, catchBody, finallyBody), nil
+}
+
 func (p *parser) expressionStatement() (ast.Statement, *Error) {
 	expression, err := p.expression()
 	if err != nil {
@@ -316,10 +566,16 @@ func (p *parser) assignment() (ast.Expression, *Error) {
 			return nil, e
 		}
 
-		switch expr := expression.(type) {
-		case ast.Variable:
-			name := expr.Name()
+		switch expression.Type() {
+		case ast.VariableExpressionType:
+			name := expression.(ast.Variable).Name()
 			return ast.NewAssignment(name, value), nil
+		case ast.GetExpressionType:
+			get := expression.(ast.Get)
+			return ast.NewSet(get.Object(), get.Name(), value), nil
+		case ast.SubscriptExpressionType:
+			subscript := expression.(ast.Subscript)
+			return ast.NewSubscriptSet(subscript.Object(), subscript.Bracket(), subscript.Index(), value), nil
 		}
 
 		return nil, &Error{
@@ -445,7 +701,128 @@ func (p *parser) unary() (ast.Expression, *Error) {
 		return ast.NewUnary(operator, right), nil
 	}
 
-	return p.primary()
+	return p.call()
+}
+
+func (p *parser) call() (ast.Expression, *Error) {
+	expression, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if p.match(tokens.LeftParen) {
+			expression, err = p.finishCall(expression)
+			if err != nil {
+				return nil, err
+			}
+		} else if p.match(tokens.Dot) {
+			name, e := p.consume(tokens.Identifier, "Expect property name after '.'.")
+			if e != nil {
+				return nil, e
+			}
+			expression = ast.NewGet(expression, name)
+		} else if p.match(tokens.LeftBracket) {
+			index, e := p.expression()
+			if e != nil {
+				return nil, e
+			}
+			bracket, e := p.consume(tokens.RightBracket, "Expect ']' after index.")
+			if e != nil {
+				return nil, e
+			}
+			expression = ast.NewSubscript(expression, bracket, index)
+		} else {
+			break
+		}
+	}
+
+	return expression, nil
+}
+
+func (p *parser) finishCall(callee ast.Expression) (ast.Expression, *Error) {
+	var arguments []ast.Expression
+	if !p.check(tokens.RightParen) {
+		for {
+			if len(arguments) >= MaxArguments {
+				return nil, &Error{
+					Token: p.peek(),
+					err:   fmt.Errorf("Can't have more than %d arguments.", MaxArguments),
+				}
+			}
+			argument, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			arguments = append(arguments, argument)
+			if !p.match(tokens.Comma) {
+				break
+			}
+		}
+	}
+
+	paren, err := p.consume(tokens.RightParen, "Expect ')' after arguments.")
+	if err != nil {
+		return nil, err
+	}
+
+	return ast.NewCall(callee, paren, arguments), nil
+}
+
+// list parses the elements of a list literal after its opening bracket
+func (p *parser) list() (ast.Expression, *Error) {
+	bracket := p.previous()
+	var elements []ast.Expression
+	if !p.check(tokens.RightBracket) {
+		for {
+			element, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, element)
+			if !p.match(tokens.Comma) {
+				break
+			}
+		}
+	}
+
+	if _, err := p.consume(tokens.RightBracket, "Expect ']' after list elements."); err != nil {
+		return nil, err
+	}
+
+	return ast.NewList(bracket, elements), nil
+}
+
+// mapLiteral parses the entries of a map literal after its opening brace
+func (p *parser) mapLiteral() (ast.Expression, *Error) {
+	brace := p.previous()
+	var keys, values []ast.Expression
+	if !p.check(tokens.RightBrace) {
+		for {
+			key, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if _, err = p.consume(tokens.Colon, "Expect ':' after map key."); err != nil {
+				return nil, err
+			}
+			value, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+			if !p.match(tokens.Comma) {
+				break
+			}
+		}
+	}
+
+	if _, err := p.consume(tokens.RightBrace, "Expect '}' after map entries."); err != nil {
+		return nil, err
+	}
+
+	return ast.NewMapLiteral(brace, keys, values), nil
 }
 
 func (p *parser) primary() (ast.Expression, *Error) {
@@ -461,6 +838,20 @@ func (p *parser) primary() (ast.Expression, *Error) {
 	if p.match(tokens.Number, tokens.String) {
 		return ast.NewLiteral(p.previous().Literal()), nil
 	}
+	if p.match(tokens.Super) {
+		keyword := p.previous()
+		if _, err := p.consume(tokens.Dot, "Expect '.' after 'super'."); err != nil {
+			return nil, err
+		}
+		method, err := p.consume(tokens.Identifier, "Expect superclass method name.")
+		if err != nil {
+			return nil, err
+		}
+		return ast.NewSuper(keyword, method), nil
+	}
+	if p.match(tokens.This) {
+		return ast.NewThis(p.previous()), nil
+	}
 	if p.match(tokens.Identifier) {
 		return ast.NewVariable(p.previous()), nil
 	}
@@ -474,6 +865,12 @@ func (p *parser) primary() (ast.Expression, *Error) {
 		}
 		return ast.NewGrouping(expression), nil
 	}
+	if p.match(tokens.LeftBracket) {
+		return p.list()
+	}
+	if p.match(tokens.LeftBrace) {
+		return p.mapLiteral()
+	}
 
 	if !p.isAtEnd() {
 		return nil, &Error{
@@ -500,10 +897,15 @@ func (p *parser) consume(tokenType tokens.TokenType, message string) (tokens.Tok
 		return p.advance(), nil
 	}
 
-	return nil, &Error{
+	err := &Error{
 		Token: p.peek(),
 		err:   fmt.Errorf(message),
 	}
+	// the error is reported at the next token, which is on another line when the statement ends without a semicolon
+	if tokenType == tokens.Semicolon && p.currentPos > 0 && p.peek().Line() > p.previous().EndLine() {
+		err.Hint = fmt.Sprintf("add ';' at the end of line %d", p.previous().EndLine())
+	}
+	return nil, err
 }
 
 func (p *parser) synchronize() {
@@ -517,9 +919,9 @@ func (p *parser) synchronize() {
 		if _, found := StopSyncTokensSet[p.peek().Type()]; found {
 			return
 		}
-	}
 
-	_ = p.advance()
+		_ = p.advance()
+	}
 }
 
 func (p *parser) check(tokenType tokens.TokenType) bool {
@@ -529,6 +931,19 @@ func (p *parser) check(tokenType tokens.TokenType) bool {
 	return p.peek().Type() == tokenType
 }
 
+// checkAhead reports whether the token distance tokens after the current one has the given type
+func (p *parser) checkAhead(distance int, tokenType tokens.TokenType) bool {
+	if p.currentPos+distance >= len(p.input) {
+		return false
+	}
+	return p.input[p.currentPos+distance].Type() == tokenType
+}
+
+// checkWordAhead reports whether the token distance tokens after the current one is the identifier word
+func (p *parser) checkWordAhead(distance int, word string) bool {
+	return p.checkAhead(distance, tokens.Identifier) && p.input[p.currentPos+distance].Lexeme() == word
+}
+
 func (p *parser) isAtEnd() bool {
 	return p.peek().Type() == tokens.EOF
 }
@@ -1,5 +1,13 @@
 package parser
 
+import (
+	"github.com/mtvarkovsky/golox/pkg/ast"
+	"github.com/mtvarkovsky/golox/pkg/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
 //func TestParser(t *testing.T) {
 //	code := "(5 * (2 + 3)) - 25 == 0"
 //	scnr := scanner.NewScanner(code)
@@ -14,3 +22,229 @@ package parser
 //		assert.Equal(t, "(== (- (group (* 5 (group (+ 2 3)))) 25) )", stringRepr)
 //	}
 //}
+
+func TestParser_FunctionDeclaration(t *testing.T) {
+	code := "fun add(a, b) { return a + b; }\nprint add(1, 2);\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	statements, errs := NewParser(tkns).Parse()
+	assert.Empty(t, errs)
+	assert.Len(t, statements, 2)
+
+	function, ok := statements[0].(ast.FunctionStatement)
+	assert.True(t, ok)
+	assert.Equal(t, "add", function.Name().Lexeme())
+	assert.Len(t, function.Params(), 2)
+	assert.Len(t, function.Body(), 1)
+	assert.Equal(t, ast.ReturnStatementStatementType, function.Body()[0].Type())
+
+	printStatement, ok := statements[1].(ast.PrintStatement)
+	assert.True(t, ok)
+	call, ok := printStatement.Expression().(ast.Call)
+	assert.True(t, ok)
+	assert.Len(t, call.Arguments(), 2)
+}
+
+func TestParser_SynchronizesAfterError(t *testing.T) {
+	code := "fun (a) {}\nprint 1;\nfun g( {}\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	_, errs := NewParser(tkns).Parse()
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "Expect function name.", errs[0].Error())
+	assert.Equal(t, "Expect parameter name.", errs[1].Error())
+}
+
+func TestParser_Lists(t *testing.T) {
+	code := "var xs = [1, [2], []];\nxs[0] = xs[1][0];\nprint [1;\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	statements, errs := NewParser(tkns).Parse()
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "Expect ']' after list elements.", errs[0].Error())
+
+	list := statements[0].(ast.VarStatement).Initializer()
+	assert.Equal(t, ast.ListExpressionType, list.Type())
+	assert.Len(t, list.(ast.List).Elements(), 3)
+	assert.Empty(t, list.(ast.List).Elements()[2].(ast.List).Elements())
+
+	set := statements[1].(ast.ExpressionStatement).Expression()
+	assert.Equal(t, ast.SubscriptSetExpressionType, set.Type())
+	value := set.(ast.SubscriptSet).Value()
+	assert.Equal(t, ast.SubscriptExpressionType, value.Type())
+	assert.Equal(t, ast.SubscriptExpressionType, value.(ast.Subscript).Object().Type())
+	assert.Equal(t, "]", value.(ast.Subscript).Bracket().Lexeme())
+}
+
+func TestParser_Maps(t *testing.T) {
+	code := "var m = {\"a\": 1, 2: {}};\nm[\"b\"] = m[2];\n{ print 1; }\nprint {\"a\" 1};\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	statements, errs := NewParser(tkns).Parse()
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "Expect ':' after map key.", errs[0].Error())
+
+	m := statements[0].(ast.VarStatement).Initializer()
+	assert.Equal(t, ast.MapLiteralExpressionType, m.Type())
+	assert.Len(t, m.(ast.MapLiteral).Keys(), 2)
+	assert.Len(t, m.(ast.MapLiteral).Values(), 2)
+	assert.Equal(t, ast.MapLiteralExpressionType, m.(ast.MapLiteral).Values()[1].Type())
+	assert.Equal(t, "{", m.(ast.MapLiteral).Brace().Lexeme())
+
+	set := statements[1].(ast.ExpressionStatement).Expression()
+	assert.Equal(t, ast.SubscriptSetExpressionType, set.Type())
+
+	// a brace at the start of a statement still opens a block
+	assert.Equal(t, ast.BlockStatementStatementType, statements[2].Type())
+}
+
+func TestParser_ForIn(t *testing.T) {
+	code := "for (x in xs) print x;\nfor (var i = 0; i < 1; i = i + 1) print i;\nfor (var y in range(0, 2)) {}\nfor (z in) print z;\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	statements, errs := NewParser(tkns).Parse()
+	assert.Len(t, errs, 1)
+
+	forIn, ok := statements[0].(ast.ForInStatement)
+	assert.True(t, ok)
+	assert.Equal(t, "for", forIn.Keyword().Lexeme())
+	assert.Equal(t, "x", forIn.Name().Lexeme())
+	assert.Equal(t, ast.VariableExpressionType, forIn.Iterable().Type())
+	assert.Equal(t, ast.PrintStatementStatementType, forIn.Body().Type())
+
+	// the C-style loop is still desugared into a while loop
+	assert.Equal(t, ast.BlockStatementStatementType, statements[1].Type())
+
+	forIn, ok = statements[2].(ast.ForInStatement)
+	assert.True(t, ok)
+	assert.Equal(t, "y", forIn.Name().Lexeme())
+	assert.Equal(t, ast.CallExpressionType, forIn.Iterable().Type())
+}
+
+func TestParser_InIsNotReserved(t *testing.T) {
+	code := "var in = 1;\nfun f(in) { return in; }\nclass A { in() { this.in = in; } }\nfor (in in xs) print in;\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	statements, errs := NewParser(tkns).Parse()
+	assert.Empty(t, errs)
+	require.Len(t, statements, 4)
+
+	assert.Equal(t, "in", statements[0].(ast.VarStatement).Name().Lexeme())
+	forIn, ok := statements[3].(ast.ForInStatement)
+	assert.True(t, ok)
+	assert.Equal(t, "in", forIn.Name().Lexeme())
+	assert.Equal(t, "xs", forIn.Iterable().(ast.Variable).Name().Lexeme())
+}
+
+func TestParser_BreakAndContinue(t *testing.T) {
+	code := "for (var i = 0; i < 3; i = i + 1) { if (i == 1) continue; break; }\nbreak\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	statements, errs := NewParser(tkns).Parse()
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "Expect ';' after 'break'.", errs[0].Error())
+
+	block, ok := statements[0].(ast.BlockStatement)
+	assert.True(t, ok)
+	loop, ok := block.Statements()[1].(ast.WhileStatement)
+	assert.True(t, ok)
+	// the increment is kept apart from the body, so continue doesn't skip it
+	assert.Equal(t, ast.AssignmentExpressionType, loop.Increment().Type())
+
+	body := loop.Body().(ast.BlockStatement).Statements()
+	assert.Len(t, body, 2)
+	assert.Equal(t, ast.ContinueStatementStatementType, body[0].(ast.IfStatement).ThenStatement().Type())
+	assert.Equal(t, ast.BreakStatementStatementType, body[1].Type())
+}
+
+func TestParser_SynchronizesAtBreakAndContinue(t *testing.T) {
+	code := "while (true) {\nprint 1 2\nbreak;\nprint 3 4\ncontinue\n}\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	_, errs := NewParser(tkns).Parse()
+	// recovery stops before break and continue, so the error of the continue statement is still reported
+	assert.Len(t, errs, 3)
+	assert.Equal(t, "Expect ';' after value.", errs[0].Error())
+	assert.Equal(t, "Expect ';' after value.", errs[1].Error())
+	assert.Equal(t, "Expect ';' after 'continue'.", errs[2].Error())
+	assert.Equal(t, "}", errs[2].Token.Lexeme())
+}
+
+func TestParser_Try(t *testing.T) {
+	code := "try { throw \"boom\"; } catch (e) { print e; } finally { print 1; }\ntry { print 2; } finally {}\ntry { print 3; }\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	statements, errs := NewParser(tkns).Parse()
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "Expect 'catch' or 'finally' after try block.", errs[0].Error())
+
+	try, ok := statements[0].(ast.TryStatement)
+	assert.True(t, ok)
+	assert.Equal(t, "try", try.Keyword().Lexeme())
+	assert.Len(t, try.Body(), 1)
+	assert.Equal(t, ast.ThrowStatementStatementType, try.Body()[0].Type())
+	assert.Equal(t, "e", try.Name().Lexeme())
+	assert.Len(t, try.CatchBody(), 1)
+	assert.Len(t, try.FinallyBody(), 1)
+
+	try, ok = statements[1].(ast.TryStatement)
+	assert.True(t, ok)
+	assert.Nil(t, try.Name(), "there is no catch clause")
+	assert.Empty(t, try.FinallyBody())
+}
+
+func TestParser_SynchronizesAtTryAndThrow(t *testing.T) {
+	code := "print 1 2\ntry { print 3; } catch (e) {}\nprint 4 5\nthrow;\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	statements, errs := NewParser(tkns).Parse()
+	assert.Len(t, errs, 3)
+	assert.Equal(t, "Expect ';' after value.", errs[0].Error())
+	assert.Equal(t, "Expect ';' after value.", errs[1].Error())
+	// recovery stops before throw, so its missing value is still reported
+	assert.Equal(t, "expect exception", errs[2].Error())
+	assert.Equal(t, 4, errs[2].Token.Line())
+
+	// recovery stops before try, so the statement after the error is parsed whole
+	require.Len(t, statements, 4)
+	try, ok := statements[1].(ast.TryStatement)
+	assert.True(t, ok)
+	assert.Len(t, try.Body(), 1)
+	assert.Equal(t, "e", try.Name().Lexeme())
+}
+
+func TestParser_MissingSemicolonHint(t *testing.T) {
+	code := "var a = 1\nprint a;\nprint a print a;\n"
+	scnr := scanner.NewScanner(code)
+	tkns, scanErrs := scnr.ScanTokens()
+	assert.Empty(t, scanErrs)
+
+	_, errs := NewParser(tkns).Parse()
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "Expect ';' after variable declaration.", errs[0].Error())
+	assert.Equal(t, "print", errs[0].Token.Lexeme())
+	assert.Equal(t, "add ';' at the end of line 1", errs[0].Hint)
+	// the next token is on the same line, the error already points at the right place
+	assert.Equal(t, "Expect ';' after value.", errs[1].Error())
+	assert.Empty(t, errs[1].Hint)
+}
@@ -0,0 +1,423 @@
+package resolver
+
+import (
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/ast"
+	"github.com/mtvarkovsky/golox/pkg/tokens"
+)
+
+type (
+	// Resolver walks the parsed program once before it is interpreted.
+	// It binds every local variable reference to the scope it was declared in
+	// and reports the scoping mistakes that can be detected without running the code.
+	Resolver interface {
+		Resolve(statements []ast.Statement) (Locals, []*Error)
+	}
+
+	// Locals maps variable, assignment, this and super expressions to the local variable they refer to.
+	// Expressions that are missing from the map refer to global variables.
+	Locals map[ast.Expression]Local
+
+	// Local is the position of a local variable at runtime.
+	// Depth is the number of scopes between the expression and the declaration it refers to,
+	// Index is the order of the declaration in its scope, the first declaration has index 0.
+	Local struct {
+		Depth int
+		Index int
+	}
+
+	resolver struct {
+		// scopes holds the block scopes that are currently open, innermost last
+		scopes          []scope
+		locals          Locals
+		currentFunction functionType
+		currentClass    classType
+		errs            []*Error
+		// loopDepth counts the loops around the statement being resolved in the current function
+		loopDepth int
+	}
+
+	Error struct {
+		Token tokens.Token
+		err   error
+	}
+
+	// scope maps the names declared in a block to their variables
+	scope map[string]variable
+
+	variable struct {
+		index int
+		// defined is false while the variable is declared but its initializer is still being resolved
+		defined bool
+	}
+
+	functionType int
+	classType    int
+)
+
+const (
+	noFunction functionType = iota
+	function
+	method
+	initializer
+)
+
+const (
+	noClass classType = iota
+	class
+	subclass
+)
+
+func NewResolver() Resolver {
+	return &resolver{}
+}
+
+func (r *resolver) Resolve(statements []ast.Statement) (Locals, []*Error) {
+	r.scopes = nil
+	r.locals = make(Locals)
+	r.currentFunction = noFunction
+	r.currentClass = noClass
+	r.loopDepth = 0
+	r.errs = nil
+
+	r.resolveStatements(statements)
+
+	return r.locals, r.errs
+}
+
+func (r *resolver) resolveStatements(statements []ast.Statement) {
+	for _, statement := range statements {
+		r.resolveStatement(statement)
+	}
+}
+
+func (r *resolver) resolveStatement(statement ast.Statement) {
+	_, _ = statement.Accept(r.visitStatement)
+}
+
+func (r *resolver) resolveExpression(expression ast.Expression) {
+	_, _ = expression.Accept(r.visitExpression)
+}
+
+func (r *resolver) visitStatement(statement ast.Statement) (any, error) {
+	switch statement.Type() {
+	case ast.BlockStatementStatementType:
+		r.visitBlockStatement(statement.(ast.BlockStatement))
+	case ast.ClassStatementStatementType:
+		r.visitClassStatement(statement.(ast.ClassStatement))
+	case ast.VarStatementStatementType:
+		r.visitVarStatement(statement.(ast.VarStatement))
+	case ast.FunctionStatementStatementType:
+		r.visitFunctionStatement(statement.(ast.FunctionStatement))
+	case ast.ExpressionStatementStatementType:
+		r.resolveExpression(statement.(ast.ExpressionStatement).Expression())
+	case ast.IfStatementStatementType:
+		r.visitIfStatement(statement.(ast.IfStatement))
+	case ast.PrintStatementStatementType:
+		r.resolveExpression(statement.(ast.PrintStatement).Expression())
+	case ast.ReturnStatementStatementType:
+		r.visitReturnStatement(statement.(ast.ReturnStatement))
+	case ast.WhileStatementStatementType:
+		r.visitWhileStatement(statement.(ast.WhileStatement))
+	case ast.ForInStatementStatementType:
+		r.visitForInStatement(statement.(ast.ForInStatement))
+	case ast.ThrowStatementStatementType:
+		r.resolveExpression(statement.(ast.ThrowStatement).Value())
+	case ast.TryStatementStatementType:
+		r.visitTryStatement(statement.(ast.TryStatement))
+	case ast.BreakStatementStatementType:
+		if r.loopDepth == 0 {
+			r.error(statement.(ast.BreakStatement).Keyword(), "Can't use 'break' outside of a loop.")
+		}
+	case ast.ContinueStatementStatementType:
+		if r.loopDepth == 0 {
+			r.error(statement.(ast.ContinueStatement).Keyword(), "Can't use 'continue' outside of a loop.")
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *resolver) visitBlockStatement(statement ast.BlockStatement) {
+	r.beginScope()
+	r.resolveStatements(statement.Statements())
+	r.endScope()
+}
+
+func (r *resolver) visitClassStatement(statement ast.ClassStatement) {
+	enclosingClass := r.currentClass
+	r.currentClass = class
+
+	r.declare(statement.Name())
+	r.define(statement.Name())
+
+	if statement.Superclass() != nil {
+		if statement.Superclass().Name().Lexeme() == statement.Name().Lexeme() {
+			r.error(statement.Superclass().Name(), "A class can't inherit from itself.")
+		}
+		r.currentClass = subclass
+		r.resolveExpression(statement.Superclass())
+
+		r.beginScope()
+		r.scopes[len(r.scopes)-1]["super"] = variable{index: 0, defined: true}
+	}
+
+	r.beginScope()
+	r.scopes[len(r.scopes)-1]["this"] = variable{index: 0, defined: true}
+	for _, m := range statement.Methods() {
+		fType := method
+		if m.Name().Lexeme() == "init" {
+			fType = initializer
+		}
+		r.resolveFunction(m, fType)
+	}
+	r.endScope()
+
+	if statement.Superclass() != nil {
+		r.endScope()
+	}
+
+	r.currentClass = enclosingClass
+}
+
+func (r *resolver) visitVarStatement(statement ast.VarStatement) {
+	r.declare(statement.Name())
+	if statement.Initializer() != nil {
+		r.resolveExpression(statement.Initializer())
+	}
+	r.define(statement.Name())
+}
+
+func (r *resolver) visitFunctionStatement(statement ast.FunctionStatement) {
+	r.declare(statement.Name())
+	r.define(statement.Name())
+	r.resolveFunction(statement, function)
+}
+
+func (r *resolver) visitIfStatement(statement ast.IfStatement) {
+	r.resolveExpression(statement.Condition())
+	r.resolveStatement(statement.ThenStatement())
+	if statement.ElseStatement() != nil {
+		r.resolveStatement(statement.ElseStatement())
+	}
+}
+
+func (r *resolver) visitReturnStatement(statement ast.ReturnStatement) {
+	if r.currentFunction == noFunction {
+		r.error(statement.Keyword(), "Can't return from top-level code.")
+	}
+	if statement.Value() != nil {
+		if r.currentFunction == initializer {
+			r.error(statement.Keyword(), "Can't return a value from an initializer.")
+		}
+		r.resolveExpression(statement.Value())
+	}
+}
+
+func (r *resolver) visitWhileStatement(statement ast.WhileStatement) {
+	r.resolveExpression(statement.Condition())
+	r.loopDepth++
+	r.resolveStatement(statement.Body())
+	r.loopDepth--
+	if statement.Increment() != nil {
+		r.resolveExpression(statement.Increment())
+	}
+}
+
+// visitForInStatement puts the loop variable in a scope of its own around the body, every iteration gets a new one
+func (r *resolver) visitForInStatement(statement ast.ForInStatement) {
+	r.resolveExpression(statement.Iterable())
+	r.beginScope()
+	r.declare(statement.Name())
+	r.define(statement.Name())
+	r.loopDepth++
+	r.resolveStatement(statement.Body())
+	r.loopDepth--
+	r.endScope()
+}
+
+// visitTryStatement resolves each block of the statement in a scope of its own,
+// the catch variable shares its scope with the catch body like parameters do with a function body
+func (r *resolver) visitTryStatement(statement ast.TryStatement) {
+	r.beginScope()
+	r.resolveStatements(statement.Body())
+	r.endScope()
+
+	if statement.Name() != nil {
+		r.beginScope()
+		r.declare(statement.Name())
+		r.define(statement.Name())
+		r.resolveStatements(statement.CatchBody())
+		r.endScope()
+	}
+
+	r.beginScope()
+	r.resolveStatements(statement.FinallyBody())
+	r.endScope()
+}
+
+func (r *resolver) visitExpression(expression ast.Expression) (any, error) {
+	switch expression.Type() {
+	case ast.VariableExpressionType:
+		r.visitVariable(expression.(ast.Variable))
+	case ast.AssignmentExpressionType:
+		r.visitAssignment(expression.(ast.Assignment))
+	case ast.BinaryExpressionType:
+		binary := expression.(ast.Binary)
+		r.resolveExpression(binary.Left())
+		r.resolveExpression(binary.Right())
+	case ast.LogicalExpressionType:
+		logical := expression.(ast.Logical)
+		r.resolveExpression(logical.Left())
+		r.resolveExpression(logical.Right())
+	case ast.UnaryExpressionType:
+		r.resolveExpression(expression.(ast.Unary).Right())
+	case ast.GroupingExpressionType:
+		r.resolveExpression(expression.(ast.Grouping).Expression())
+	case ast.CallExpressionType:
+		r.visitCall(expression.(ast.Call))
+	case ast.GetExpressionType:
+		r.resolveExpression(expression.(ast.Get).Object())
+	case ast.SetExpressionType:
+		set := expression.(ast.Set)
+		r.resolveExpression(set.Value())
+		r.resolveExpression(set.Object())
+	case ast.ThisExpressionType:
+		r.visitThis(expression.(ast.This))
+	case ast.SuperExpressionType:
+		r.visitSuper(expression.(ast.Super))
+	case ast.ListExpressionType:
+		for _, element := range expression.(ast.List).Elements() {
+			r.resolveExpression(element)
+		}
+	case ast.SubscriptExpressionType:
+		subscript := expression.(ast.Subscript)
+		r.resolveExpression(subscript.Object())
+		r.resolveExpression(subscript.Index())
+	case ast.SubscriptSetExpressionType:
+		set := expression.(ast.SubscriptSet)
+		r.resolveExpression(set.Value())
+		r.resolveExpression(set.Object())
+		r.resolveExpression(set.Index())
+	case ast.MapLiteralExpressionType:
+		m := expression.(ast.MapLiteral)
+		for i, key := range m.Keys() {
+			r.resolveExpression(key)
+			r.resolveExpression(m.Values()[i])
+		}
+	case ast.LiteralExpressionType:
+	}
+
+	return nil, nil
+}
+
+func (r *resolver) visitVariable(expression ast.Variable) {
+	if len(r.scopes) > 0 {
+		if v, declared := r.scopes[len(r.scopes)-1][expression.Name().Lexeme()]; declared && !v.defined {
+			r.error(expression.Name(), "Can't read local variable in its own initializer.")
+		}
+	}
+	r.resolveLocal(expression, expression.Name())
+}
+
+func (r *resolver) visitAssignment(expression ast.Assignment) {
+	r.resolveExpression(expression.Value())
+	r.resolveLocal(expression, expression.Name())
+}
+
+func (r *resolver) visitCall(expression ast.Call) {
+	r.resolveExpression(expression.Callee())
+	for _, argument := range expression.Arguments() {
+		r.resolveExpression(argument)
+	}
+}
+
+func (r *resolver) visitThis(expression ast.This) {
+	if r.currentClass == noClass {
+		r.error(expression.Keyword(), "Can't use 'this' outside of a class.")
+		return
+	}
+	r.resolveLocal(expression, expression.Keyword())
+}
+
+func (r *resolver) visitSuper(expression ast.Super) {
+	if r.currentClass == noClass {
+		r.error(expression.Keyword(), "Can't use 'super' outside of a class.")
+		return
+	}
+	if r.currentClass != subclass {
+		r.error(expression.Keyword(), "Can't use 'super' in a class with no superclass.")
+		return
+	}
+	r.resolveLocal(expression, expression.Keyword())
+}
+
+func (r *resolver) resolveFunction(declaration ast.FunctionStatement, fType functionType) {
+	enclosingFunction := r.currentFunction
+	r.currentFunction = fType
+	// break and continue can't jump out of a function to a loop around its declaration
+	enclosingLoopDepth := r.loopDepth
+	r.loopDepth = 0
+
+	r.beginScope()
+	for _, param := range declaration.Params() {
+		r.declare(param)
+		r.define(param)
+	}
+	r.resolveStatements(declaration.Body())
+	r.endScope()
+
+	r.currentFunction = enclosingFunction
+	r.loopDepth = enclosingLoopDepth
+}
+
+func (r *resolver) resolveLocal(expression ast.Expression, name tokens.Token) {
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		if v, found := r.scopes[i][name.Lexeme()]; found {
+			r.locals[expression] = Local{Depth: len(r.scopes) - 1 - i, Index: v.index}
+			return
+		}
+	}
+}
+
+func (r *resolver) beginScope() {
+	r.scopes = append(r.scopes, make(scope))
+}
+
+func (r *resolver) endScope() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *resolver) declare(name tokens.Token) {
+	if len(r.scopes) == 0 {
+		return
+	}
+	scope := r.scopes[len(r.scopes)-1]
+	if _, found := scope[name.Lexeme()]; found {
+		r.error(name, "Already a variable with this name in this scope.")
+		return
+	}
+	// variables get their index in the order they are declared, the interpreter defines them in the same order
+	scope[name.Lexeme()] = variable{index: len(scope)}
+}
+
+func (r *resolver) define(name tokens.Token) {
+	if len(r.scopes) == 0 {
+		return
+	}
+	scope := r.scopes[len(r.scopes)-1]
+	v := scope[name.Lexeme()]
+	v.defined = true
+	scope[name.Lexeme()] = v
+}
+
+func (r *resolver) error(token tokens.Token, message string) {
+	r.errs = append(r.errs, &Error{
+		Token: token,
+		err:   fmt.Errorf(message),
+	})
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
@@ -0,0 +1,201 @@
+package resolver
+
+import (
+	"github.com/mtvarkovsky/golox/pkg/ast"
+	"github.com/mtvarkovsky/golox/pkg/parser"
+	"github.com/mtvarkovsky/golox/pkg/scanner"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func parse(t *testing.T, code string) []ast.Statement {
+	tkns, scanErrs := scanner.NewScanner(code).ScanTokens()
+	assert.Empty(t, scanErrs)
+	statements, parseErrs := parser.NewParser(tkns).Parse()
+	assert.Empty(t, parseErrs)
+	return statements
+}
+
+func TestResolver_Depths(t *testing.T) {
+	code := `
+var global = 1;
+fun outer(a) {
+    var b = a;
+    {
+        print a + b + global;
+    }
+}
+`
+	statements := parse(t, code)
+	locals, errs := NewResolver().Resolve(statements)
+	assert.Empty(t, errs)
+
+	body := statements[1].(ast.FunctionStatement).Body()
+	initializer := body[0].(ast.VarStatement).Initializer()
+	assert.Equal(t, Local{Depth: 0, Index: 0}, locals[initializer])
+
+	block := body[1].(ast.BlockStatement)
+	sum := block.Statements()[0].(ast.PrintStatement).Expression().(ast.Binary)
+	aPlusB := sum.Left().(ast.Binary)
+	assert.Equal(t, Local{Depth: 1, Index: 0}, locals[aPlusB.Left()])
+	assert.Equal(t, Local{Depth: 1, Index: 1}, locals[aPlusB.Right()], "b is declared after the parameter a")
+
+	_, found := locals[sum.Right()]
+	assert.False(t, found, "globals are not resolved")
+}
+
+func TestResolver_Slots(t *testing.T) {
+	code := `
+class A {}
+class B < A {
+    m() {
+        var x;
+        fun f() {}
+        var y;
+        return super.m() or this or x or f or y;
+    }
+}
+`
+	statements := parse(t, code)
+	locals, errs := NewResolver().Resolve(statements)
+	assert.Empty(t, errs)
+
+	body := statements[1].(ast.ClassStatement).Methods()[0].Body()
+	or := body[3].(ast.ReturnStatement).Value()
+	var operands []ast.Expression
+	for or.Type() == ast.LogicalExpressionType {
+		operands = append([]ast.Expression{or.(ast.Logical).Right()}, operands...)
+		or = or.(ast.Logical).Left()
+	}
+	super := or.(ast.Call).Callee()
+
+	assert.Equal(t, Local{Depth: 2, Index: 0}, locals[super])
+	expected := []Local{
+		{Depth: 1, Index: 0},
+		{Depth: 0, Index: 0},
+		{Depth: 0, Index: 1},
+		{Depth: 0, Index: 2},
+	}
+	assert.Equal(t, expected, []Local{locals[operands[0]], locals[operands[1]], locals[operands[2]], locals[operands[3]]})
+}
+
+func TestResolver_ForIn(t *testing.T) {
+	code := `
+{
+    var xs;
+    for (x in xs) {
+        print x;
+    }
+}
+`
+	statements := parse(t, code)
+	locals, errs := NewResolver().Resolve(statements)
+	assert.Empty(t, errs)
+
+	forIn := statements[0].(ast.BlockStatement).Statements()[1].(ast.ForInStatement)
+	assert.Equal(t, Local{Depth: 0, Index: 0}, locals[forIn.Iterable()], "the iterable is resolved outside of the loop scope")
+	x := forIn.Body().(ast.BlockStatement).Statements()[0].(ast.PrintStatement).Expression()
+	assert.Equal(t, Local{Depth: 1, Index: 0}, locals[x])
+}
+
+func TestResolver_Errors(t *testing.T) {
+	cases := []struct {
+		name    string
+		code    string
+		lexeme  string
+		message string
+	}{
+		{
+			name:    "top level return",
+			code:    "return 1;\n",
+			lexeme:  "return",
+			message: "Can't return from top-level code.",
+		},
+		{
+			name:    "local redeclaration",
+			code:    "{ var a = 1; var a = 2; }\n",
+			lexeme:  "a",
+			message: "Already a variable with this name in this scope.",
+		},
+		{
+			name:    "read in own initializer",
+			code:    "{ var a = a; }\n",
+			lexeme:  "a",
+			message: "Can't read local variable in its own initializer.",
+		},
+		{
+			name:    "this outside of class",
+			code:    "fun f() { print this; }\n",
+			lexeme:  "this",
+			message: "Can't use 'this' outside of a class.",
+		},
+		{
+			name:    "value returned from initializer",
+			code:    "class A { init() { return 1; } }\n",
+			lexeme:  "return",
+			message: "Can't return a value from an initializer.",
+		},
+		{
+			name:    "class inherits from itself",
+			code:    "class A < A {}\n",
+			lexeme:  "A",
+			message: "A class can't inherit from itself.",
+		},
+		{
+			name:    "super outside of class",
+			code:    "fun f() { super.g(); }\n",
+			lexeme:  "super",
+			message: "Can't use 'super' outside of a class.",
+		},
+		{
+			name:    "super without superclass",
+			code:    "class A { f() { super.f(); } }\n",
+			lexeme:  "super",
+			message: "Can't use 'super' in a class with no superclass.",
+		},
+		{
+			name:    "duplicate parameter",
+			code:    "fun f(a, a) {}\n",
+			lexeme:  "a",
+			message: "Already a variable with this name in this scope.",
+		},
+		{
+			name:    "top level break",
+			code:    "break;\n",
+			lexeme:  "break",
+			message: "Can't use 'break' outside of a loop.",
+		},
+		{
+			name:    "continue in a function declared in a loop",
+			code:    "while (true) { fun f() { continue; } }\n",
+			lexeme:  "continue",
+			message: "Can't use 'continue' outside of a loop.",
+		},
+		{
+			name:    "break after a for-in loop",
+			code:    "for (x in xs) print x;\nif (true) break;\n",
+			lexeme:  "break",
+			message: "Can't use 'break' outside of a loop.",
+		},
+		{
+			name:    "catch variable redeclared in the catch body",
+			code:    "try {} catch (e) { var e = 1; }\n",
+			lexeme:  "e",
+			message: "Already a variable with this name in this scope.",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, errs := NewResolver().Resolve(parse(t, tc.code))
+			assert.Len(t, errs, 1)
+			assert.Equal(t, tc.lexeme, errs[0].Token.Lexeme())
+			assert.Equal(t, tc.message, errs[0].Error())
+		})
+	}
+}
+
+func TestResolver_GlobalRedeclarationIsAllowed(t *testing.T) {
+	_, errs := NewResolver().Resolve(parse(t, "var a = 1;\nvar a = 2;\n"))
+	assert.Empty(t, errs)
+}
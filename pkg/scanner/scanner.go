@@ -18,37 +18,52 @@ type (
 		input  string
 		tokens []tokens.Token
 
-		lexemeStartPos int
-		currentPos     int
-		currentLine    int
-		currentLinePos int
+		lexemeStartPos     int
+		lexemeStartLine    int
+		lexemeStartLinePos int
+		currentPos         int
+		currentLine        int
+		currentLinePos     int
 	}
 
 	Error struct {
 		Line int
 		Pos  int
-		Err  error
+		// Start and End are the byte offsets in Source of the characters the error is about, End is excluded
+		Start int
+		End   int
+		// Hint suggests how to fix the error, it is empty when there is nothing to suggest
+		Hint string
+		// Source is the program that was scanned
+		Source string
+		Err    error
 	}
 )
 
 var (
 	Keywords = map[string]tokens.TokenType{
-		"and":    tokens.And,
-		"class":  tokens.Class,
-		"else":   tokens.Else,
-		"false":  tokens.False,
-		"for":    tokens.For,
-		"fun":    tokens.Fun,
-		"if":     tokens.If,
-		"nil":    tokens.Nil,
-		"or":     tokens.Or,
-		"print":  tokens.Print,
-		"return": tokens.Return,
-		"super":  tokens.Super,
-		"this":   tokens.This,
-		"true":   tokens.True,
-		"var":    tokens.Var,
-		"while":  tokens.While,
+		"and":      tokens.And,
+		"break":    tokens.Break,
+		"catch":    tokens.Catch,
+		"class":    tokens.Class,
+		"continue": tokens.Continue,
+		"else":     tokens.Else,
+		"false":    tokens.False,
+		"finally":  tokens.Finally,
+		"for":      tokens.For,
+		"fun":      tokens.Fun,
+		"if":       tokens.If,
+		"nil":      tokens.Nil,
+		"or":       tokens.Or,
+		"print":    tokens.Print,
+		"return":   tokens.Return,
+		"super":    tokens.Super,
+		"this":     tokens.This,
+		"throw":    tokens.Throw,
+		"true":     tokens.True,
+		"try":      tokens.Try,
+		"var":      tokens.Var,
+		"while":    tokens.While,
 	}
 
 	SingleCharLexemeToToken = map[rune]tokens.TokenType{
@@ -56,6 +71,9 @@ var (
 		')': tokens.RightParen,
 		'{': tokens.LeftBrace,
 		'}': tokens.RightBrace,
+		'[': tokens.LeftBracket,
+		']': tokens.RightBracket,
+		':': tokens.Colon,
 		',': tokens.Comma,
 		'.': tokens.Dot,
 		'-': tokens.Minus,
@@ -111,12 +129,14 @@ func (s *scanner) ScanTokens() ([]tokens.Token, []*Error) {
 
 	for !s.IsAtEnd() {
 		s.lexemeStartPos = s.currentPos
+		s.lexemeStartLine = s.currentLine
+		s.lexemeStartLinePos = s.currentLinePos + 1
 		if err := s.scanToken(); err != nil {
 			errs = append(errs, err)
 		}
 	}
 
-	s.appendToken(tokens.NewToken(tokens.EOF, "", nil, s.currentLine, s.currentLinePos))
+	s.appendToken(tokens.NewSourceToken(tokens.EOF, "", nil, s.currentLine, s.currentLinePos, s.currentPos, s.input))
 
 	return s.tokens, errs
 }
@@ -127,7 +147,20 @@ func (s *scanner) appendToken(t tokens.Token) {
 
 func (s *scanner) addToken(tType tokens.TokenType, literal any) {
 	text := s.input[s.lexemeStartPos:s.currentPos]
-	s.appendToken(tokens.NewToken(tType, text, literal, s.currentLine, s.currentLinePos-len(text)+1))
+	s.appendToken(tokens.NewSourceToken(tType, text, literal, s.lexemeStartLine, s.lexemeStartLinePos, s.lexemeStartPos, s.input))
+}
+
+// lexemeError reports an error about the lexeme scanned so far
+func (s *scanner) lexemeError(err error, hint string) *Error {
+	return &Error{
+		Line:   s.lexemeStartLine,
+		Pos:    s.lexemeStartLinePos,
+		Start:  s.lexemeStartPos,
+		End:    s.currentPos,
+		Hint:   hint,
+		Source: s.input,
+		Err:    err,
+	}
 }
 
 func (s *scanner) scanToken() *Error {
@@ -182,11 +215,7 @@ func (s *scanner) scanToken() *Error {
 		return nil
 	}
 
-	return &Error{
-		Err:  fmt.Errorf("unexpected character %c", c),
-		Pos:  s.currentPos,
-		Line: s.currentLinePos,
-	}
+	return s.lexemeError(fmt.Errorf("unexpected character %c", c), "")
 }
 
 func (s *scanner) next() rune {
@@ -217,21 +246,14 @@ func (s *scanner) peekNext() rune {
 
 func (s *scanner) string() *Error {
 	for s.peek() != '"' && !s.IsAtEnd() {
-		if s.peek() == '\n' {
+		if s.next() == '\n' {
 			s.currentLine++
-			s.currentLinePos++
 			s.currentLinePos = 0
 		}
-
-		_ = s.next()
 	}
 
 	if s.IsAtEnd() {
-		return &Error{
-			Line: s.currentLine,
-			Pos:  s.currentLinePos,
-			Err:  fmt.Errorf("unterminated string"),
-		}
+		return s.lexemeError(fmt.Errorf("unterminated string"), "add a closing '\"' at the end of the string")
 	}
 
 	_ = s.next()
@@ -261,11 +283,7 @@ func (s *scanner) number() *Error {
 
 	val, err := strconv.ParseFloat(s.input[s.lexemeStartPos:s.currentPos], 54)
 	if err != nil {
-		return &Error{
-			Line: s.currentLine,
-			Pos:  s.currentLinePos,
-			Err:  fmt.Errorf("unsopported number format"),
-		}
+		return s.lexemeError(fmt.Errorf("unsopported number format"), "")
 	}
 	s.addToken(tokens.Number, val)
 
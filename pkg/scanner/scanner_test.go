@@ -2943,7 +2943,120 @@ func TestScanner_Success(t *testing.T) {
 		),
 	}
 
-	for i, tkn := range tkns {
+	for i, tkn := range withoutSpans(tkns) {
 		assert.Equal(t, expectedTokens[i], tkn)
 	}
 }
+
+func TestScanner_Brackets(t *testing.T) {
+	tkns, errs := NewScanner("xs[0] = [];\n").ScanTokens()
+	assert.Nil(t, errs)
+
+	expectedTokens := []tokens.Token{
+		tokens.NewToken(tokens.Identifier, `xs`, nil, 1, 1),
+		tokens.NewToken(tokens.LeftBracket, `[`, nil, 1, 3),
+		tokens.NewToken(tokens.Number, `0`, 0.0, 1, 4),
+		tokens.NewToken(tokens.RightBracket, `]`, nil, 1, 5),
+		tokens.NewToken(tokens.Equal, `=`, nil, 1, 7),
+		tokens.NewToken(tokens.LeftBracket, `[`, nil, 1, 9),
+		tokens.NewToken(tokens.RightBracket, `]`, nil, 1, 10),
+		tokens.NewToken(tokens.Semicolon, `;`, nil, 1, 11),
+		tokens.NewToken(tokens.EOF, ``, nil, 1, 11),
+	}
+	assert.Equal(t, expectedTokens, withoutSpans(tkns))
+}
+
+func TestScanner_Colon(t *testing.T) {
+	tkns, errs := NewScanner("{\"a\": 1}\n").ScanTokens()
+	assert.Nil(t, errs)
+
+	expectedTokens := []tokens.Token{
+		tokens.NewToken(tokens.LeftBrace, `{`, nil, 1, 1),
+		tokens.NewToken(tokens.String, `"a"`, "a", 1, 2),
+		tokens.NewToken(tokens.Colon, `:`, nil, 1, 5),
+		tokens.NewToken(tokens.Number, `1`, 1.0, 1, 7),
+		tokens.NewToken(tokens.RightBrace, `}`, nil, 1, 8),
+		tokens.NewToken(tokens.EOF, ``, nil, 1, 8),
+	}
+	assert.Equal(t, expectedTokens, withoutSpans(tkns))
+}
+
+func TestScanner_LoopKeywords(t *testing.T) {
+	tkns, errs := NewScanner("break; continue;\n").ScanTokens()
+	assert.Nil(t, errs)
+
+	expectedTokens := []tokens.Token{
+		tokens.NewToken(tokens.Break, `break`, nil, 1, 1),
+		tokens.NewToken(tokens.Semicolon, `;`, nil, 1, 6),
+		tokens.NewToken(tokens.Continue, `continue`, nil, 1, 8),
+		tokens.NewToken(tokens.Semicolon, `;`, nil, 1, 16),
+		tokens.NewToken(tokens.EOF, ``, nil, 1, 16),
+	}
+	assert.Equal(t, expectedTokens, withoutSpans(tkns))
+}
+
+func TestScanner_ExceptionKeywords(t *testing.T) {
+	tkns, errs := NewScanner("try catch finally throw\n").ScanTokens()
+	assert.Nil(t, errs)
+
+	expectedTokens := []tokens.Token{
+		tokens.NewToken(tokens.Try, `try`, nil, 1, 1),
+		tokens.NewToken(tokens.Catch, `catch`, nil, 1, 5),
+		tokens.NewToken(tokens.Finally, `finally`, nil, 1, 11),
+		tokens.NewToken(tokens.Throw, `throw`, nil, 1, 19),
+		tokens.NewToken(tokens.EOF, ``, nil, 1, 23),
+	}
+	assert.Equal(t, expectedTokens, withoutSpans(tkns))
+}
+
+func TestScanner_Spans(t *testing.T) {
+	code := "var s = \"a\nbc\";\nprint s;\n"
+	tkns, errs := NewScanner(code).ScanTokens()
+	assert.Nil(t, errs)
+
+	for _, tkn := range tkns {
+		assert.Equal(t, code, tkn.Source())
+		assert.Equal(t, tkn.Lexeme(), code[tkn.Start():tkn.End()])
+	}
+
+	// a string spanning two lines starts where its opening quote is
+	str := tkns[3]
+	assert.Equal(t, 1, str.Line())
+	assert.Equal(t, 9, str.Position())
+	assert.Equal(t, 2, str.EndLine())
+	assert.Equal(t, 4, str.EndColumn())
+	assert.Equal(t, 2, tkns[4].Line())
+	assert.Equal(t, 4, tkns[4].Position())
+	assert.Equal(t, 3, tkns[5].Line())
+	assert.Equal(t, 1, tkns[5].Position())
+}
+
+func TestScanner_Errors(t *testing.T) {
+	_, errs := NewScanner("var a = 1 @ 2;\nprint \"ab;\n").ScanTokens()
+	assert.Len(t, errs, 2)
+
+	assert.Equal(t, "unexpected character @", errs[0].Error())
+	assert.Equal(t, 1, errs[0].Line)
+	assert.Equal(t, 11, errs[0].Pos)
+	assert.Equal(t, 10, errs[0].Start)
+	assert.Equal(t, 11, errs[0].End)
+	assert.Empty(t, errs[0].Hint)
+
+	assert.Equal(t, "unterminated string", errs[1].Error())
+	assert.Equal(t, 2, errs[1].Line)
+	assert.Equal(t, 7, errs[1].Pos)
+	assert.Equal(t, 21, errs[1].Start)
+	assert.Equal(t, 25, errs[1].End)
+	assert.Equal(t, "add a closing '\"' at the end of the string", errs[1].Hint)
+	assert.Equal(t, "var a = 1 @ 2;\nprint \"ab;\n", errs[1].Source)
+}
+
+// withoutSpans copies tkns without their offsets and the program they were scanned from,
+// so they compare equal to tokens made by NewToken
+func withoutSpans(tkns []tokens.Token) []tokens.Token {
+	stripped := make([]tokens.Token, 0, len(tkns))
+	for _, tkn := range tkns {
+		stripped = append(stripped, tokens.NewToken(tkn.Type(), tkn.Lexeme(), tkn.Literal(), tkn.Line(), tkn.Position()))
+	}
+	return stripped
+}
@@ -1,6 +1,9 @@
 package tokens
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type TokenType int
 
@@ -10,6 +13,9 @@ const (
 	RightParen
 	LeftBrace
 	RightBrace
+	LeftBracket
+	RightBracket
+	Colon
 	Comma
 	Dot
 	Minus
@@ -36,9 +42,13 @@ const (
 
 	// Keywords
 	And
+	Break
+	Catch
 	Class
+	Continue
 	Else
 	False
+	Finally
 	Fun
 	For
 	If
@@ -48,7 +58,9 @@ const (
 	Return
 	Super
 	This
+	Throw
 	True
+	Try
 	Var
 	While
 
@@ -62,6 +74,9 @@ func (tt TokenType) String() string {
 		"RIGHT_PAREN",
 		"LEFT_BRACE",
 		"RIGHT_BRACE",
+		"LEFT_BRACKET",
+		"RIGHT_BRACKET",
+		"COLON",
 		"COMMA",
 		"DOT",
 		"MINUS",
@@ -88,9 +103,13 @@ func (tt TokenType) String() string {
 
 		// Keywords
 		"AND",
+		"BREAK",
+		"CATCH",
 		"CLASS",
+		"CONTINUE",
 		"ELSE",
 		"FALSE",
+		"FINALLY",
 		"FUN",
 		"FOR",
 		"IF",
@@ -100,7 +119,9 @@ func (tt TokenType) String() string {
 		"RETURN",
 		"SUPER",
 		"THIS",
+		"THROW",
 		"TRUE",
+		"TRY",
 		"VAR",
 		"WHILE",
 
@@ -113,9 +134,20 @@ type (
 		String() string
 		Lexeme() string
 		Literal() any
+		// Line and Position are the line and the 1-based column of the first character of the token
 		Line() int
 		Position() int
 		Type() TokenType
+		// Start and End are the byte offsets in the source of the first byte of the token and of the byte after it
+		Start() int
+		End() int
+		// EndLine and EndColumn are the line and the column right after the last character of the token,
+		// they only differ from Line and Position plus the length of the token for strings spanning several lines
+		EndLine() int
+		EndColumn() int
+		// Source is the program the token was scanned from, Start and End are offsets in it.
+		// It is empty for tokens that weren't scanned.
+		Source() string
 	}
 
 	token struct {
@@ -123,17 +155,27 @@ type (
 		tokenType TokenType
 		line      int
 		position  int
+		offset    int
 		literal   any
+		source    string
 	}
 )
 
 func NewToken(tType TokenType, lexeme string, literal any, line int, position int) Token {
+	return NewSourceToken(tType, lexeme, literal, line, position, 0, "")
+}
+
+// NewSourceToken is like NewToken for a token scanned from source, offset is the byte offset of its lexeme in it.
+// The token keeps source for as long as it is reachable.
+func NewSourceToken(tType TokenType, lexeme string, literal any, line int, position int, offset int, source string) Token {
 	return &token{
 		tokenType: tType,
 		lexeme:    lexeme,
 		literal:   literal,
 		line:      line,
 		position:  position,
+		offset:    offset,
+		source:    source,
 	}
 }
 
@@ -160,3 +202,26 @@ func (t *token) Line() int {
 func (t *token) Position() int {
 	return t.position
 }
+
+func (t *token) Start() int {
+	return t.offset
+}
+
+func (t *token) End() int {
+	return t.offset + len(t.lexeme)
+}
+
+func (t *token) EndLine() int {
+	return t.line + strings.Count(t.lexeme, "\n")
+}
+
+func (t *token) EndColumn() int {
+	if last := strings.LastIndexByte(t.lexeme, '\n'); last >= 0 {
+		return len(t.lexeme) - last
+	}
+	return t.position + len(t.lexeme)
+}
+
+func (t *token) Source() string {
+	return t.source
+}
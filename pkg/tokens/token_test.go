@@ -26,6 +26,18 @@ func TestTokenTypeToString(t *testing.T) {
 			tType: RightBrace,
 			str:   "RIGHT_BRACE",
 		},
+		{
+			tType: LeftBracket,
+			str:   "LEFT_BRACKET",
+		},
+		{
+			tType: RightBracket,
+			str:   "RIGHT_BRACKET",
+		},
+		{
+			tType: Colon,
+			str:   "COLON",
+		},
 		{
 			tType: Comma,
 			str:   "COMMA",
@@ -106,10 +118,22 @@ func TestTokenTypeToString(t *testing.T) {
 			tType: And,
 			str:   "AND",
 		},
+		{
+			tType: Break,
+			str:   "BREAK",
+		},
+		{
+			tType: Catch,
+			str:   "CATCH",
+		},
 		{
 			tType: Class,
 			str:   "CLASS",
 		},
+		{
+			tType: Continue,
+			str:   "CONTINUE",
+		},
 		{
 			tType: Else,
 			str:   "ELSE",
@@ -118,6 +142,10 @@ func TestTokenTypeToString(t *testing.T) {
 			tType: False,
 			str:   "FALSE",
 		},
+		{
+			tType: Finally,
+			str:   "FINALLY",
+		},
 		{
 			tType: Fun,
 			str:   "FUN",
@@ -154,10 +182,18 @@ func TestTokenTypeToString(t *testing.T) {
 			tType: This,
 			str:   "THIS",
 		},
+		{
+			tType: Throw,
+			str:   "THROW",
+		},
 		{
 			tType: True,
 			str:   "TRUE",
 		},
+		{
+			tType: Try,
+			str:   "TRY",
+		},
 		{
 			tType: Var,
 			str:   "VAR",
@@ -205,3 +241,21 @@ func TestToken_Line(t *testing.T) {
 func TestToken_Position(t *testing.T) {
 	assert.Equal(t, 13, testToken.Position())
 }
+
+func TestToken_Span(t *testing.T) {
+	code := "var pi = 3.14;"
+	token := NewSourceToken(Number, "3.14", 3.14, 1, 10, 9, code)
+	assert.Equal(t, 9, token.Start())
+	assert.Equal(t, 13, token.End())
+	assert.Equal(t, 1, token.EndLine())
+	assert.Equal(t, 14, token.EndColumn())
+	assert.Equal(t, code, token.Source())
+
+	multiline := NewSourceToken(String, "\"ab\ncd\"", "ab\ncd", 3, 5, 20, "")
+	assert.Equal(t, 27, multiline.End())
+	assert.Equal(t, 4, multiline.EndLine())
+	assert.Equal(t, 4, multiline.EndColumn())
+
+	assert.Equal(t, 0, testToken.Start())
+	assert.Empty(t, testToken.Source())
+}
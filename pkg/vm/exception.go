@@ -0,0 +1,129 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type (
+	// handler is an exception handler installed by a try statement
+	handler struct {
+		// frame is the index of the call frame that installed the handler
+		frame int
+		// sp is the stack depth the handler unwinds the stack to
+		sp int
+		// ip is the first instruction of the catch clause or of the finally block
+		ip int
+		// finally handlers receive the exception itself instead of the thrown value, so they can throw it again
+		finally bool
+	}
+
+	// thrownValue is the error of a throw statement, it holds the thrown value until a handler catches it
+	thrownValue struct {
+		value Value
+	}
+)
+
+func (e *objError) String() string {
+	return e.message
+}
+
+func (e *objException) String() string {
+	return "exception"
+}
+
+// Error is the message of an uncaught exception, a thrown error keeps its original message
+func (t *thrownValue) Error() string {
+	return t.value.String()
+}
+
+// throw returns the error that unwinds the VM with value. An error caught earlier keeps the line it was first reported at
+// when it is thrown again, and an exception a finally block throws again is the one it caught.
+func (vm *vm) throw(value Value) error {
+	switch o := value.object.(type) {
+	case *objException:
+		return o.err
+	case *objError:
+		return &RuntimeError{Line: o.line, Span: o.span, Source: o.source, err: &thrownValue{value: value}}
+	}
+	return vm.runtimeError("%w", &thrownValue{value: value})
+}
+
+// catch hands err to the innermost exception handler and reports whether there was one that can catch it.
+// The limits set by the host can't be caught, a script must not escape them, so no catch clause nor finally block runs.
+func (vm *vm) catch(err error) bool {
+	var runtimeErr *RuntimeError
+	if len(vm.handlers) == 0 || !errors.As(err, &runtimeErr) {
+		return false
+	}
+	if errors.Is(err, ErrStepLimitExceeded) || errors.Is(err, ErrCallDepthExceeded) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	if h.finally {
+		// the finally block may throw the exception again after the frames it came from are gone
+		vm.unwind(runtimeErr, h.frame)
+	}
+	vm.closeUpvalues(h.sp)
+	vm.frameCount = h.frame + 1
+	vm.frames[h.frame].ip = h.ip
+	vm.sp = h.sp
+
+	// the thrown value is pushed before anything is allocated, so it stays reachable
+	var thrown *thrownValue
+	if errors.As(err, &thrown) {
+		vm.push(thrown.value)
+	} else {
+		vm.push(Nil)
+		if !h.finally {
+			vm.stack[vm.sp-1] = NewObject(vm.newError(runtimeErr.err.Error(), runtimeErr.Line, runtimeErr.Span, runtimeErr.Source))
+		}
+	}
+	if h.finally {
+		vm.stack[vm.sp-1] = NewObject(vm.newException(runtimeErr, vm.peek(0)))
+	}
+	return true
+}
+
+// errorProperty reads the message, the line and the position of an error caught by a catch clause,
+// the position is nil when the instruction that failed has no span
+func (vm *vm) errorProperty(e *objError, name *objString) (Value, error) {
+	switch name.chars {
+	case "message":
+		return NewObject(vm.internString(e.message)), nil
+	case "line":
+		return NewNumber(float64(e.line)), nil
+	case "position":
+		if e.span.Column == 0 {
+			return Nil, nil
+		}
+		return NewNumber(float64(e.span.Column)), nil
+	}
+	return Nil, fmt.Errorf("undefined property '%s'", name.chars)
+}
+
+// unwind adds the call frames from the innermost one not yet in the stack trace of err down to frame to the trace,
+// before they are discarded
+func (vm *vm) unwind(err *RuntimeError, frame int) {
+	top := vm.frameCount - 1
+	if err.Trace != nil {
+		top = err.unwound - 1
+	}
+	for f := top; f >= frame; f-- {
+		function := vm.frames[f].closure.function
+		name := "script"
+		if function.name != nil {
+			name = function.name.chars
+		}
+		line := -1
+		if ip := vm.frames[f].ip; ip > 0 {
+			line = function.chunk.Lines[ip-1]
+		}
+		err.Trace = append(err.Trace, StackFrame{Function: name, Line: line})
+	}
+	err.unwound = frame
+}
@@ -0,0 +1,335 @@
+package vm
+
+import "github.com/mtvarkovsky/golox/pkg/compiler"
+
+type (
+	GCConfig struct {
+		// InitialHeap is the number of bytes allocated before the first collection, DefaultInitialHeap is used when it is 0
+		InitialHeap int
+		// GrowthFactor sets the next collection threshold to the live heap size times the factor,
+		// DefaultGrowthFactor is used when it is lower than 2
+		GrowthFactor int
+		// Stress runs a collection before every allocation, it is slow and meant for tests
+		Stress bool
+	}
+
+	// GCStats describes the VM heap and the work done by the collector so far
+	GCStats struct {
+		Collections      int
+		ObjectsAllocated int
+		ObjectsFreed     int
+		// HeapObjects and HeapBytes describe the objects alive after the last collection and allocated since
+		HeapObjects int
+		HeapBytes   int
+		// NextCollection is the heap size in bytes that triggers the next collection
+		NextCollection int
+	}
+)
+
+const (
+	DefaultInitialHeap  = 1 << 20
+	DefaultGrowthFactor = 2
+)
+
+// Approximate sizes of the objects, they only need to be proportional to the memory the objects hold
+const (
+	stringSize      = 32
+	functionSize    = 80
+	closureSize     = 48
+	upvalueSize     = 56
+	nativeSize      = 56
+	classSize       = 48
+	instanceSize    = 48
+	boundMethodSize = 56
+	listSize        = 48
+	mapSize         = 56
+	rangeSize       = 48
+	iteratorSize    = 80
+	errorSize       = 88
+	exceptionSize   = 56
+	valueSize       = 40
+	entrySize       = 48
+	pointerSize     = 8
+)
+
+// allocate adds o to the heap, collecting garbage first when the heap has outgrown its threshold.
+// Everything o refers to must already be reachable from the roots, o itself isn't traced until it is allocated.
+func (vm *vm) allocate(o Object, size int) {
+	vm.collectIfNeeded(size)
+
+	h := o.header()
+	h.size = size
+	h.nextObject = vm.objects
+	vm.objects = o
+	vm.bytesAllocated += size
+	vm.heapObjects++
+	vm.stats.ObjectsAllocated++
+}
+
+// grow accounts for memory an object gained after it was allocated, like a new field or method.
+// o must be reachable from the roots.
+func (vm *vm) grow(o Object, size int) {
+	vm.collectIfNeeded(size)
+
+	o.header().size += size
+	vm.bytesAllocated += size
+}
+
+func (vm *vm) collectIfNeeded(size int) {
+	if vm.loading {
+		return
+	}
+	if vm.config.GC.Stress || vm.bytesAllocated+size > vm.nextGC {
+		vm.collectGarbage()
+	}
+}
+
+// collectGarbage is a tri-color mark and sweep collector.
+// White objects are unmarked, gray objects are marked and wait in grayStack to have their references marked,
+// black objects are marked and no longer in grayStack. Once grayStack is empty the white objects are unreachable.
+func (vm *vm) collectGarbage() {
+	vm.markRoots()
+	for len(vm.grayStack) > 0 {
+		o := vm.grayStack[len(vm.grayStack)-1]
+		vm.grayStack = vm.grayStack[:len(vm.grayStack)-1]
+		vm.blacken(o)
+	}
+
+	// the string table doesn't keep strings alive, unreachable strings are dropped before they are swept
+	for chars, s := range vm.strings {
+		if !s.marked {
+			delete(vm.strings, chars)
+		}
+	}
+	vm.sweep()
+
+	vm.nextGC = vm.bytesAllocated * vm.config.GC.GrowthFactor
+	if vm.nextGC < vm.config.GC.InitialHeap {
+		vm.nextGC = vm.config.GC.InitialHeap
+	}
+	vm.stats.Collections++
+}
+
+func (vm *vm) markRoots() {
+	for _, value := range vm.stack[:vm.sp] {
+		vm.markValue(value)
+	}
+	for i := 0; i < vm.frameCount; i++ {
+		vm.markObject(vm.frames[i].closure)
+	}
+	for upvalue := vm.openUpvalues; upvalue != nil; upvalue = upvalue.next {
+		vm.markObject(upvalue)
+	}
+	for name, value := range vm.globals {
+		vm.markObject(name)
+		vm.markValue(value)
+	}
+	// the names are nil while NewVM interns them
+	for _, name := range []*objString{vm.initString, vm.iteratorString, vm.hasNextString, vm.nextString} {
+		if name != nil {
+			vm.markObject(name)
+		}
+	}
+}
+
+func (vm *vm) markValue(value Value) {
+	if value.vType == ObjectType {
+		vm.markObject(value.object)
+	}
+}
+
+func (vm *vm) markObject(o Object) {
+	if o == nil {
+		return
+	}
+	h := o.header()
+	if h.marked {
+		return
+	}
+	h.marked = true
+	vm.grayStack = append(vm.grayStack, o)
+}
+
+// blacken marks every object o refers to
+func (vm *vm) blacken(o Object) {
+	switch o := o.(type) {
+	case *objFunction:
+		if o.name != nil {
+			vm.markObject(o.name)
+		}
+		for _, constant := range o.constants {
+			vm.markValue(constant)
+		}
+	case *objClosure:
+		vm.markObject(o.function)
+		for _, upvalue := range o.upvalues {
+			if upvalue != nil {
+				vm.markObject(upvalue)
+			}
+		}
+	case *objUpvalue:
+		vm.markValue(o.closed)
+	case *objClass:
+		vm.markObject(o.name)
+		for name, method := range o.methods {
+			vm.markObject(name)
+			vm.markObject(method)
+		}
+	case *objInstance:
+		vm.markObject(o.class)
+		for name, value := range o.fields {
+			vm.markObject(name)
+			vm.markValue(value)
+		}
+	case *objBoundMethod:
+		vm.markValue(o.receiver)
+		vm.markObject(o.method)
+	case *objList:
+		for _, element := range o.elements {
+			vm.markValue(element)
+		}
+	case *objMap:
+		for _, entry := range o.entries {
+			vm.markValue(entry.key)
+			vm.markValue(entry.value)
+		}
+	case *objIterator:
+		vm.markValue(o.source)
+		for _, key := range o.keys {
+			vm.markValue(key)
+		}
+	case *objException:
+		vm.markValue(o.value)
+	}
+}
+
+// sweep unlinks the unmarked objects from the heap, leaving them to the Go runtime, and unmarks the rest
+func (vm *vm) sweep() {
+	var previous Object
+	o := vm.objects
+	for o != nil {
+		h := o.header()
+		if h.marked {
+			h.marked = false
+			previous = o
+			o = h.nextObject
+			continue
+		}
+
+		o = h.nextObject
+		if previous == nil {
+			vm.objects = o
+		} else {
+			previous.header().nextObject = o
+		}
+		h.nextObject = nil
+		vm.bytesAllocated -= h.size
+		vm.heapObjects--
+		vm.stats.ObjectsFreed++
+	}
+}
+
+// CollectGarbage runs a full collection right away
+func (vm *vm) CollectGarbage() {
+	vm.collectGarbage()
+}
+
+func (vm *vm) GCStats() GCStats {
+	stats := vm.stats
+	stats.HeapObjects = vm.heapObjects
+	stats.HeapBytes = vm.bytesAllocated
+	stats.NextCollection = vm.nextGC
+	return stats
+}
+
+func (vm *vm) newString(chars string) *objString {
+	s := &objString{chars: chars}
+	vm.allocate(s, stringSize+len(chars))
+	return s
+}
+
+func (vm *vm) newFunction(arity int, upvalueCount int, chunk *compiler.Chunk) *objFunction {
+	function := &objFunction{
+		arity:        arity,
+		upvalueCount: upvalueCount,
+		chunk:        chunk,
+		constants:    make([]Value, len(chunk.Constants)),
+	}
+	vm.allocate(function, functionSize+valueSize*len(chunk.Constants))
+	return function
+}
+
+func (vm *vm) newClosure(function *objFunction) *objClosure {
+	closure := &objClosure{function: function, upvalues: make([]*objUpvalue, function.upvalueCount)}
+	vm.allocate(closure, closureSize+pointerSize*function.upvalueCount)
+	return closure
+}
+
+func (vm *vm) newUpvalue(location int, next *objUpvalue) *objUpvalue {
+	upvalue := &objUpvalue{location: location, open: true, next: next}
+	vm.allocate(upvalue, upvalueSize)
+	return upvalue
+}
+
+func (vm *vm) newNative(name string, arity int, fn func(arguments []Value) (Value, error)) *objNative {
+	native := &objNative{name: name, arity: arity, fn: fn}
+	vm.allocate(native, nativeSize)
+	return native
+}
+
+func (vm *vm) newClass(name *objString) *objClass {
+	class := &objClass{name: name, methods: make(map[*objString]*objClosure)}
+	vm.allocate(class, classSize)
+	return class
+}
+
+func (vm *vm) newInstance(class *objClass) *objInstance {
+	instance := &objInstance{class: class, fields: make(map[*objString]Value)}
+	vm.allocate(instance, instanceSize)
+	return instance
+}
+
+func (vm *vm) newBoundMethod(receiver Value, method *objClosure) *objBoundMethod {
+	bound := &objBoundMethod{receiver: receiver, method: method}
+	vm.allocate(bound, boundMethodSize)
+	return bound
+}
+
+// newList takes ownership of elements, which must be reachable from the roots while the list is allocated
+func (vm *vm) newList(elements []Value) *objList {
+	list := &objList{elements: elements}
+	vm.allocate(list, listSize+valueSize*len(elements))
+	return list
+}
+
+func (vm *vm) newMap() *objMap {
+	m := &objMap{index: make(map[Value]int)}
+	vm.allocate(m, mapSize)
+	return m
+}
+
+func (vm *vm) newRange(start float64, end float64, step float64) *objRange {
+	r := &objRange{start: start, end: end, step: step}
+	vm.allocate(r, rangeSize)
+	return r
+}
+
+// newIterator takes ownership of keys, which must be reachable from the roots while the iterator is allocated
+func (vm *vm) newIterator(source Value, keys []Value) *objIterator {
+	it := &objIterator{source: source, keys: keys}
+	vm.allocate(it, iteratorSize+valueSize*len(keys))
+	return it
+}
+
+func (vm *vm) newError(message string, line int, span compiler.Span, source string) *objError {
+	e := &objError{message: message, line: line, span: span, source: source}
+	vm.allocate(e, errorSize+len(message))
+	return e
+}
+
+// newException keeps value, the thrown value of err, reachable for as long as the exception is
+func (vm *vm) newException(err *RuntimeError, value Value) *objException {
+	e := &objException{err: err, value: value}
+	vm.allocate(e, exceptionSize)
+	return e
+}
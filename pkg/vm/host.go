@@ -0,0 +1,108 @@
+package vm
+
+import "fmt"
+
+// NativeFunction is a Go function exposed to Lox code.
+// It receives the arguments as Lox values: nil, bool, float64, string or an opaque VM object.
+type NativeFunction func(arguments []any) (any, error)
+
+// DefineNative registers a Go function as a global Lox function.
+// A negative arity accepts any number of arguments.
+// Errors returned by fn are reported as runtime errors at the call site.
+func (vm *vm) DefineNative(name string, arity int, fn NativeFunction) {
+	vm.defineNative(name, arity, func(arguments []Value) (Value, error) {
+		hostArguments := make([]any, 0, len(arguments))
+		for _, argument := range arguments {
+			hostArguments = append(hostArguments, hostValue(argument))
+		}
+		result, err := fn(hostArguments)
+		if err != nil {
+			return Nil, err
+		}
+		return vm.newValue(result)
+	})
+}
+
+// GetGlobal returns the current value of a global variable, see DefineNative for the Go types of Lox values.
+// Objects stay valid for as long as the scripts can reach them, the garbage collector doesn't know about Go references.
+func (vm *vm) GetGlobal(name string) (any, error) {
+	// a name that was never interned can't be a global
+	key, found := vm.strings[name]
+	value, defined := vm.globals[key]
+	if !found || !defined {
+		return nil, fmt.Errorf("undefined variable '%s'", name)
+	}
+	return hostValue(value), nil
+}
+
+// SetGlobal defines a global variable or overwrites its current value.
+// Go numbers of any type are converted to Lox numbers.
+func (vm *vm) SetGlobal(name string, value any) error {
+	// the name is kept on the stack while the value is allocated
+	vm.push(NewObject(vm.internString(name)))
+	v, err := vm.newValue(value)
+	key := vm.pop().object.(*objString)
+	if err != nil {
+		return err
+	}
+	vm.globals[key] = v
+	return nil
+}
+
+// hostValue converts a Lox value to the Go value passed to native functions
+func hostValue(value Value) any {
+	switch value.vType {
+	case NilType:
+		return nil
+	case BoolType:
+		return value.boolean
+	case NumberType:
+		return value.number
+	}
+	if s, ok := value.object.(*objString); ok {
+		return s.chars
+	}
+	return value.object
+}
+
+// newValue converts a Go value to a Lox value, strings are interned on the VM heap
+func (vm *vm) newValue(value any) (Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return Nil, nil
+	case Value:
+		return v, nil
+	case bool:
+		return NewBool(v), nil
+	case string:
+		return NewObject(vm.internString(v)), nil
+	case float64:
+		return NewNumber(v), nil
+	case float32:
+		return NewNumber(float64(v)), nil
+	case int:
+		return NewNumber(float64(v)), nil
+	case int8:
+		return NewNumber(float64(v)), nil
+	case int16:
+		return NewNumber(float64(v)), nil
+	case int32:
+		return NewNumber(float64(v)), nil
+	case int64:
+		return NewNumber(float64(v)), nil
+	case uint:
+		return NewNumber(float64(v)), nil
+	case uint8:
+		return NewNumber(float64(v)), nil
+	case uint16:
+		return NewNumber(float64(v)), nil
+	case uint32:
+		return NewNumber(float64(v)), nil
+	case uint64:
+		return NewNumber(float64(v)), nil
+	case Object:
+		return NewObject(v), nil
+	}
+
+	return Nil, fmt.Errorf("unsupported value type %T", value)
+}
@@ -0,0 +1,154 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+func (r *objRange) String() string {
+	return fmt.Sprintf("range(%s, %s, %s)", formatNumber(r.start), formatNumber(r.end), formatNumber(r.step))
+}
+
+// at returns the number at position, ok is false when the range ends before it.
+// The numbers are computed from the start instead of adding up steps, so fractional steps don't drift.
+func (r *objRange) at(position int) (float64, bool) {
+	value := r.start + float64(position)*r.step
+	if r.step > 0 && value >= r.end || r.step < 0 && value <= r.end {
+		return 0, false
+	}
+	return value, true
+}
+
+func (it *objIterator) String() string {
+	return "iterator"
+}
+
+// iterator replaces the iterable on top of the stack with the iterator a for-in loop uses.
+// Lists, maps, strings and ranges get a built-in iterator. Any other instance is its own iterator
+// unless it has an iterator() method, which is invoked so its result replaces the instance.
+func (vm *vm) iterator() error {
+	iterable := vm.peek(0)
+	switch o := iterable.object.(type) {
+	case *objList, *objString, *objRange:
+		vm.stack[vm.sp-1] = NewObject(vm.newIterator(iterable, nil))
+		return nil
+	case *objMap:
+		keys := make([]Value, 0, len(o.index))
+		for _, entry := range o.entries {
+			if !entry.deleted {
+				keys = append(keys, entry.key)
+			}
+		}
+		// the keys stay reachable through the map on the stack while the iterator is allocated
+		vm.stack[vm.sp-1] = NewObject(vm.newIterator(iterable, keys))
+		return nil
+	case *objInstance:
+		if _, found := o.fields[vm.iteratorString]; found {
+			return vm.invoke(vm.iteratorString, 0)
+		}
+		if _, found := o.class.methods[vm.iteratorString]; found {
+			return vm.invoke(vm.iteratorString, 0)
+		}
+		return nil
+	}
+	return vm.runtimeError("%s is not iterable", typeName(iterable))
+}
+
+// invokeIterator answers the hasNext and next methods of a built-in iterator, the receiver is replaced by the result
+func (vm *vm) invokeIterator(it *objIterator, name *objString, argCount int) error {
+	if name != vm.hasNextString && name != vm.nextString {
+		return vm.runtimeError("undefined property '%s'", name.chars)
+	}
+	if argCount != 0 {
+		return vm.runtimeError("expected 0 arguments but got %d", argCount)
+	}
+
+	hasNext := name == vm.hasNextString
+	result := Nil
+	switch source := it.source.object.(type) {
+	case *objList:
+		if hasNext {
+			result = NewBool(it.position < len(source.elements))
+		} else if it.position < len(source.elements) {
+			result = source.elements[it.position]
+			it.position++
+		}
+	case *objMap:
+		if hasNext {
+			result = NewBool(it.position < len(it.keys))
+		} else if it.position < len(it.keys) {
+			result = it.keys[it.position]
+			it.position++
+		}
+	case *objString:
+		if hasNext {
+			result = NewBool(it.position < len(source.chars))
+		} else if it.position < len(source.chars) {
+			_, size := utf8.DecodeRuneInString(source.chars[it.position:])
+			// the iterator is still on the stack while the character is interned
+			result = NewObject(vm.internString(source.chars[it.position : it.position+size]))
+			it.position += size
+		}
+	case *objRange:
+		value, ok := source.at(it.position)
+		if hasNext {
+			result = NewBool(ok)
+		} else if ok {
+			result = NewNumber(value)
+			it.position++
+		}
+	}
+	vm.stack[vm.sp-1] = result
+	return nil
+}
+
+// nativeRange creates the range from a start number up to an end number, excluded.
+// The optional third argument is the step between the numbers, it is 1 by default and may be negative.
+func (vm *vm) nativeRange(arguments []Value) (Value, error) {
+	if len(arguments) != 2 && len(arguments) != 3 {
+		return Nil, fmt.Errorf("range expects 2 or 3 arguments but got %d", len(arguments))
+	}
+	step := NewNumber(1)
+	if len(arguments) == 3 {
+		step = arguments[2]
+	}
+	for _, argument := range []Value{arguments[0], arguments[1], step} {
+		if argument.vType != NumberType || math.IsNaN(argument.number) {
+			return Nil, fmt.Errorf("range expects numbers")
+		}
+	}
+	if step.number == 0 || math.IsInf(step.number, 0) {
+		return Nil, fmt.Errorf("range step must be finite and not 0")
+	}
+	return NewObject(vm.newRange(arguments[0].number, arguments[1].number, step.number)), nil
+}
+
+// typeName names the type of a value in error messages, the names are the ones the tree-walking interpreter uses
+func typeName(value Value) string {
+	switch value.vType {
+	case NilType:
+		return "nil"
+	case BoolType:
+		return "boolean"
+	case NumberType:
+		return "number"
+	}
+	switch value.object.(type) {
+	case *objString:
+		return "string"
+	case *objClass:
+		return "class"
+	case *objInstance:
+		return "instance"
+	case *objList:
+		return "list"
+	case *objMap:
+		return "map"
+	case *objRange:
+		return "range"
+	case *objError:
+		return "error"
+	}
+	return "function"
+}
@@ -0,0 +1,180 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+func (l *objList) String() string {
+	return l.format(make(map[Object]bool))
+}
+
+// format prints the list the same way the tree-walking interpreter does, with its string elements quoted.
+// seen holds the lists and maps being printed, a list that contains itself is printed as [...] the second time.
+func (l *objList) format(seen map[Object]bool) string {
+	if seen[l] {
+		return "[...]"
+	}
+	seen[l] = true
+	defer delete(seen, l)
+
+	b := strings.Builder{}
+	b.WriteString("[")
+	for i, element := range l.elements {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(formatElement(element, seen))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// formatElement prints a value inside a list or a map, strings are quoted so they can be told apart from other values
+func formatElement(value Value, seen map[Object]bool) string {
+	switch o := value.object.(type) {
+	case *objString:
+		return `"` + o.chars + `"`
+	case *objList:
+		return o.format(seen)
+	case *objMap:
+		return o.format(seen)
+	}
+	return value.String()
+}
+
+// listIndex checks that index is an integer between 0 and length, excluded, and converts it
+func listIndex(index Value, length int) (int, error) {
+	if index.vType != NumberType || index.number != math.Trunc(index.number) || math.IsInf(index.number, 0) {
+		return 0, fmt.Errorf("list index must be an integer")
+	}
+	if index.number < 0 {
+		return 0, fmt.Errorf("list index %s is negative", formatNumber(index.number))
+	}
+	if index.number >= float64(length) {
+		return 0, fmt.Errorf("list index %s is out of range for a list of length %d", formatNumber(index.number), length)
+	}
+	return int(index.number), nil
+}
+
+// defineListNatives adds the global functions that work with lists, they are bound to the VM so they can allocate
+func (vm *vm) defineListNatives() {
+	vm.defineNative("len", 1, nativeLen)
+	vm.defineNative("push", 2, vm.nativePush)
+	vm.defineNative("pop", 1, nativePop)
+	vm.defineNative("insert", 3, vm.nativeInsert)
+	vm.defineNative("slice", 3, vm.nativeSlice)
+	vm.defineNative("contains", 2, nativeContains)
+}
+
+// appendElements adds values to the end of a list and accounts for the memory the list gains
+func (vm *vm) appendElements(list *objList, values ...Value) {
+	capacity := cap(list.elements)
+	list.elements = append(list.elements, values...)
+	if grown := cap(list.elements) - capacity; grown > 0 {
+		vm.grow(list, valueSize*grown)
+	}
+}
+
+// listArgument returns the first argument of the native function name, which must be a list
+func listArgument(name string, arguments []Value) (*objList, error) {
+	list, ok := arguments[0].object.(*objList)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a list", name)
+	}
+	return list, nil
+}
+
+// nativeLen returns the number of elements of a list, the number of entries of a map or the number of characters of a string
+func nativeLen(arguments []Value) (Value, error) {
+	switch o := arguments[0].object.(type) {
+	case *objList:
+		return NewNumber(float64(len(o.elements))), nil
+	case *objMap:
+		return NewNumber(float64(len(o.index))), nil
+	case *objString:
+		return NewNumber(float64(utf8.RuneCountInString(o.chars))), nil
+	}
+	return Nil, fmt.Errorf("len expects a list, a map or a string")
+}
+
+// nativePush appends a value to the end of a list
+func (vm *vm) nativePush(arguments []Value) (Value, error) {
+	list, err := listArgument("push", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	vm.appendElements(list, arguments[1])
+	return Nil, nil
+}
+
+// nativePop removes the last element of a list and returns it
+func nativePop(arguments []Value) (Value, error) {
+	list, err := listArgument("pop", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	if len(list.elements) == 0 {
+		return Nil, fmt.Errorf("can't pop from an empty list")
+	}
+	last := list.elements[len(list.elements)-1]
+	list.elements[len(list.elements)-1] = Nil
+	list.elements = list.elements[:len(list.elements)-1]
+	return last, nil
+}
+
+// nativeInsert inserts a value before the element at an index, the index may be the length of the list to append
+func (vm *vm) nativeInsert(arguments []Value) (Value, error) {
+	list, err := listArgument("insert", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	i, err := listIndex(arguments[1], len(list.elements)+1)
+	if err != nil {
+		return Nil, err
+	}
+	vm.appendElements(list, Nil)
+	copy(list.elements[i+1:], list.elements[i:])
+	list.elements[i] = arguments[2]
+	return Nil, nil
+}
+
+// nativeSlice returns a new list with the elements from a start index up to an end index, excluded
+func (vm *vm) nativeSlice(arguments []Value) (Value, error) {
+	list, err := listArgument("slice", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	// both bounds may be the length of the list
+	start, err := listIndex(arguments[1], len(list.elements)+1)
+	if err != nil {
+		return Nil, err
+	}
+	end, err := listIndex(arguments[2], len(list.elements)+1)
+	if err != nil {
+		return Nil, err
+	}
+	if start > end {
+		return Nil, fmt.Errorf("slice start %d is after its end %d", start, end)
+	}
+	// the copied elements stay reachable through the list argument on the stack while the new list is allocated
+	elements := make([]Value, end-start)
+	copy(elements, list.elements[start:end])
+	return NewObject(vm.newList(elements)), nil
+}
+
+// nativeContains reports whether a list has an element equal to a value
+func nativeContains(arguments []Value) (Value, error) {
+	list, err := listArgument("contains", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	for _, element := range list.elements {
+		if valuesEqual(element, arguments[1]) {
+			return NewBool(true), nil
+		}
+	}
+	return NewBool(false), nil
+}
@@ -0,0 +1,192 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+func (m *objMap) String() string {
+	return m.format(make(map[Object]bool))
+}
+
+// format prints the entries the same way the tree-walking interpreter does, in insertion order with strings quoted.
+// seen holds the lists and maps being printed, a map that contains itself is printed as {...} the second time.
+func (m *objMap) format(seen map[Object]bool) string {
+	if seen[m] {
+		return "{...}"
+	}
+	seen[m] = true
+	defer delete(seen, m)
+
+	b := strings.Builder{}
+	b.WriteString("{")
+	first := true
+	for _, entry := range m.entries {
+		if entry.deleted {
+			continue
+		}
+		if !first {
+			b.WriteString(", ")
+		}
+		first = false
+		b.WriteString(formatElement(entry.key, seen))
+		b.WriteString(": ")
+		b.WriteString(formatElement(entry.value, seen))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// mapKey checks that a value can be used as a map key and returns the value the map is indexed by.
+// Lists and maps can change after they are inserted, and NaN isn't equal to itself, so none of them can be found again.
+// Zero and negative zero are equal so they share a key, every other value is its own key since strings are interned.
+func mapKey(key Value) (Value, error) {
+	switch key.object.(type) {
+	case *objList:
+		return Nil, fmt.Errorf("list can't be a map key")
+	case *objMap:
+		return Nil, fmt.Errorf("map can't be a map key")
+	}
+	if key.vType == NumberType {
+		if math.IsNaN(key.number) {
+			return Nil, fmt.Errorf("NaN can't be a map key")
+		}
+		if key.number == 0 {
+			key.number = 0
+		}
+	}
+	return key, nil
+}
+
+// mapGet reads the value of a key, reading a missing key is an error
+func mapGet(m *objMap, key Value) (Value, error) {
+	k, err := mapKey(key)
+	if err != nil {
+		return Nil, err
+	}
+	i, found := m.index[k]
+	if !found {
+		return Nil, fmt.Errorf("key %s is not in the map", formatElement(key, make(map[Object]bool)))
+	}
+	return m.entries[i].value, nil
+}
+
+// mapSet adds a key at the end of the map or updates its value, updating a key doesn't move it.
+// m, key and value must be reachable from the roots since a new entry may trigger a collection.
+func (vm *vm) mapSet(m *objMap, key Value, value Value) error {
+	k, err := mapKey(key)
+	if err != nil {
+		return err
+	}
+	if i, found := m.index[k]; found {
+		m.entries[i].value = value
+		return nil
+	}
+	vm.grow(m, entrySize)
+	m.index[k] = len(m.entries)
+	m.entries = append(m.entries, mapEntry{key: key, value: value})
+	return nil
+}
+
+// mapDelete removes a key and reports whether it was in the map
+func mapDelete(m *objMap, key Value) (bool, error) {
+	k, err := mapKey(key)
+	if err != nil {
+		return false, err
+	}
+	i, found := m.index[k]
+	if !found {
+		return false, nil
+	}
+	delete(m.index, k)
+	m.entries[i] = mapEntry{deleted: true}
+	// the holes are dropped once they are the majority, which keeps deletion cheap and iteration proportional to the size
+	if len(m.index) < len(m.entries)/2 {
+		entries := make([]mapEntry, 0, len(m.index))
+		for _, entry := range m.entries {
+			if !entry.deleted {
+				k, _ = mapKey(entry.key)
+				m.index[k] = len(entries)
+				entries = append(entries, entry)
+			}
+		}
+		m.entries = entries
+	}
+	return true, nil
+}
+
+// defineMapNatives adds the global functions that work with maps, len works with maps too
+func (vm *vm) defineMapNatives() {
+	vm.defineNative("keys", 1, vm.nativeKeys)
+	vm.defineNative("values", 1, vm.nativeValues)
+	vm.defineNative("has", 2, nativeHas)
+	vm.defineNative("delete", 2, nativeDelete)
+}
+
+// mapArgument returns the first argument of the native function name, which must be a map
+func mapArgument(name string, arguments []Value) (*objMap, error) {
+	m, ok := arguments[0].object.(*objMap)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a map", name)
+	}
+	return m, nil
+}
+
+// nativeKeys returns a new list with the keys of a map in insertion order
+func (vm *vm) nativeKeys(arguments []Value) (Value, error) {
+	m, err := mapArgument("keys", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	keys := make([]Value, 0, len(m.index))
+	for _, entry := range m.entries {
+		if !entry.deleted {
+			keys = append(keys, entry.key)
+		}
+	}
+	// the keys stay reachable through the map argument on the stack while the list is allocated
+	return NewObject(vm.newList(keys)), nil
+}
+
+// nativeValues returns a new list with the values of a map in the insertion order of their keys
+func (vm *vm) nativeValues(arguments []Value) (Value, error) {
+	m, err := mapArgument("values", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	values := make([]Value, 0, len(m.index))
+	for _, entry := range m.entries {
+		if !entry.deleted {
+			values = append(values, entry.value)
+		}
+	}
+	return NewObject(vm.newList(values)), nil
+}
+
+// nativeHas reports whether a map has a key
+func nativeHas(arguments []Value) (Value, error) {
+	m, err := mapArgument("has", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	k, err := mapKey(arguments[1])
+	if err != nil {
+		return Nil, err
+	}
+	_, found := m.index[k]
+	return NewBool(found), nil
+}
+
+// nativeDelete removes a key from a map and reports whether it was there
+func nativeDelete(arguments []Value) (Value, error) {
+	m, err := mapArgument("delete", arguments)
+	if err != nil {
+		return Nil, err
+	}
+	found, err := mapDelete(m, arguments[1])
+	if err != nil {
+		return Nil, err
+	}
+	return NewBool(found), nil
+}
@@ -0,0 +1,176 @@
+package vm
+
+import (
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/compiler"
+)
+
+type (
+	// Object is a value that lives on the VM heap, every object is created through the VM so the collector can trace it
+	Object interface {
+		String() string
+		header() *objHeader
+	}
+
+	// objHeader is embedded in every object, it links the object into the heap
+	objHeader struct {
+		marked bool
+		// size is the number of bytes the object accounts for in the heap statistics
+		size int
+		// nextObject links all the objects allocated by the VM
+		nextObject Object
+	}
+
+	// objString is interned, two strings with the same characters are the same object
+	objString struct {
+		objHeader
+		chars string
+	}
+
+	// objFunction is a compiled function loaded into the VM with its constants materialized as values
+	objFunction struct {
+		objHeader
+		name         *objString
+		arity        int
+		upvalueCount int
+		chunk        *compiler.Chunk
+		constants    []Value
+	}
+
+	objClosure struct {
+		objHeader
+		function *objFunction
+		upvalues []*objUpvalue
+	}
+
+	// objUpvalue refers to a local variable captured by a closure.
+	// While the variable is still on the stack the upvalue is open and points at its slot,
+	// once the variable goes out of scope the upvalue is closed and holds the value itself.
+	objUpvalue struct {
+		objHeader
+		location int
+		open     bool
+		closed   Value
+		// next links the open upvalues, sorted by stack slot from top to bottom
+		next *objUpvalue
+	}
+
+	objNative struct {
+		objHeader
+		name  string
+		arity int
+		fn    func(arguments []Value) (Value, error)
+	}
+
+	objClass struct {
+		objHeader
+		name    *objString
+		methods map[*objString]*objClosure
+	}
+
+	objInstance struct {
+		objHeader
+		class  *objClass
+		fields map[*objString]Value
+	}
+
+	objBoundMethod struct {
+		objHeader
+		receiver Value
+		method   *objClosure
+	}
+
+	objList struct {
+		objHeader
+		elements []Value
+	}
+
+	// objMap remembers the order its keys were first inserted in,
+	// deleted entries stay as holes in entries until the map is compacted
+	objMap struct {
+		objHeader
+		entries []mapEntry
+		// index maps the key of every live entry to its position in entries
+		index map[Value]int
+	}
+
+	mapEntry struct {
+		key     Value
+		value   Value
+		deleted bool
+	}
+
+	// objRange is the sequence of numbers from start up to end, excluded, created by the range built-in
+	objRange struct {
+		objHeader
+		start float64
+		end   float64
+		step  float64
+	}
+
+	// objIterator is the built-in iterator a for-in loop uses for lists, maps, strings and ranges.
+	// Lists are read by index as the loop runs, maps are iterated over the keys they had when the loop started
+	// and strings by character, position is a byte offset for them.
+	objIterator struct {
+		objHeader
+		source   Value
+		keys     []Value
+		position int
+	}
+
+	// objError is the value a catch clause receives for a runtime error of the VM
+	objError struct {
+		objHeader
+		message string
+		line    int
+		span    compiler.Span
+		source  string
+	}
+
+	// objException holds an exception caught by the handler of a finally block, the block throws it again once it completes.
+	// value is the thrown value, or nil for runtime errors of the VM.
+	objException struct {
+		objHeader
+		err   *RuntimeError
+		value Value
+	}
+)
+
+func (h *objHeader) header() *objHeader {
+	return h
+}
+
+func (s *objString) String() string {
+	return s.chars
+}
+
+func (f *objFunction) String() string {
+	if f.name == nil {
+		return "<script>"
+	}
+	return fmt.Sprintf("<fn %s>", f.name.chars)
+}
+
+func (c *objClosure) String() string {
+	return c.function.String()
+}
+
+func (u *objUpvalue) String() string {
+	return "upvalue"
+}
+
+func (n *objNative) String() string {
+	return "<native fn>"
+}
+
+func (c *objClass) String() string {
+	return c.name.chars
+}
+
+func (i *objInstance) String() string {
+	return fmt.Sprintf("%s instance", i.class.name.chars)
+}
+
+func (b *objBoundMethod) String() string {
+	return b.method.String()
+}
@@ -0,0 +1,105 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+)
+
+type (
+	ValueType byte
+
+	// Value is a Lox value on the VM stack.
+	// Numbers and booleans are stored inline, so arithmetic doesn't allocate.
+	Value struct {
+		vType   ValueType
+		boolean bool
+		number  float64
+		object  Object
+	}
+)
+
+const (
+	NilType ValueType = iota
+	BoolType
+	NumberType
+	ObjectType
+)
+
+var Nil = Value{}
+
+func NewBool(b bool) Value {
+	return Value{vType: BoolType, boolean: b}
+}
+
+func NewNumber(n float64) Value {
+	return Value{vType: NumberType, number: n}
+}
+
+func NewObject(o Object) Value {
+	return Value{vType: ObjectType, object: o}
+}
+
+func (v Value) Type() ValueType {
+	return v.vType
+}
+
+func (v Value) IsNil() bool {
+	return v.vType == NilType
+}
+
+func (v Value) IsNumber() bool {
+	return v.vType == NumberType
+}
+
+func (v Value) AsBool() bool {
+	return v.boolean
+}
+
+func (v Value) AsNumber() float64 {
+	return v.number
+}
+
+func (v Value) AsObject() Object {
+	return v.object
+}
+
+// isFalsey follows Lox truthiness: only nil and false are falsey
+func (v Value) isFalsey() bool {
+	return v.vType == NilType || (v.vType == BoolType && !v.boolean)
+}
+
+func (v Value) String() string {
+	switch v.vType {
+	case BoolType:
+		return fmt.Sprint(v.boolean)
+	case NumberType:
+		return formatNumber(v.number)
+	case ObjectType:
+		return v.object.String()
+	}
+	return "nil"
+}
+
+// valuesEqual compares values of the same type, strings are interned so objects compare by identity
+func valuesEqual(a Value, b Value) bool {
+	if a.vType != b.vType {
+		return false
+	}
+	switch a.vType {
+	case NilType:
+		return true
+	case BoolType:
+		return a.boolean == b.boolean
+	case NumberType:
+		return a.number == b.number
+	}
+	return a.object == b.object
+}
+
+// formatNumber prints numbers the same way the tree-walking interpreter does
+func formatNumber(n float64) string {
+	if n == math.Trunc(n) {
+		return fmt.Sprintf("%.0f", n)
+	}
+	return fmt.Sprintf("%f", n)
+}
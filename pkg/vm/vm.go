@@ -0,0 +1,836 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/compiler"
+	"github.com/mtvarkovsky/golox/pkg/interpreter"
+	"io"
+	"os"
+	"time"
+)
+
+type (
+	// VM runs compiled Lox programs on a value stack.
+	// Globals persist between Interpret calls, a single VM is not safe for concurrent use.
+	VM interface {
+		Interpret(function *compiler.Function) error
+		InterpretContext(ctx context.Context, function *compiler.Function) error
+		CollectGarbage()
+		GCStats() GCStats
+		DefineNative(name string, arity int, fn NativeFunction)
+		GetGlobal(name string) (any, error)
+		SetGlobal(name string, value any) error
+	}
+
+	Config struct {
+		// Stdout receives the output of print statements, os.Stdout is used when it is nil
+		Stdout io.Writer
+		// MaxSteps limits how many instructions a single run may execute, 0 means no limit
+		MaxSteps int
+		// MaxCallDepth limits how many frames may be active at once, the top-level script included, 0 means FramesMax
+		MaxCallDepth int
+		// Trace receives the stack and the disassembled instruction before every instruction is executed,
+		// nothing is traced when it is nil
+		Trace io.Writer
+		GC    GCConfig
+	}
+
+	vm struct {
+		config Config
+
+		frames     [FramesMax]callFrame
+		frameCount int
+
+		stack []Value
+		sp    int
+
+		globals map[*objString]Value
+		strings map[string]*objString
+		// initString is the interned name of class initializers
+		initString *objString
+		// iteratorString, hasNextString and nextString are the interned names of the iterator protocol methods
+		iteratorString *objString
+		hasNextString  *objString
+		nextString     *objString
+		// openUpvalues is the head of the list of upvalues that still point at the stack
+		openUpvalues *objUpvalue
+		// handlers holds the exception handlers of the try statements being run, innermost last
+		handlers []handler
+
+		steps int
+		ctx   context.Context
+		done  <-chan struct{}
+
+		// objects is the head of the list of every object on the heap
+		objects        Object
+		heapObjects    int
+		bytesAllocated int
+		nextGC         int
+		grayStack      []Object
+		stats          GCStats
+		// loading pauses the collector while a compiled function is turned into objects that aren't rooted yet
+		loading bool
+	}
+
+	callFrame struct {
+		closure *objClosure
+		ip      int
+		// slots is the index of the first stack slot the function can use, it holds the callee
+		slots int
+	}
+
+	RuntimeError struct {
+		Line int
+		// Span is the token the failed instruction was compiled from
+		Span compiler.Span
+		// Source is the program Span points into, it is empty for functions loaded from .loxc files
+		Source string
+		err    error
+		// Trace lists the function calls the error returned from, innermost first, it ends with the top-level script
+		Trace []StackFrame
+		// unwound is the index of the outermost call frame already in Trace
+		unwound int
+	}
+
+	// StackFrame is a function call that was active when a runtime error stopped the program
+	StackFrame struct {
+		// Function is the name of the called function, "script" for the top-level code
+		Function string
+		// Line is the line the function was executing, -1 when it isn't known
+		Line int
+	}
+)
+
+const (
+	FramesMax = 1024
+	stackMin  = 256
+)
+
+// the limits share their errors with the tree-walking interpreter, so hosts check for them the same way on every backend
+var (
+	ErrStepLimitExceeded = interpreter.ErrStepLimitExceeded
+	ErrCallDepthExceeded = interpreter.ErrCallDepthExceeded
+)
+
+func NewVM(config Config) VM {
+	if config.Stdout == nil {
+		config.Stdout = os.Stdout
+	}
+	if config.MaxCallDepth <= 0 || config.MaxCallDepth > FramesMax {
+		config.MaxCallDepth = FramesMax
+	}
+	if config.GC.InitialHeap <= 0 {
+		config.GC.InitialHeap = DefaultInitialHeap
+	}
+	if config.GC.GrowthFactor < 2 {
+		config.GC.GrowthFactor = DefaultGrowthFactor
+	}
+
+	vm := &vm{
+		config:  config,
+		stack:   make([]Value, stackMin),
+		globals: make(map[*objString]Value),
+		strings: make(map[string]*objString),
+		nextGC:  config.GC.InitialHeap,
+	}
+	vm.initString = vm.internString("init")
+	vm.iteratorString = vm.internString("iterator")
+	vm.hasNextString = vm.internString("hasNext")
+	vm.nextString = vm.internString("next")
+	vm.defineNative("clock", 0, clock)
+	vm.defineNative("range", -1, vm.nativeRange)
+	vm.defineListNatives()
+	vm.defineMapNatives()
+	return vm
+}
+
+func (vm *vm) Interpret(function *compiler.Function) error {
+	return vm.InterpretContext(context.Background(), function)
+}
+
+// InterpretContext runs the compiled script until it finishes, fails or ctx is done.
+// Cancellation is checked on every backward jump and function call.
+func (vm *vm) InterpretContext(ctx context.Context, function *compiler.Function) error {
+	vm.steps = 0
+	vm.ctx = ctx
+	vm.done = ctx.Done()
+	defer func() {
+		vm.ctx = nil
+		vm.done = nil
+	}()
+
+	vm.loading = true
+	script := vm.loadFunction(function)
+	vm.loading = false
+	vm.push(NewObject(script))
+	closure := vm.newClosure(script)
+	vm.stack[vm.sp-1] = NewObject(closure)
+	if err := vm.call(closure, 0); err != nil {
+		vm.resetStack()
+		return err
+	}
+
+	if err := vm.run(); err != nil {
+		var runtimeErr *RuntimeError
+		if errors.As(err, &runtimeErr) {
+			vm.unwind(runtimeErr, 0)
+		}
+		vm.resetStack()
+		return err
+	}
+	return nil
+}
+
+// loadFunction turns compiled functions into VM objects, interning their string constants
+func (vm *vm) loadFunction(function *compiler.Function) *objFunction {
+	f := vm.newFunction(function.Arity, function.UpvalueCount, function.Chunk)
+	if function.Name != "" {
+		f.name = vm.internString(function.Name)
+	}
+
+	for i, constant := range function.Chunk.Constants {
+		switch c := constant.(type) {
+		case float64:
+			f.constants[i] = NewNumber(c)
+		case string:
+			f.constants[i] = NewObject(vm.internString(c))
+		case *compiler.Function:
+			f.constants[i] = NewObject(vm.loadFunction(c))
+		}
+	}
+	return f
+}
+
+// run executes instructions until the script returns or fails.
+// A runtime error the program can catch resumes execution at the innermost exception handler instead.
+func (vm *vm) run() error {
+	for {
+		err := vm.execute()
+		if err == nil || !vm.catch(err) {
+			return err
+		}
+	}
+}
+
+// execute runs the instructions of the innermost frame and of the frames it calls
+func (vm *vm) execute() error {
+	frame := &vm.frames[vm.frameCount-1]
+	code := frame.closure.function.chunk.Code
+	constants := frame.closure.function.constants
+	ip := frame.ip
+
+	for {
+		if vm.config.MaxSteps > 0 {
+			vm.steps++
+			if vm.steps > vm.config.MaxSteps {
+				frame.ip = ip
+				return vm.runtimeError("%w", ErrStepLimitExceeded)
+			}
+		}
+
+		if vm.config.Trace != nil {
+			vm.trace(frame, ip)
+		}
+
+		op := compiler.OpCode(code[ip])
+		ip++
+
+		switch op {
+		case compiler.OpConstant:
+			vm.push(constants[int(code[ip])<<8|int(code[ip+1])])
+			ip += 2
+		case compiler.OpNil:
+			vm.push(Nil)
+		case compiler.OpTrue:
+			vm.push(NewBool(true))
+		case compiler.OpFalse:
+			vm.push(NewBool(false))
+		case compiler.OpPop:
+			vm.sp--
+		case compiler.OpGetLocal:
+			vm.push(vm.stack[frame.slots+int(code[ip])])
+			ip++
+		case compiler.OpSetLocal:
+			vm.stack[frame.slots+int(code[ip])] = vm.peek(0)
+			ip++
+		case compiler.OpGetGlobal:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			ip += 2
+			value, found := vm.globals[name]
+			if !found {
+				frame.ip = ip
+				return vm.runtimeError("undefined variable '%s'", name.chars)
+			}
+			vm.push(value)
+		case compiler.OpDefineGlobal:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			ip += 2
+			vm.globals[name] = vm.peek(0)
+			vm.sp--
+		case compiler.OpSetGlobal:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			ip += 2
+			if _, found := vm.globals[name]; !found {
+				frame.ip = ip
+				return vm.runtimeError("undefined variable '%s'", name.chars)
+			}
+			vm.globals[name] = vm.peek(0)
+		case compiler.OpGetUpvalue:
+			vm.push(vm.upvalueGet(frame.closure.upvalues[code[ip]]))
+			ip++
+		case compiler.OpSetUpvalue:
+			vm.upvalueSet(frame.closure.upvalues[code[ip]], vm.peek(0))
+			ip++
+		case compiler.OpGetProperty:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			ip += 2
+			if e, ok := vm.peek(0).object.(*objError); ok {
+				value, err := vm.errorProperty(e, name)
+				if err != nil {
+					frame.ip = ip
+					return vm.runtimeError("%s", err)
+				}
+				vm.stack[vm.sp-1] = value
+				break
+			}
+			instance, ok := vm.peek(0).object.(*objInstance)
+			if !ok {
+				frame.ip = ip
+				return vm.runtimeError("only instances have properties")
+			}
+			if value, found := instance.fields[name]; found {
+				vm.stack[vm.sp-1] = value
+				break
+			}
+			if err := vm.bindMethod(instance.class, name); err != nil {
+				frame.ip = ip
+				return vm.runtimeError("%s", err)
+			}
+		case compiler.OpSetProperty:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			ip += 2
+			instance, ok := vm.peek(1).object.(*objInstance)
+			if !ok {
+				frame.ip = ip
+				return vm.runtimeError("only instances have fields")
+			}
+			if _, found := instance.fields[name]; !found {
+				vm.grow(instance, entrySize)
+			}
+			value := vm.pop()
+			instance.fields[name] = value
+			vm.stack[vm.sp-1] = value
+		case compiler.OpGetSuper:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			ip += 2
+			superclass, ok := vm.pop().object.(*objClass)
+			if !ok {
+				frame.ip = ip
+				return vm.runtimeError("superclass must be a class")
+			}
+			if err := vm.bindMethod(superclass, name); err != nil {
+				frame.ip = ip
+				return vm.runtimeError("%s", err)
+			}
+		case compiler.OpEqual:
+			b := vm.pop()
+			vm.stack[vm.sp-1] = NewBool(valuesEqual(vm.stack[vm.sp-1], b))
+		case compiler.OpGreater, compiler.OpLess, compiler.OpSubtract, compiler.OpMultiply, compiler.OpDivide:
+			a, b := vm.peek(1), vm.peek(0)
+			if a.vType != NumberType || b.vType != NumberType {
+				frame.ip = ip
+				return vm.runtimeError("operand must be a number")
+			}
+			vm.sp--
+			vm.stack[vm.sp-1] = arithmetic(op, a.number, b.number)
+		case compiler.OpAdd:
+			a, b := vm.peek(1), vm.peek(0)
+			if a.vType == NumberType && b.vType == NumberType {
+				vm.sp--
+				vm.stack[vm.sp-1] = NewNumber(a.number + b.number)
+				break
+			}
+			as, aIsString := a.object.(*objString)
+			bs, bIsString := b.object.(*objString)
+			if !aIsString || !bIsString {
+				frame.ip = ip
+				return vm.runtimeError("operands must be both numbers or both strings")
+			}
+			// the operands stay on the stack until the result is allocated
+			result := vm.internString(as.chars + bs.chars)
+			vm.sp--
+			vm.stack[vm.sp-1] = NewObject(result)
+		case compiler.OpNot:
+			vm.stack[vm.sp-1] = NewBool(vm.stack[vm.sp-1].isFalsey())
+		case compiler.OpNegate:
+			if vm.peek(0).vType != NumberType {
+				frame.ip = ip
+				return vm.runtimeError("operand must be a number")
+			}
+			vm.stack[vm.sp-1].number = -vm.stack[vm.sp-1].number
+		case compiler.OpPrint:
+			if _, err := fmt.Fprintln(vm.config.Stdout, vm.pop().String()); err != nil {
+				frame.ip = ip
+				return vm.runtimeError("%s", err)
+			}
+		case compiler.OpJump:
+			ip += 2 + (int(code[ip])<<8 | int(code[ip+1]))
+		case compiler.OpJumpIfFalse:
+			if vm.peek(0).isFalsey() {
+				ip += 2 + (int(code[ip])<<8 | int(code[ip+1]))
+			} else {
+				ip += 2
+			}
+		case compiler.OpLoop:
+			ip += 2
+			ip -= int(code[ip-2])<<8 | int(code[ip-1])
+			if err := vm.checkCanceled(); err != nil {
+				frame.ip = ip
+				return err
+			}
+		case compiler.OpCall:
+			argCount := int(code[ip])
+			ip++
+			frame.ip = ip
+			if err := vm.callValue(vm.peek(argCount), argCount); err != nil {
+				return err
+			}
+			frame = &vm.frames[vm.frameCount-1]
+			code, constants, ip = frame.closure.function.chunk.Code, frame.closure.function.constants, frame.ip
+		case compiler.OpInvoke:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			argCount := int(code[ip+2])
+			ip += 3
+			frame.ip = ip
+			if err := vm.invoke(name, argCount); err != nil {
+				return err
+			}
+			frame = &vm.frames[vm.frameCount-1]
+			code, constants, ip = frame.closure.function.chunk.Code, frame.closure.function.constants, frame.ip
+		case compiler.OpSuperInvoke:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			argCount := int(code[ip+2])
+			ip += 3
+			frame.ip = ip
+			superclass, ok := vm.pop().object.(*objClass)
+			if !ok {
+				return vm.runtimeError("superclass must be a class")
+			}
+			if err := vm.invokeFromClass(superclass, name, argCount); err != nil {
+				return err
+			}
+			frame = &vm.frames[vm.frameCount-1]
+			code, constants, ip = frame.closure.function.chunk.Code, frame.closure.function.constants, frame.ip
+		case compiler.OpClosure:
+			function := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objFunction)
+			ip += 2
+			closure := vm.newClosure(function)
+			vm.push(NewObject(closure))
+			for i := range closure.upvalues {
+				isLocal := code[ip] == 1
+				index := int(code[ip+1])
+				ip += 2
+				if isLocal {
+					closure.upvalues[i] = vm.captureUpvalue(frame.slots + index)
+				} else {
+					closure.upvalues[i] = frame.closure.upvalues[index]
+				}
+			}
+		case compiler.OpCloseUpvalue:
+			vm.closeUpvalues(vm.sp - 1)
+			vm.sp--
+		case compiler.OpReturn:
+			result := vm.pop()
+			vm.closeUpvalues(frame.slots)
+			vm.frameCount--
+			if vm.frameCount == 0 {
+				vm.sp = 0
+				return nil
+			}
+			vm.sp = frame.slots
+			vm.push(result)
+			frame = &vm.frames[vm.frameCount-1]
+			code, constants, ip = frame.closure.function.chunk.Code, frame.closure.function.constants, frame.ip
+		case compiler.OpClass:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			ip += 2
+			vm.push(NewObject(vm.newClass(name)))
+		case compiler.OpInherit:
+			superclass, ok := vm.peek(1).object.(*objClass)
+			if !ok {
+				frame.ip = ip
+				return vm.runtimeError("superclass must be a class")
+			}
+			subclass, ok := vm.peek(0).object.(*objClass)
+			if !ok {
+				frame.ip = ip
+				return vm.runtimeError("%s expects a class", op)
+			}
+			vm.grow(subclass, entrySize*len(superclass.methods))
+			for name, method := range superclass.methods {
+				subclass.methods[name] = method
+			}
+			vm.sp--
+		case compiler.OpMethod:
+			name := constants[int(code[ip])<<8|int(code[ip+1])].object.(*objString)
+			ip += 2
+			// the compiler only emits these types, a .loxc file may not
+			class, isClass := vm.peek(1).object.(*objClass)
+			method, isClosure := vm.peek(0).object.(*objClosure)
+			if !isClass || !isClosure {
+				frame.ip = ip
+				return vm.runtimeError("%s expects a class and a method", op)
+			}
+			vm.grow(class, entrySize)
+			class.methods[name] = method
+			vm.sp--
+		case compiler.OpList:
+			count := int(code[ip])<<8 | int(code[ip+1])
+			ip += 2
+			// the elements stay on the stack until the list is allocated
+			elements := make([]Value, count)
+			copy(elements, vm.stack[vm.sp-count:vm.sp])
+			list := vm.newList(elements)
+			vm.sp -= count
+			vm.push(NewObject(list))
+		case compiler.OpMap:
+			count := int(code[ip])<<8 | int(code[ip+1])
+			ip += 2
+			// the map is pushed above its keys and values so all of them stay reachable while it grows
+			base := vm.sp - 2*count
+			m := vm.newMap()
+			vm.push(NewObject(m))
+			for i := base; i < base+2*count; i += 2 {
+				if err := vm.mapSet(m, vm.stack[i], vm.stack[i+1]); err != nil {
+					frame.ip = ip
+					return vm.runtimeError("%s", err)
+				}
+			}
+			vm.stack[base] = NewObject(m)
+			vm.sp = base + 1
+		case compiler.OpIterator:
+			frame.ip = ip
+			if err := vm.iterator(); err != nil {
+				return err
+			}
+			frame = &vm.frames[vm.frameCount-1]
+			code, constants, ip = frame.closure.function.chunk.Code, frame.closure.function.constants, frame.ip
+		case compiler.OpGetIndex:
+			var value Value
+			var err error
+			switch o := vm.peek(1).object.(type) {
+			case *objList:
+				var i int
+				if i, err = listIndex(vm.peek(0), len(o.elements)); err == nil {
+					value = o.elements[i]
+				}
+			case *objMap:
+				value, err = mapGet(o, vm.peek(0))
+			default:
+				err = errors.New("only lists and maps can be indexed")
+			}
+			if err != nil {
+				frame.ip = ip
+				return vm.runtimeError("%s", err)
+			}
+			vm.sp--
+			vm.stack[vm.sp-1] = value
+		case compiler.OpSetIndex:
+			var err error
+			switch o := vm.peek(2).object.(type) {
+			case *objList:
+				var i int
+				if i, err = listIndex(vm.peek(1), len(o.elements)); err == nil {
+					o.elements[i] = vm.peek(0)
+				}
+			case *objMap:
+				err = vm.mapSet(o, vm.peek(1), vm.peek(0))
+			default:
+				err = errors.New("only lists and maps can be indexed")
+			}
+			if err != nil {
+				frame.ip = ip
+				return vm.runtimeError("%s", err)
+			}
+			value := vm.pop()
+			vm.sp--
+			vm.stack[vm.sp-1] = value
+		case compiler.OpTry, compiler.OpTryFinally:
+			offset := int(code[ip])<<8 | int(code[ip+1])
+			ip += 2
+			vm.handlers = append(vm.handlers, handler{
+				frame:   vm.frameCount - 1,
+				sp:      vm.sp,
+				ip:      ip + offset,
+				finally: op == compiler.OpTryFinally,
+			})
+		case compiler.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case compiler.OpThrow:
+			frame.ip = ip
+			return vm.throw(vm.pop())
+		default:
+			frame.ip = ip
+			return vm.runtimeError("unknown opcode %d", op)
+		}
+	}
+}
+
+func (vm *vm) trace(frame *callFrame, ip int) {
+	_, _ = fmt.Fprint(vm.config.Trace, "          ")
+	for _, value := range vm.stack[:vm.sp] {
+		_, _ = fmt.Fprintf(vm.config.Trace, "[ %s ]", value)
+	}
+	_, _ = fmt.Fprintln(vm.config.Trace)
+	compiler.DisassembleInstruction(vm.config.Trace, frame.closure.function.chunk, ip)
+}
+
+func arithmetic(op compiler.OpCode, a float64, b float64) Value {
+	switch op {
+	case compiler.OpGreater:
+		return NewBool(a > b)
+	case compiler.OpLess:
+		return NewBool(a < b)
+	case compiler.OpSubtract:
+		return NewNumber(a - b)
+	case compiler.OpMultiply:
+		return NewNumber(a * b)
+	}
+	return NewNumber(a / b)
+}
+
+func (vm *vm) callValue(callee Value, argCount int) error {
+	switch c := callee.object.(type) {
+	case *objClosure:
+		return vm.call(c, argCount)
+	case *objBoundMethod:
+		vm.stack[vm.sp-argCount-1] = c.receiver
+		return vm.call(c.method, argCount)
+	case *objClass:
+		vm.stack[vm.sp-argCount-1] = NewObject(vm.newInstance(c))
+		if initializer, found := c.methods[vm.initString]; found {
+			return vm.call(initializer, argCount)
+		}
+		if argCount != 0 {
+			return vm.runtimeError("expected 0 arguments but got %d", argCount)
+		}
+		return nil
+	case *objNative:
+		return vm.callNative(c, argCount)
+	}
+	return vm.runtimeError("can only call functions and classes")
+}
+
+func (vm *vm) call(closure *objClosure, argCount int) error {
+	if argCount != closure.function.arity {
+		return vm.runtimeError("expected %d arguments but got %d", closure.function.arity, argCount)
+	}
+	if err := vm.checkCanceled(); err != nil {
+		return err
+	}
+	if vm.frameCount == vm.config.MaxCallDepth {
+		return vm.runtimeError("%w", ErrCallDepthExceeded)
+	}
+
+	frame := &vm.frames[vm.frameCount]
+	vm.frameCount++
+	frame.closure = closure
+	frame.ip = 0
+	frame.slots = vm.sp - argCount - 1
+	return nil
+}
+
+func (vm *vm) callNative(native *objNative, argCount int) error {
+	if native.arity >= 0 && argCount != native.arity {
+		return vm.runtimeError("expected %d arguments but got %d", native.arity, argCount)
+	}
+
+	result, err := native.fn(vm.stack[vm.sp-argCount : vm.sp])
+	if err != nil {
+		return vm.runtimeError("%s", err)
+	}
+	vm.sp -= argCount + 1
+	vm.push(result)
+	return nil
+}
+
+func (vm *vm) invoke(name *objString, argCount int) error {
+	if it, ok := vm.peek(argCount).object.(*objIterator); ok {
+		return vm.invokeIterator(it, name, argCount)
+	}
+	if e, ok := vm.peek(argCount).object.(*objError); ok {
+		value, err := vm.errorProperty(e, name)
+		if err != nil {
+			return vm.runtimeError("%s", err)
+		}
+		vm.stack[vm.sp-argCount-1] = value
+		return vm.callValue(value, argCount)
+	}
+	instance, ok := vm.peek(argCount).object.(*objInstance)
+	if !ok {
+		return vm.runtimeError("only instances have properties")
+	}
+
+	if value, found := instance.fields[name]; found {
+		vm.stack[vm.sp-argCount-1] = value
+		return vm.callValue(value, argCount)
+	}
+
+	return vm.invokeFromClass(instance.class, name, argCount)
+}
+
+func (vm *vm) invokeFromClass(class *objClass, name *objString, argCount int) error {
+	method, found := class.methods[name]
+	if !found {
+		return vm.runtimeError("undefined property '%s'", name.chars)
+	}
+	return vm.call(method, argCount)
+}
+
+// bindMethod replaces the instance on top of the stack with its method bound to it
+func (vm *vm) bindMethod(class *objClass, name *objString) error {
+	method, found := class.methods[name]
+	if !found {
+		return fmt.Errorf("undefined property '%s'", name.chars)
+	}
+
+	vm.stack[vm.sp-1] = NewObject(vm.newBoundMethod(vm.peek(0), method))
+	return nil
+}
+
+// captureUpvalue reuses the open upvalue for the stack slot if a closure already captured it
+func (vm *vm) captureUpvalue(location int) *objUpvalue {
+	var previous *objUpvalue
+	upvalue := vm.openUpvalues
+	for upvalue != nil && upvalue.location > location {
+		previous = upvalue
+		upvalue = upvalue.next
+	}
+	if upvalue != nil && upvalue.location == location {
+		return upvalue
+	}
+
+	created := vm.newUpvalue(location, upvalue)
+	if previous == nil {
+		vm.openUpvalues = created
+	} else {
+		previous.next = created
+	}
+	return created
+}
+
+// closeUpvalues moves the values of all stack slots at or above last into their upvalues
+func (vm *vm) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.location >= last {
+		upvalue := vm.openUpvalues
+		upvalue.closed = vm.stack[upvalue.location]
+		upvalue.open = false
+		vm.openUpvalues = upvalue.next
+		upvalue.next = nil
+	}
+}
+
+func (vm *vm) upvalueGet(upvalue *objUpvalue) Value {
+	if upvalue.open {
+		return vm.stack[upvalue.location]
+	}
+	return upvalue.closed
+}
+
+func (vm *vm) upvalueSet(upvalue *objUpvalue, value Value) {
+	if upvalue.open {
+		vm.stack[upvalue.location] = value
+		return
+	}
+	upvalue.closed = value
+}
+
+// checkCanceled returns a runtime error once the context of the current Interpret call is done
+func (vm *vm) checkCanceled() error {
+	if vm.done == nil {
+		return nil
+	}
+	select {
+	case <-vm.done:
+		return vm.runtimeError("execution interrupted: %w", vm.ctx.Err())
+	default:
+		return nil
+	}
+}
+
+func (vm *vm) internString(chars string) *objString {
+	if s, found := vm.strings[chars]; found {
+		return s
+	}
+	s := vm.newString(chars)
+	vm.strings[chars] = s
+	return s
+}
+
+func (vm *vm) defineNative(name string, arity int, fn func(arguments []Value) (Value, error)) {
+	// the name is kept on the stack while the native is allocated
+	vm.push(NewObject(vm.internString(name)))
+	native := vm.newNative(name, arity, fn)
+	vm.globals[vm.pop().object.(*objString)] = NewObject(native)
+}
+
+func (vm *vm) push(value Value) {
+	if vm.sp == len(vm.stack) {
+		stack := make([]Value, len(vm.stack)*2)
+		copy(stack, vm.stack)
+		vm.stack = stack
+	}
+	vm.stack[vm.sp] = value
+	vm.sp++
+}
+
+func (vm *vm) pop() Value {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+func (vm *vm) peek(distance int) Value {
+	return vm.stack[vm.sp-1-distance]
+}
+
+func (vm *vm) resetStack() {
+	vm.sp = 0
+	vm.frameCount = 0
+	vm.openUpvalues = nil
+	vm.handlers = nil
+}
+
+// runtimeError reports an error at the instruction the innermost frame is executing,
+// callers keep the frame's ip up to date before calling it
+func (vm *vm) runtimeError(format string, args ...any) error {
+	line, span, source := 0, compiler.Span{}, ""
+	if vm.frameCount > 0 {
+		frame := &vm.frames[vm.frameCount-1]
+		if frame.ip > 0 {
+			chunk := frame.closure.function.chunk
+			line = chunk.Lines[frame.ip-1]
+			span = chunk.Spans[frame.ip-1]
+			source = chunk.Source
+		}
+	}
+	return &RuntimeError{
+		Line:   line,
+		Span:   span,
+		Source: source,
+		err:    fmt.Errorf(format, args...),
+	}
+}
+
+func clock(_ []Value) (Value, error) {
+	return NewNumber(float64(time.Now().UnixNano()) / float64(time.Second)), nil
+}
+
+func (re *RuntimeError) Error() string {
+	return re.err.Error()
+}
+
+func (re *RuntimeError) Unwrap() error {
+	return re.err
+}
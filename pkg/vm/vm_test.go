@@ -0,0 +1,508 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mtvarkovsky/golox/pkg/compiler"
+	"github.com/mtvarkovsky/golox/pkg/parser"
+	"github.com/mtvarkovsky/golox/pkg/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func compile(t *testing.T, code string) *compiler.Function {
+	tkns, scanErrs := scanner.NewScanner(code).ScanTokens()
+	require.Empty(t, scanErrs)
+	statements, parseErrs := parser.NewParser(tkns).Parse()
+	require.Empty(t, parseErrs)
+	function, compileErrs := compiler.NewCompiler().Compile(statements)
+	require.Empty(t, compileErrs)
+	return function
+}
+
+func TestVM_Programs(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		code   string
+		output string
+	}{
+		{
+			name:   "arithmetic",
+			code:   "print 1 + 2 * 3;\nprint (1 + 2) * 3;\nprint 7 / 2;\nprint -(1 - 3);\n",
+			output: "7\n9\n3.500000\n2\n",
+		},
+		{
+			name:   "comparison and equality",
+			code:   "print 1 < 2;\nprint 2 <= 1;\nprint 1 != 1;\nprint \"a\" + \"b\" == \"ab\";\nprint nil == false;\nprint !nil;\n",
+			output: "true\nfalse\nfalse\ntrue\nfalse\ntrue\n",
+		},
+		{
+			name:   "logical operators",
+			code:   "print nil or \"b\";\nprint false and 1;\nprint 1 and 2;\n",
+			output: "b\nfalse\n2\n",
+		},
+		{
+			name:   "control flow",
+			code:   "var s = 0;\nfor (var i = 0; i < 5; i = i + 1) { if (i == 2) s = s + 10; else s = s + i; }\nprint s;\n",
+			output: "18\n",
+		},
+		{
+			name:   "recursion",
+			code:   "fun fib(n) { if (n < 2) return n; return fib(n - 1) + fib(n - 2); }\nprint fib(15);\n",
+			output: "610\n",
+		},
+		{
+			name:   "closed upvalues",
+			code:   "fun make() { var i = 0; fun inc() { i = i + 1; return i; } return inc; }\nvar a = make();\nvar b = make();\na();\nprint a();\nprint b();\n",
+			output: "2\n1\n",
+		},
+		{
+			name:   "shared upvalue",
+			code:   "var get;\nvar set;\n{\nvar x = 1;\nfun g() { return x; }\nfun s(v) { x = v; }\nget = g;\nset = s;\n}\nset(5);\nprint get();\n",
+			output: "5\n",
+		},
+		{
+			name:   "classes",
+			code:   "class P { init(x) { this.x = x; } get() { return this.x; } }\nvar p = P(3);\nprint p.get();\nvar m = p.get;\nprint m();\nprint P;\nprint p;\nprint p.init(4).x;\n",
+			output: "3\n3\nP\nP instance\n4\n",
+		},
+		{
+			name:   "fields shadow methods",
+			code:   "class A { f() { return 1; } }\nfun g() { return 2; }\nvar a = A();\na.f = g;\nprint a.f();\n",
+			output: "2\n",
+		},
+		{
+			name:   "inheritance",
+			code:   "class A { f() { return \"A\"; } g() { return \"g\"; } }\nclass B < A { f() { var s = super.f; return \"B\" + s() + super.g(); } }\nprint B().f();\nprint B().g();\n",
+			output: "BAg\ng\n",
+		},
+		{
+			name:   "functions print like the tree-walker",
+			code:   "fun f() {}\nprint f;\nprint clock;\nprint f();\n",
+			output: "<fn f>\n<native fn>\nnil\n",
+		},
+		{
+			name:   "lists",
+			code:   "var xs = [1, \"two\", [nil]];\nxs[0] = xs[0] + 1;\npush(xs, xs);\nprint xs;\nprint len(xs);\nprint pop(xs) == xs;\ninsert(xs, 1, true);\nprint slice(xs, 1, 3);\nprint contains(xs, \"two\");\n",
+			output: "[2, \"two\", [nil], [...]]\n4\ntrue\n[true, \"two\"]\ntrue\n",
+		},
+		{
+			name:   "maps",
+			code:   "var m = {\"a\": 1, 2: [3], -0: nil};\nm[\"a\"] = m[\"a\"] + 1;\nm[true] = m;\nprint m;\nprint m[0];\nprint delete(m, 2);\nprint has(m, 2);\nprint keys(m);\nprint values(m)[0];\nprint len(m);\n",
+			output: "{\"a\": 2, 2: [3], -0: nil, true: {...}}\nnil\ntrue\nfalse\n[\"a\", -0, true]\n2\n3\n",
+		},
+		{
+			name:   "for-in over built-in iterables",
+			code:   "for (x in [1, \"a\"]) print x;\nfor (k in {\"k\": 1, 2: 3}) print k;\nfor (c in \"hé\") print c;\nfor (n in range(3, 0, -1.5)) print n;\nvar xs = [1];\nfor (x in xs) if (x < 3) push(xs, x + 1);\nprint xs;\n",
+			output: "1\na\nk\n2\nh\né\n3\n1.500000\n[1, 2, 3]\n",
+		},
+		{
+			name:   "for-in iterator protocol",
+			code:   "class Down { init(n) { this.n = n; } hasNext() { return this.n > 0; } next() { this.n = this.n - 1; return this.n; } }\nclass Box { iterator() { return Down(2); } }\nfor (n in Down(2)) print n;\nfor (n in Box()) print n;\n",
+			output: "1\n0\n1\n0\n",
+		},
+		{
+			name:   "for-in variable per iteration",
+			code:   "var fs = [];\nfor (i in range(0, 2)) { fun f() { return i; } push(fs, f); }\nprint fs[0]() + fs[1]();\nprint range(0, 1);\n",
+			output: "1\nrange(0, 1, 1)\n",
+		},
+		{
+			name:   "break and continue",
+			code:   "for (var i = 0; i < 10; i = i + 1) { if (i == 1) continue; if (i == 4) break; print i; }\nvar j = 0;\nwhile (true) { j = j + 1; if (j < 3) continue; print j; break; }\nfor (x in [1, 2, 3, 4]) { var y = x * 10; if (x == 2) continue; if (x == 4) break; print y; }\n",
+			output: "0\n2\n3\n3\n10\n30\n",
+		},
+		{
+			name:   "break only leaves the innermost loop",
+			code:   "for (var i = 0; i < 2; i = i + 1) { for (j in range(0, 5)) { if (j == 1) break; print i + j; } }\n",
+			output: "0\n1\n",
+		},
+		{
+			name:   "break and continue close captured variables",
+			code:   "var fs = [];\nfor (var i = 0; i < 4; i = i + 1) { var k = i; fun f() { return k; } push(fs, f); if (i == 1) continue; if (i == 2) break; }\nprint fs[0]() + fs[1]() + fs[2]();\nfor (x in [1]) { fun g() { return x; } push(fs, g); break; }\nprint fs[3]();\n",
+			output: "3\n1\n",
+		},
+		{
+			name:   "return from a loop in a function",
+			code:   "fun find(xs, v) { for (x in xs) { while (true) { if (x == v) return x; break; } } return nil; }\nprint find([1, 2, 3], 2);\nprint find([1], 5);\n",
+			output: "2\nnil\n",
+		},
+		{
+			name:   "catch runtime errors",
+			code:   "try { print 1 + nil; } catch (e) { print e; print e.message; print e.line; }\nfun f(n) { if (n == 0) [][0]; f(n - 1); }\ntry { f(3); print \"unreachable\"; } catch (e) { print e.message; }\n",
+			output: "operands must be both numbers or both strings\noperands must be both numbers or both strings\n1\nlist index 0 is out of range for a list of length 0\n",
+		},
+		{
+			name:   "throw any value",
+			code:   "class Oops { init(code) { this.code = code; } }\ntry { throw Oops(42); } catch (e) { print e.code; }\ntry { throw \"text\"; } catch (e) { print e; }\nvar first;\ntry { nil(); } catch (e) { first = e; }\ntry { throw first; } catch (e) { print e == first; }\n",
+			output: "42\ntext\ntrue\n",
+		},
+		{
+			name:   "finally runs on every way out",
+			code:   "fun f() { try { return \"try\"; } finally { print \"finally after return\"; } }\nprint f();\nfun g() { try { throw 1; } finally { return \"finally wins\"; } }\nprint g();\nfor (var i = 0; i < 3; i = i + 1) { try { if (i == 0) continue; if (i == 2) break; print i; } finally { print \"finally\"; } }\ntry { try { throw \"inner\"; } finally { print \"inner finally\"; } } catch (e) { print e; }\n",
+			output: "finally after return\ntry\nfinally wins\nfinally\n1\nfinally\nfinally\ninner finally\ninner\n",
+		},
+		{
+			name:   "exceptions thrown by catch and finally",
+			code:   "try { try { throw 1; } catch (e) { throw e + 1; } finally { print \"cleanup\"; } } catch (e) { print e; }\ntry { try { throw 1; } finally { throw 3; } } catch (e) { print e; }\nwhile (true) { try { throw 4; } finally { break; } }\nprint \"done\";\n",
+			output: "cleanup\n2\n3\ndone\n",
+		},
+		{
+			name:   "caught locals and closures",
+			code:   "var fs = [];\nfor (x in [1, 2]) { try { var y = x * 10; fun h() { return y; } push(fs, h); throw y; } catch (e) { var z = e + 1; fun k() { return z; } push(fs, k); } }\nprint fs[0]() + fs[1]() + fs[2]() + fs[3]();\n",
+			output: "62\n",
+		},
+	}
+
+	for _, tc := range cases {
+		for _, stress := range []bool{false, true} {
+			tc, stress := tc, stress
+			name := tc.name
+			if stress {
+				name += " with gc stress"
+			}
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				out := &bytes.Buffer{}
+				err := NewVM(Config{Stdout: out, GC: GCConfig{Stress: stress}}).Interpret(compile(t, tc.code))
+				require.NoError(t, err)
+				assert.Equal(t, tc.output, out.String())
+			})
+		}
+	}
+}
+
+func TestVM_RuntimeErrors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		code    string
+		message string
+		line    int
+	}{
+		{name: "negate a string", code: "print -\"a\";\n", message: "operand must be a number", line: 1},
+		{name: "add mixed types", code: "\nprint 1 + \"a\";\n", message: "operands must be both numbers or both strings", line: 2},
+		{name: "undefined variable", code: "print a;\n", message: "undefined variable 'a'", line: 1},
+		{name: "assign undefined variable", code: "a = 1;\n", message: "undefined variable 'a'", line: 1},
+		{name: "call a number", code: "1();\n", message: "can only call functions and classes", line: 1},
+		{name: "arity", code: "fun f(a) {}\nf();\n", message: "expected 1 arguments but got 0", line: 2},
+		{name: "class arity", code: "class A {}\nA(1);\n", message: "expected 0 arguments but got 1", line: 2},
+		{name: "property of a number", code: "var a = 1;\nprint a.b;\n", message: "only instances have properties", line: 2},
+		{name: "field of a number", code: "var a = 1;\na.b = 2;\n", message: "only instances have fields", line: 2},
+		{name: "undefined property", code: "class A {}\nA().b;\n", message: "undefined property 'b'", line: 2},
+		{name: "undefined method", code: "class A {}\nA().b();\n", message: "undefined property 'b'", line: 2},
+		{name: "superclass is not a class", code: "var A = 1;\nclass B < A {}\n", message: "superclass must be a class", line: 2},
+		{name: "index a string", code: "var s = \"abc\";\nprint s[0];\n", message: "only lists and maps can be indexed", line: 2},
+		{name: "fractional index", code: "var xs = [1];\nprint xs[0.5];\n", message: "list index must be an integer", line: 2},
+		{name: "negative index", code: "var xs = [1];\nxs[-1] = 2;\n", message: "list index -1 is negative", line: 2},
+		{name: "index out of range", code: "var xs = [1];\nprint xs[1];\n", message: "list index 1 is out of range for a list of length 1", line: 2},
+		{name: "pop an empty list", code: "\npop([]);\n", message: "can't pop from an empty list", line: 2},
+		{name: "push to a number", code: "push(1, 2);\n", message: "push expects a list", line: 1},
+		{name: "list key", code: "var m = {};\nm[[]] = 1;\n", message: "list can't be a map key", line: 2},
+		{name: "NaN key in a literal", code: "var nan = 0 / 0;\nprint {nan: 1};\n", message: "NaN can't be a map key", line: 2},
+		{name: "missing key", code: "var m = {\"a\": 1};\nprint m[\"b\"];\n", message: "key \"b\" is not in the map", line: 2},
+		{name: "keys of a list", code: "keys([]);\n", message: "keys expects a map", line: 1},
+		{name: "for-in over a number", code: "\nfor (x in 1) print x;\n", message: "number is not iterable", line: 2},
+		{name: "iterator without next", code: "class A { hasNext() { return true; } }\nfor (x in A()) print x;\n", message: "undefined property 'next'", line: 2},
+		{name: "iterator that isn't an instance", code: "class A { iterator() { return 1; } }\nfor (x in A()) print x;\n", message: "only instances have properties", line: 2},
+		{name: "range step", code: "range(0, 1, 0);\n", message: "range step must be finite and not 0", line: 1},
+		{name: "stack overflow", code: "fun f() { f(); }\nf();\n", message: "maximum call depth exceeded", line: 1},
+		{name: "uncaught exception", code: "try {\nthrow \"boom\";\n} finally {\nprint 1;\n}\n", message: "boom", line: 2},
+		{name: "uncaught error thrown again", code: "var e;\ntry { nil(); } catch (c) { e = c; }\nthrow e;\n", message: "can only call functions and classes", line: 2},
+		{name: "property of a caught error", code: "try { nil(); } catch (e) {\nprint e.code;\n}\n", message: "undefined property 'code'", line: 2},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := NewVM(Config{Stdout: &bytes.Buffer{}}).Interpret(compile(t, tc.code))
+			require.Error(t, err)
+			require.IsType(t, &RuntimeError{}, err)
+			assert.Equal(t, tc.message, err.Error())
+			assert.Equal(t, tc.line, err.(*RuntimeError).Line)
+		})
+	}
+}
+
+func TestVM_StackTrace(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name  string
+		code  string
+		trace []StackFrame
+	}{
+		{
+			name:  "top-level error",
+			code:  "print 1;\nprint -nil;\n",
+			trace: []StackFrame{{Function: "script", Line: 2}},
+		},
+		{
+			name: "nested calls",
+			code: "fun inner() {\nreturn nil + 1;\n}\nfun outer() {\nreturn inner();\n}\nclass A {\ninit() { outer(); }\n}\n\nA();\n",
+			trace: []StackFrame{
+				{Function: "inner", Line: 2},
+				{Function: "outer", Line: 5},
+				{Function: "init", Line: 8},
+				{Function: "script", Line: 11},
+			},
+		},
+		{
+			name: "natives have no frame",
+			code: "fun f() {\nreturn len(1);\n}\nf();\n",
+			trace: []StackFrame{
+				{Function: "f", Line: 2},
+				{Function: "script", Line: 4},
+			},
+		},
+		{
+			name: "exception passing through finally blocks",
+			code: "fun f() {\ntry { g(); } finally { print 1; }\n}\nfun g() {\nthrow \"boom\";\n}\ntry {\nf();\n} finally {\nprint 2;\n}\n",
+			trace: []StackFrame{
+				{Function: "g", Line: 5},
+				{Function: "f", Line: 2},
+				{Function: "script", Line: 8},
+			},
+		},
+		{
+			name: "caught error thrown again",
+			code: "fun f() {\nnil();\n}\nfun rethrow(e) {\nthrow e;\n}\ntry { f(); } catch (e) {\nrethrow(e);\n}\n",
+			trace: []StackFrame{
+				{Function: "rethrow", Line: 5},
+				{Function: "script", Line: 8},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := NewVM(Config{Stdout: &bytes.Buffer{}}).Interpret(compile(t, tc.code))
+			require.IsType(t, &RuntimeError{}, err)
+			assert.Equal(t, tc.trace, err.(*RuntimeError).Trace)
+		})
+	}
+}
+
+func TestVM_GlobalsPersistBetweenPrograms(t *testing.T) {
+	out := &bytes.Buffer{}
+	vm := NewVM(Config{Stdout: out})
+
+	require.NoError(t, vm.Interpret(compile(t, "var a = \"a\";\nfun f() { return a + \"b\"; }\n")))
+	require.Error(t, vm.Interpret(compile(t, "print nil + 1;\n")))
+	require.NoError(t, vm.Interpret(compile(t, "print f();\n")))
+	assert.Equal(t, "ab\n", out.String())
+}
+
+func TestVM_Host(t *testing.T) {
+	t.Parallel()
+
+	stdout := &bytes.Buffer{}
+	vm := NewVM(Config{Stdout: stdout, GC: GCConfig{Stress: true}})
+	vm.DefineNative("double", 1, func(arguments []any) (any, error) {
+		n, ok := arguments[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("double expects a number")
+		}
+		return int(n) * 2, nil
+	})
+	vm.DefineNative("greet", 1, func(arguments []any) (any, error) {
+		return "hi " + arguments[0].(string), nil
+	})
+	require.NoError(t, vm.SetGlobal("limit", 3))
+	require.NoError(t, vm.SetGlobal("name", "rule"))
+	assert.EqualError(t, vm.SetGlobal("bad", struct{}{}), "unsupported value type struct {}")
+
+	require.NoError(t, vm.Interpret(compile(t, "var total = double(limit) + 1;\nprint greet(name);\nvar xs = [1];\n")))
+	assert.Equal(t, "hi rule\n", stdout.String())
+
+	total, err := vm.GetGlobal("total")
+	assert.NoError(t, err)
+	assert.Equal(t, 7.0, total)
+
+	// objects other than strings are opaque, they can be handed back to the scripts
+	xs, err := vm.GetGlobal("xs")
+	require.NoError(t, err)
+	require.NoError(t, vm.SetGlobal("ys", xs))
+	require.NoError(t, vm.Interpret(compile(t, "push(ys, 2);\nprint xs;\n")))
+	assert.Equal(t, "hi rule\n[1, 2]\n", stdout.String())
+
+	_, err = vm.GetGlobal("missing")
+	assert.EqualError(t, err, "undefined variable 'missing'")
+
+	err = vm.Interpret(compile(t, "double(\"x\");\n"))
+	assert.EqualError(t, err, "double expects a number")
+	assert.Equal(t, 1, err.(*RuntimeError).Line)
+}
+
+func TestVM_MalformedBytecode(t *testing.T) {
+	t.Parallel()
+
+	// Decode accepts these chunks, the stack is fine, but the values have the wrong type
+	cases := []struct {
+		name    string
+		code    []compiler.OpCode
+		message string
+	}{
+		{
+			name:    "method on nil",
+			code:    []compiler.OpCode{compiler.OpNil, compiler.OpNil, compiler.OpMethod, 0, 0, compiler.OpReturn},
+			message: "OP_METHOD expects a class and a method",
+		},
+		{
+			name:    "inherit into nil",
+			code:    []compiler.OpCode{compiler.OpClass, 0, 0, compiler.OpNil, compiler.OpInherit, compiler.OpReturn},
+			message: "OP_INHERIT expects a class",
+		},
+		{
+			name:    "super of nil",
+			code:    []compiler.OpCode{compiler.OpNil, compiler.OpNil, compiler.OpGetSuper, 0, 0, compiler.OpReturn},
+			message: "superclass must be a class",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			chunk := compiler.NewChunk()
+			chunk.Constants = append(chunk.Constants, "m")
+			for _, b := range tc.code {
+				chunk.Write(byte(b), 1, compiler.Span{})
+			}
+			buf := &bytes.Buffer{}
+			require.NoError(t, compiler.Encode(buf, &compiler.Function{Chunk: chunk}))
+			function, err := compiler.Decode(buf.Bytes())
+			require.NoError(t, err)
+
+			err = NewVM(Config{Stdout: &bytes.Buffer{}}).Interpret(function)
+			var runtimeErr *RuntimeError
+			require.ErrorAs(t, err, &runtimeErr)
+			assert.EqualError(t, err, tc.message)
+		})
+	}
+}
+
+func TestVM_Limits(t *testing.T) {
+	t.Parallel()
+
+	loop := "while (true) {}\n"
+
+	t.Run("max steps", func(t *testing.T) {
+		t.Parallel()
+
+		err := NewVM(Config{MaxSteps: 100}).Interpret(compile(t, loop))
+		assert.ErrorIs(t, err, ErrStepLimitExceeded)
+	})
+
+	t.Run("limits can't be caught", func(t *testing.T) {
+		t.Parallel()
+
+		out := &bytes.Buffer{}
+		err := NewVM(Config{Stdout: out, MaxSteps: 100}).Interpret(compile(t, "try { while (true) {} } catch (e) { print e; } finally { print 1; }\n"))
+		assert.ErrorIs(t, err, ErrStepLimitExceeded)
+
+		err = NewVM(Config{Stdout: out, MaxCallDepth: 5}).Interpret(compile(t, "fun f() { try { f(); } finally { print 2; } }\nf();\n"))
+		assert.ErrorIs(t, err, ErrCallDepthExceeded)
+		assert.Empty(t, out.String())
+	})
+
+	t.Run("max call depth", func(t *testing.T) {
+		t.Parallel()
+
+		code := "fun f(n) { if (n > 0) f(n - 1); }\nf(10);\n"
+		assert.NoError(t, NewVM(Config{MaxCallDepth: 20}).Interpret(compile(t, code)))
+		err := NewVM(Config{MaxCallDepth: 5}).Interpret(compile(t, code))
+		assert.ErrorIs(t, err, ErrCallDepthExceeded)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err := NewVM(Config{}).InterpretContext(ctx, compile(t, loop))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestVM_GC(t *testing.T) {
+	t.Parallel()
+
+	t.Run("stress collects before every allocation", func(t *testing.T) {
+		t.Parallel()
+
+		vm := NewVM(Config{Stdout: &bytes.Buffer{}, GC: GCConfig{Stress: true}})
+		before := vm.GCStats()
+		require.NoError(t, vm.Interpret(compile(t, "var a = \"a\" + \"b\";\n")))
+		after := vm.GCStats()
+		// the loaded script, its two strings, its closure and the concatenated string
+		assert.Equal(t, 5, after.ObjectsAllocated-before.ObjectsAllocated)
+		assert.Equal(t, before.Collections+2, after.Collections)
+	})
+
+	t.Run("unreachable objects are freed", func(t *testing.T) {
+		t.Parallel()
+
+		out := &bytes.Buffer{}
+		vm := NewVM(Config{Stdout: out, GC: GCConfig{InitialHeap: 4096}})
+		code := "class A {}\nvar keep = A();\nkeep.name = \"k\" + \"eep\";\n" +
+			"fun f() {}\nfor (var i = 0; i < 500; i = i + 1) { var a = A(); a.f = f; a.g = a.f; }\n"
+		require.NoError(t, vm.Interpret(compile(t, code)))
+
+		stats := vm.GCStats()
+		assert.Greater(t, stats.Collections, 0)
+		assert.LessOrEqual(t, stats.HeapBytes, stats.NextCollection)
+
+		// every iteration leaves an instance behind
+		vm.CollectGarbage()
+		stats = vm.GCStats()
+		assert.GreaterOrEqual(t, stats.ObjectsFreed, 500)
+		assert.Equal(t, stats.ObjectsAllocated-stats.ObjectsFreed, stats.HeapObjects)
+
+		// interned strings that survived stay identical to newly created ones
+		require.NoError(t, vm.Interpret(compile(t, "print keep.name == \"ke\" + \"ep\";\nprint keep;\n")))
+		assert.Equal(t, "true\nA instance\n", out.String())
+	})
+
+	t.Run("growth factor", func(t *testing.T) {
+		t.Parallel()
+
+		vm := NewVM(Config{GC: GCConfig{InitialHeap: 1, GrowthFactor: 4}})
+		vm.CollectGarbage()
+		stats := vm.GCStats()
+		assert.Equal(t, stats.HeapBytes*4, stats.NextCollection)
+	})
+}
+
+func TestVM_Trace(t *testing.T) {
+	out, trace := &bytes.Buffer{}, &bytes.Buffer{}
+	err := NewVM(Config{Stdout: out, Trace: trace}).Interpret(compile(t, "print 1 + 2;\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "3\n", out.String())
+	assert.Equal(t, `          [ <script> ]
+0000    1 OP_CONSTANT         0 1
+          [ <script> ][ 1 ]
+0003    | OP_CONSTANT         1 2
+          [ <script> ][ 1 ][ 2 ]
+0006    | OP_ADD
+          [ <script> ][ 3 ]
+0007    | OP_PRINT
+          [ <script> ]
+0008    | OP_NIL
+          [ <script> ][ nil ]
+0009    | OP_RETURN
+`, trace.String())
+}
@@ -36,19 +36,36 @@ var (
 	expressionRules = []string{
 		"Assignment          : name tokens.Token, value Expression",
 		"Binary              : left Expression, operator tokens.Token, right Expression",
+		"Call                : callee Expression, paren tokens.Token, arguments []Expression",
 		"Unary               : operator tokens.Token, right Expression",
 		"Variable            : name tokens.Token",
+		"Get                 : object Expression, name tokens.Token",
+		"Set                 : object Expression, name tokens.Token, value Expression",
+		"This                : keyword tokens.Token",
+		"Super               : keyword tokens.Token, method tokens.Token",
 		"Logical             : left Expression, operator tokens.Token, right Expression",
 		"Literal             : value any",
 		"Grouping            : expression Expression",
+		"List                : bracket tokens.Token, elements []Expression",
+		"Subscript           : object Expression, bracket tokens.Token, index Expression",
+		"SubscriptSet        : object Expression, bracket tokens.Token, index Expression, value Expression",
+		"MapLiteral          : brace tokens.Token, keys []Expression, values []Expression",
 	}
 	statementRules = []string{
 		"BlockStatement      : statements []Statement",
+		"ClassStatement      : name tokens.Token, superclass Variable, methods []FunctionStatement",
 		"ExpressionStatement : expression Expression",
+		"FunctionStatement   : name tokens.Token, params []tokens.Token, body []Statement",
 		"IfStatement         : condition Expression, thenStatement Statement, elseStatement Statement",
 		"PrintStatement      : expression Expression",
+		"ReturnStatement     : keyword tokens.Token, value Expression",
 		"VarStatement        : name tokens.Token, initializer Expression",
-		"WhileStatement      : condition Expression, body Statement",
+		"WhileStatement      : condition Expression, body Statement, increment Expression",
+		"ForInStatement      : keyword tokens.Token, name tokens.Token, iterable Expression, body Statement",
+		"BreakStatement      : keyword tokens.Token",
+		"ContinueStatement   : keyword tokens.Token",
+		"ThrowStatement      : keyword tokens.Token, value Expression",
+		"TryStatement        : keyword tokens.Token, body []Statement, name tokens.Token, catchBody []Statement, finallyBody []Statement",
 	}
 )
 